@@ -30,6 +30,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"path/filepath"
@@ -39,6 +40,8 @@ import (
 	"github.com/apex/log"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/docker/docker/api/types"
+	dockerregistry "github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/docker/docker/pkg/stringid"
 	controlapi "github.com/moby/buildkit/api/services/control"
@@ -55,6 +58,7 @@ import (
 	"github.com/buildtool/build-tools/pkg/ci"
 	"github.com/buildtool/build-tools/pkg/config"
 	"github.com/buildtool/build-tools/pkg/docker"
+	"github.com/buildtool/build-tools/pkg/registry"
 )
 
 type Args struct {
@@ -64,6 +68,7 @@ type Args struct {
 	NoLogin    bool     `help:"disable login to docker registry" default:"false" `
 	NoPull     bool     `help:"disable pulling latest from docker registry" default:"false"`
 	Platform   string   `help:"specify target platform to build" default:""`
+	Parallel   bool     `help:"build configured variants concurrently" default:"false"`
 }
 
 func DoBuild(dir string, buildArgs Args) error {
@@ -103,7 +108,34 @@ func build(client docker.Client, dir string, buildVars Args) error {
 
 	currentRegistry := cfg.CurrentRegistry()
 	log.Debugf("Using registry <green>%s</green>\n", currentRegistry.Name())
+	if cfg.Build.Go != nil {
+		var auths map[string]dockerregistry.AuthConfig
+		if buildVars.NoLogin {
+			log.Debugf("Login <yellow>disabled</yellow>\n")
+		} else {
+			log.Debugf("Authenticating against registry <green>%s</green>\n", currentRegistry.Name())
+			if err := registry.Authenticate(currentRegistry); err != nil {
+				return err
+			}
+			auths, err = buildAuths(cfg, currentRegistry)
+			if err != nil {
+				log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+				return err
+			}
+		}
+		return buildGo(dir, buildVars, cfg, currentCI, currentRegistry.RegistryUrl(), docker.NewKeychain(auths))
+	}
+	engine, err := docker.DetectEngine(context.Background(), client)
+	if err != nil {
+		log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+		return err
+	}
+	log.Debugf("Using engine <green>%s</green> at <green>%s</green>\n", engine, engine.Host)
+	if !engine.BuildKit {
+		log.Warnf("<yellow>%s does not support BuildKit sessions, falling back to the classic builder</yellow>\n", engine.Name)
+	}
 	var authenticator docker.Authenticator
+	var auths map[string]dockerregistry.AuthConfig
 	if buildVars.NoLogin {
 		log.Debugf("Login <yellow>disabled</yellow>\n")
 	} else {
@@ -111,15 +143,41 @@ func build(client docker.Client, dir string, buildVars Args) error {
 		if err := currentRegistry.Login(client); err != nil {
 			return err
 		}
-		authenticator = docker.NewAuthenticator(currentRegistry.RegistryUrl(), currentRegistry.GetAuthConfig())
+		auths, err = buildAuths(cfg, currentRegistry)
+		if err != nil {
+			log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+			return err
+		}
+		authenticator = docker.NewAuthenticator(auths)
 	}
 
-	content, err := os.ReadFile(filepath.Join(dir, buildVars.Dockerfile))
-	if err != nil {
-		log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
-		return err
+	mirror := newMirrors(cfg.Build.Mirrors, docker.NewKeychain(auths))
+	if mirror != nil && !engine.BuildKit {
+		log.Warnf("<yellow>Registry mirrors require BuildKit, using upstream registries</yellow>\n")
+		mirror = nil
+	}
+
+	variants := cfg.BuildVariants(buildVars.Dockerfile)
+	stages := make([][]string, len(variants))
+	dockerfileDirs := make([]string, len(variants))
+	for i, variant := range variants {
+		content, err := os.ReadFile(filepath.Join(dir, variant.Dockerfile))
+		if err != nil {
+			log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+			return err
+		}
+		stages[i] = docker.FindStages(string(content))
+		dockerfileDirs[i] = dir
+		if rewritten := mirror.dockerfile(string(content), stages[i]); rewritten != string(content) {
+			tmp, err := mirroredDockerfile(dir, variant.Dockerfile, rewritten)
+			if err != nil {
+				log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+				return err
+			}
+			defer func() { _ = os.RemoveAll(tmp) }()
+			dockerfileDirs[i] = tmp
+		}
 	}
-	stages := docker.FindStages(string(content))
 	if !ci.IsValid(currentCI) {
 		return fmt.Errorf("commit and/or branch information is <red>missing</red> (perhaps you're not in a Git repository or forgot to set environment variables?)")
 	}
@@ -127,18 +185,6 @@ func build(client docker.Client, dir string, buildVars Args) error {
 	commit := currentCI.Commit()
 	branch := currentCI.BranchReplaceSlash()
 	log.Debugf("Using build variables commit <green>%s</green> on branch <green>%s</green>\n", commit, branch)
-	var tags []string
-	branchTag := docker.Tag(currentRegistry.RegistryUrl(), currentCI.BuildName(), branch)
-	latestTag := docker.Tag(currentRegistry.RegistryUrl(), currentCI.BuildName(), "latest")
-	tags = append(tags, []string{
-		docker.Tag(currentRegistry.RegistryUrl(), currentCI.BuildName(), commit),
-		branchTag,
-	}...)
-	if currentCI.Branch() == "master" || currentCI.Branch() == "main" {
-		tags = append(tags, latestTag)
-	}
-
-	caches := []string{branchTag, latestTag}
 
 	buildArgs := map[string]*string{
 		"BUILDKIT_INLINE_CACHE": aws.String("1"),
@@ -160,19 +206,108 @@ func build(client docker.Client, dir string, buildVars Args) error {
 		}
 	}
 
+	eg, ctx := errgroup.WithContext(context.Background())
+	if !buildVars.Parallel {
+		eg.SetLimit(1)
+	}
+	for i, variant := range variants {
+		vars := buildVars
+		vars.Dockerfile = variant.Dockerfile
+		eg.Go(func() error {
+			return buildVariant(ctx, client, dir, dockerfileDirs[i], vars, cfg, currentCI, currentRegistry.RegistryUrl(), variant, stages[i], variantBuildArgs(buildArgs, variant), authenticator, mirror, engine.BuildKit)
+		})
+	}
+	return eg.Wait()
+}
+
+// buildAuths returns the credentials of the current registry and the registries listed in build, keyed by host
+func buildAuths(cfg *config.Config, currentRegistry registry.Registry) (map[string]dockerregistry.AuthConfig, error) {
+	registries, err := cfg.BuildRegistries()
+	if err != nil {
+		return nil, err
+	}
+	auths := map[string]dockerregistry.AuthConfig{}
+	for _, r := range registries {
+		if err := registry.Authenticate(r); err != nil {
+			return nil, err
+		}
+		log.Debugf("Using credentials of registry <green>%s</green> for <green>%s</green>\n", r.Name(), registry.Host(r))
+		auths[registry.Host(r)] = r.GetAuthConfig()
+	}
+	auths[registry.Host(currentRegistry)] = currentRegistry.GetAuthConfig()
+	return auths, nil
+}
+
+// buildVariant builds the stages and the image of the variant, stopping when ctx is cancelled
+// because another variant failed
+func buildVariant(ctx context.Context, client docker.Client, dir, dockerfileDir string, buildVars Args, cfg *config.Config, currentCI ci.CI, registryUrl string, variant config.Variant, stages []string, buildArgs map[string]*string, authenticator docker.Authenticator, mirror *mirrors, buildKit bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if variant.Suffix != "" {
+		log.Infof("Building variant <green>%s</green>\n", variant.Suffix)
+	}
+	commit := currentCI.Commit()
+	branch := currentCI.BranchReplaceSlash()
+
+	var tags []string
+	branchTag := docker.Tag(registryUrl, currentCI.BuildName(), branch+variant.Suffix)
+	latestTag := docker.Tag(registryUrl, currentCI.BuildName(), "latest"+variant.Suffix)
+	tags = append(tags, []string{
+		docker.Tag(registryUrl, currentCI.BuildName(), commit+variant.Suffix),
+		branchTag,
+	}...)
+	if currentCI.Branch() == "master" || currentCI.Branch() == "main" {
+		tags = append(tags, latestTag)
+	}
+
+	caches := mirror.images(branchTag, latestTag)
+
 	for _, stage := range stages {
-		tag := docker.Tag(currentRegistry.RegistryUrl(), currentCI.BuildName(), stage)
-		caches = append([]string{tag}, caches...)
-		err := buildStage(client, dir, buildVars, buildArgs, []string{tag}, caches, stage, authenticator)
+		tag := docker.Tag(registryUrl, currentCI.BuildName(), stage+variant.Suffix)
+		caches = append(mirror.images(tag), caches...)
+		err := buildStage(ctx, client, dir, dockerfileDir, buildVars, buildArgs, []string{tag}, caches, stage, variantExport(cfg.StageExport(stage), variant.Suffix), authenticator, buildKit)
 		if err != nil {
 			return err
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 	}
 
-	return buildStage(client, dir, buildVars, buildArgs, tags, caches, "", authenticator)
+	return buildStage(ctx, client, dir, dockerfileDir, buildVars, buildArgs, tags, caches, variant.Target, nil, authenticator, buildKit)
 }
 
-func buildStage(client docker.Client, dir string, buildVars Args, buildArgs map[string]*string, tags []string, caches []string, stage string, authenticator docker.Authenticator) error {
+// variantExport returns the export with the suffix of the variant appended to the destination,
+// before the extension of files, so that the variants don't overwrite each others exports
+func variantExport(export *config.Export, suffix string) *config.Export {
+	if export == nil || suffix == "" {
+		return export
+	}
+	dest := export.Dest + suffix
+	if export.Type != config.ExportLocal {
+		ext := filepath.Ext(export.Dest)
+		dest = strings.TrimSuffix(export.Dest, ext) + suffix + ext
+	}
+	return &config.Export{Type: export.Type, Dest: dest}
+}
+
+// variantBuildArgs returns a copy of the common build-args with the variant specific ones added
+func variantBuildArgs(common map[string]*string, variant config.Variant) map[string]*string {
+	buildArgs := make(map[string]*string, len(common)+len(variant.BuildArgs))
+	for key, value := range common {
+		buildArgs[key] = value
+	}
+	for key, value := range variant.BuildArgs {
+		buildArgs[key] = &value
+	}
+	return buildArgs
+}
+
+func buildStage(ctx context.Context, client docker.Client, dir, dockerfileDir string, buildVars Args, buildArgs map[string]*string, tags []string, caches []string, stage string, export *config.Export, authenticator docker.Authenticator, buildKit bool) error {
+	if !buildKit {
+		return buildClassic(ctx, client, dir, buildVars, buildArgs, tags, caches, stage, export)
+	}
 	s := setupSession(dir)
 	if authenticator != nil {
 		s.Allow(authenticator)
@@ -181,13 +316,26 @@ func buildStage(client docker.Client, dir string, buildVars Args, buildArgs map[
 	if err != nil {
 		return err
 	}
+	dockerfileFs := fs
+	if dockerfileDir != dir {
+		if dockerfileFs, err = fsutil.NewFS(dockerfileDir); err != nil {
+			return err
+		}
+	}
 	s.Allow(filesync.NewFSSyncProvider(filesync.StaticDirSource{
 		"context":    fs,
-		"dockerfile": fs,
+		"dockerfile": dockerfileFs,
 	}))
-	s.Allow(filesync.NewFSSyncTarget(filesync.WithFSSyncDir(0, "exported")))
+	var outputs []types.ImageBuildOutput
+	if export != nil {
+		s.Allow(filesync.NewFSSyncTarget(exportTarget(*export)))
+		outputs = append(outputs, types.ImageBuildOutput{
+			Type:  export.Type,
+			Attrs: map[string]string{},
+		})
+	}
 
-	eg, ctx := errgroup.WithContext(context.Background())
+	eg, ctx := errgroup.WithContext(ctx)
 	dialSession := func(ctx context.Context, proto string, meta map[string][]string) (net.Conn, error) {
 		return client.DialHijack(ctx, "/session", proto, meta)
 	}
@@ -198,20 +346,49 @@ func buildStage(client docker.Client, dir string, buildVars Args, buildArgs map[
 		defer func() { // make sure the Status ends cleanly on build errors
 			_ = s.Close()
 		}()
-		var outputs []types.ImageBuildOutput
-		if strings.HasPrefix(stage, "export") {
-			outputs = append(outputs, types.ImageBuildOutput{
-				Type:  "local",
-				Attrs: map[string]string{},
-			})
-		}
 		sessionID := s.ID()
-		return doBuild(ctx, client, eg, buildVars.Dockerfile, buildArgs, tags, caches, stage, !buildVars.NoPull, sessionID, outputs, buildVars.Platform)
+		return doBuild(ctx, client, eg, buildVars.Dockerfile, buildArgs, tags, caches, stage, !buildVars.NoPull, nil, sessionID, outputs, buildVars.Platform, displayMode(buildVars))
+	})
+	return eg.Wait()
+}
+
+// buildClassic builds without a BuildKit session, sending the build context as a tar archive,
+// for engines not supporting sessions
+func buildClassic(ctx context.Context, client docker.Client, dir string, buildVars Args, buildArgs map[string]*string, tags []string, caches []string, stage string, export *config.Export) error {
+	if export != nil {
+		log.Warnf("<yellow>Exporting stage '%s' requires BuildKit, skipping export</yellow>\n", stage)
+	}
+	excludes, err := docker.ParseDockerignore(dir, buildVars.Dockerfile)
+	if err != nil {
+		return err
+	}
+	buildContext, err := archive.TarWithOptions(dir, &archive.TarOptions{ExcludePatterns: excludes})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = buildContext.Close() }()
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		return doBuild(ctx, client, eg, buildVars.Dockerfile, buildArgs, tags, caches, stage, !buildVars.NoPull, buildContext, "", nil, buildVars.Platform, displayMode(buildVars))
 	})
 	return eg.Wait()
 }
 
-func doBuild(ctx context.Context, dkrClient docker.Client, eg *errgroup.Group, dockerfile string, args map[string]*string, tags, caches []string, target string, pullParent bool, sessionID string, outputs []types.ImageBuildOutput, platform string) (finalErr error) {
+// exportTarget creates the session target receiving the exported content,
+// a directory for local exports and a single file for tar and OCI exports
+func exportTarget(export config.Export) filesync.FSSyncTarget {
+	if export.Type == config.ExportLocal {
+		return filesync.WithFSSyncDir(0, export.Dest)
+	}
+	return filesync.WithFSSync(0, func(map[string]string) (io.WriteCloser, error) {
+		if err := os.MkdirAll(filepath.Dir(export.Dest), 0755); err != nil {
+			return nil, err
+		}
+		return os.Create(export.Dest)
+	})
+}
+
+func doBuild(ctx context.Context, dkrClient docker.Client, eg *errgroup.Group, dockerfile string, args map[string]*string, tags, caches []string, target string, pullParent bool, buildContext io.Reader, sessionID string, outputs []types.ImageBuildOutput, platform string, mode progressui.DisplayMode) (finalErr error) {
 	buildID := stringid.GenerateRandomID()
 	options := types.ImageBuildOptions{
 		BuildArgs:     args,
@@ -230,10 +407,14 @@ func doBuild(ctx context.Context, dkrClient docker.Client, eg *errgroup.Group, d
 		Version:       types.BuilderBuildKit,
 		Platform:      platform,
 	}
+	if buildContext != nil {
+		options.RemoteContext = ""
+		options.Version = types.BuilderV1
+	}
 	logVerbose(options)
 	var response types.ImageBuildResponse
 	var err error
-	response, err = dkrClient.ImageBuild(context.Background(), nil, options)
+	response, err = dkrClient.ImageBuild(context.Background(), buildContext, options)
 	if err != nil {
 		return err
 	}
@@ -252,7 +433,7 @@ func doBuild(ctx context.Context, dkrClient docker.Client, eg *errgroup.Group, d
 
 	tracer := newTracer()
 
-	displayStatus(os.Stderr, tracer.displayCh, eg)
+	displayStatus(os.Stderr, mode, tracer.displayCh, eg)
 	defer close(tracer.displayCh)
 
 	buf := &bytes.Buffer{}
@@ -286,9 +467,17 @@ func doBuild(ctx context.Context, dkrClient docker.Client, eg *errgroup.Group, d
 	return nil
 }
 
-func displayStatus(out *os.File, displayCh chan *client.SolveStatus, eg *errgroup.Group) {
+// displayMode uses plain output for concurrent builds since they can't share a TTY display
+func displayMode(buildVars Args) progressui.DisplayMode {
+	if buildVars.Parallel {
+		return progressui.PlainMode
+	}
+	return progressui.AutoMode
+}
+
+func displayStatus(out *os.File, mode progressui.DisplayMode, displayCh chan *client.SolveStatus, eg *errgroup.Group) {
 	// not using shared context to not disrupt display but let it finish reporting errors
-	display, err := progressui.NewDisplay(out, progressui.AutoMode)
+	display, err := progressui.NewDisplay(out, mode)
 	if err != nil {
 		eg.Go(func() error {
 			return err
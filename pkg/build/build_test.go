@@ -34,12 +34,14 @@ import (
 
 	"github.com/apex/log"
 	"github.com/docker/docker/api/types"
+	dockerregistry "github.com/docker/docker/api/types/registry"
 	mocks "gitlab.com/unboundsoftware/apex-mocks"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/buildtool/build-tools/pkg"
 	"github.com/buildtool/build-tools/pkg/args"
+	"github.com/buildtool/build-tools/pkg/config"
 	"github.com/buildtool/build-tools/pkg/docker"
 )
 
@@ -116,6 +118,7 @@ func TestBuild_NoRegistry(t *testing.T) {
 	assert.NoError(t, err)
 	logMock.Check(t, []string{"debug: Using CI <green>Gitlab</green>\n",
 		"debug: Using registry <green>No docker registry</green>\n",
+		"debug: Using engine <green>Docker 27.5.0</green> at <green>unix:///var/run/docker.sock</green>\n",
 		"debug: Authenticating against registry <green>No docker registry</green>\n",
 		"debug: Authentication <yellow>not supported</yellow> for registry <green>No docker registry</green>\n",
 		"debug: Using build variables commit <green>abc123</green> on branch <green>feature1</green>\n",
@@ -149,6 +152,7 @@ func TestBuild_LoginError(t *testing.T) {
 	logMock.Check(t, []string{
 		"debug: Using CI <green>Gitlab</green>\n",
 		"debug: Using registry <green>Dockerhub</green>\n",
+		"debug: Using engine <green>Docker 27.5.0</green> at <green>unix:///var/run/docker.sock</green>\n",
 		"debug: Authenticating against registry <green>Dockerhub</green>\n",
 		"error: Unable to login\n"})
 }
@@ -176,6 +180,7 @@ func TestBuild_NoCI(t *testing.T) {
 	logMock.Check(t, []string{
 		"debug: Using CI <green>none</green>\n",
 		"debug: Using registry <green>Dockerhub</green>\n",
+		"debug: Using engine <green>Docker 27.5.0</green> at <green>unix:///var/run/docker.sock</green>\n",
 		"debug: Authenticating against registry <green>Dockerhub</green>\n",
 		"debug: Logged in\n",
 	})
@@ -207,6 +212,7 @@ func TestBuild_BuildError(t *testing.T) {
 	logMock.Check(t, []string{
 		"debug: Using CI <green>Gitlab</green>\n",
 		"debug: Using registry <green>Dockerhub</green>\n",
+		"debug: Using engine <green>Docker 27.5.0</green> at <green>unix:///var/run/docker.sock</green>\n",
 		"debug: Authenticating against registry <green>Dockerhub</green>\n",
 		"debug: Logged in\n",
 		"debug: Using build variables commit <green>abc123</green> on branch <green>feature1</green>\n",
@@ -240,6 +246,7 @@ func TestBuild_BuildResponseError(t *testing.T) {
 	logMock.Check(t, []string{
 		"debug: Using CI <green>Gitlab</green>\n",
 		"debug: Using registry <green>Dockerhub</green>\n",
+		"debug: Using engine <green>Docker 27.5.0</green> at <green>unix:///var/run/docker.sock</green>\n",
 		"debug: Authenticating against registry <green>Dockerhub</green>\n",
 		"debug: Logged in\n",
 		"debug: Using build variables commit <green>abc123</green> on branch <green>feature1</green>\n",
@@ -273,6 +280,7 @@ func TestBuild_ErrorOutput(t *testing.T) {
 	logMock.Check(t, []string{
 		"debug: Using CI <green>Gitlab</green>\n",
 		"debug: Using registry <green>Dockerhub</green>\n",
+		"debug: Using engine <green>Docker 27.5.0</green> at <green>unix:///var/run/docker.sock</green>\n",
 		"debug: Authenticating against registry <green>Dockerhub</green>\n",
 		"debug: Logged in\n",
 		"debug: Using build variables commit <green>abc123</green> on branch <green>feature1</green>\n",
@@ -308,6 +316,7 @@ func TestBuild_ValidOutput(t *testing.T) {
 	logMock.Check(t, []string{
 		"debug: Using CI <green>Gitlab</green>\n",
 		"debug: Using registry <green>Dockerhub</green>\n",
+		"debug: Using engine <green>Docker 27.5.0</green> at <green>unix:///var/run/docker.sock</green>\n",
 		"debug: Authenticating against registry <green>Dockerhub</green>\n",
 		"debug: Logged in\n",
 		"debug: Using build variables commit <green>abc123</green> on branch <green>feature1</green>\n",
@@ -342,6 +351,7 @@ func TestBuild_BrokenBuildResult(t *testing.T) {
 	logMock.Check(t, []string{
 		"debug: Using CI <green>Gitlab</green>\n",
 		"debug: Using registry <green>Dockerhub</green>\n",
+		"debug: Using engine <green>Docker 27.5.0</green> at <green>unix:///var/run/docker.sock</green>\n",
 		"debug: Authenticating against registry <green>Dockerhub</green>\n",
 		"debug: Logged in\n",
 		"debug: Using build variables commit <green>abc123</green> on branch <green>feature1</green>\n",
@@ -402,6 +412,7 @@ func TestBuild_WithStrangeBuildArg(t *testing.T) {
 	logMock.Check(t, []string{
 		"debug: Using CI <green>Gitlab</green>\n",
 		"debug: Using registry <green>Dockerhub</green>\n",
+		"debug: Using engine <green>Docker 27.5.0</green> at <green>unix:///var/run/docker.sock</green>\n",
 		"debug: Authenticating against registry <green>Dockerhub</green>\n",
 		"debug: Logged in\n",
 		"debug: Using build variables commit <green>sha</green> on branch <green>master</green>\n",
@@ -436,6 +447,7 @@ func TestBuild_WithPlatform(t *testing.T) {
 		"info: building for platform <green>linux/amd64</green>\n",
 		"debug: Using CI <green>Gitlab</green>\n",
 		"debug: Using registry <green>Dockerhub</green>\n",
+		"debug: Using engine <green>Docker 27.5.0</green> at <green>unix:///var/run/docker.sock</green>\n",
 		"debug: Authenticating against registry <green>Dockerhub</green>\n",
 		"debug: Logged in\n",
 		"debug: Using build variables commit <green>sha</green> on branch <green>master</green>\n",
@@ -466,6 +478,7 @@ func TestBuild_WithSkipLogin(t *testing.T) {
 	logMock.Check(t, []string{
 		"debug: Using CI <green>Gitlab</green>\n",
 		"debug: Using registry <green>Dockerhub</green>\n",
+		"debug: Using engine <green>Docker 27.5.0</green> at <green>unix:///var/run/docker.sock</green>\n",
 		"debug: Login <yellow>disabled</yellow>\n",
 		"debug: Using build variables commit <green>sha</green> on branch <green>master</green>\n",
 		"debug: performing docker build with options (auths removed):\ntags:\n    - repo/reponame:sha\n    - repo/reponame:master\n    - repo/reponame:latest\nsuppressoutput: false\nremotecontext: client-session\nnocache: false\nremove: true\nforceremove: false\npullparent: true\nisolation: \"\"\ncpusetcpus: \"\"\ncpusetmems: \"\"\ncpushares: 0\ncpuquota: 0\ncpuperiod: 0\nmemory: 0\nmemoryswap: -1\ncgroupparent: \"\"\nnetworkmode: \"\"\nshmsize: 268435456\ndockerfile: Dockerfile\nulimits: []\nbuildargs:\n    BUILDKIT_INLINE_CACHE: \"1\"\n    CI_BRANCH: master\n    CI_COMMIT: sha\nauthconfigs: {}\ncontext: null\nlabels: {}\nsquash: false\ncachefrom:\n    - repo/reponame:master\n    - repo/reponame:latest\nsecurityopt: []\nextrahosts: []\ntarget: \"\"\nsessionid: \"\"\nplatform: \"\"\nversion: \"2\"\nbuildid: \"\"\noutputs: []\n\n",
@@ -511,6 +524,7 @@ func TestBuild_FeatureBranch(t *testing.T) {
 	assert.Equal(t, []string{"repo/reponame:abc123", "repo/reponame:feature1"}, client.BuildOptions[0].Tags)
 	logMock.Check(t, []string{"debug: Using CI <green>Gitlab</green>\n",
 		"debug: Using registry <green>Dockerhub</green>\n",
+		"debug: Using engine <green>Docker 27.5.0</green> at <green>unix:///var/run/docker.sock</green>\n",
 		"debug: Authenticating against registry <green>Dockerhub</green>\n",
 		"debug: Logged in\n",
 		"debug: Using build variables commit <green>abc123</green> on branch <green>feature1</green>\n",
@@ -549,6 +563,7 @@ func TestBuild_MasterBranch(t *testing.T) {
 	assert.Equal(t, []string{"repo/reponame:abc123", "repo/reponame:master", "repo/reponame:latest"}, client.BuildOptions[0].Tags)
 	logMock.Check(t, []string{"debug: Using CI <green>Gitlab</green>\n",
 		"debug: Using registry <green>Dockerhub</green>\n",
+		"debug: Using engine <green>Docker 27.5.0</green> at <green>unix:///var/run/docker.sock</green>\n",
 		"debug: Authenticating against registry <green>Dockerhub</green>\n",
 		"debug: Logged in\n",
 		"debug: Using build variables commit <green>abc123</green> on branch <green>master</green>\n",
@@ -587,6 +602,7 @@ func TestBuild_MainBranch(t *testing.T) {
 	assert.Equal(t, []string{"repo/reponame:abc123", "repo/reponame:main", "repo/reponame:latest"}, client.BuildOptions[0].Tags)
 	logMock.Check(t, []string{"debug: Using CI <green>Gitlab</green>\n",
 		"debug: Using registry <green>Dockerhub</green>\n",
+		"debug: Using engine <green>Docker 27.5.0</green> at <green>unix:///var/run/docker.sock</green>\n",
 		"debug: Authenticating against registry <green>Dockerhub</green>\n",
 		"debug: Logged in\n",
 		"debug: Using build variables commit <green>abc123</green> on branch <green>main</green>\n",
@@ -626,6 +642,7 @@ func TestBuild_WithImageName(t *testing.T) {
 	assert.Equal(t, []string{"repo/other:abc123", "repo/other:main", "repo/other:latest"}, client.BuildOptions[0].Tags)
 	logMock.Check(t, []string{"debug: Using CI <green>Gitlab</green>\n",
 		"debug: Using registry <green>Dockerhub</green>\n",
+		"debug: Using engine <green>Docker 27.5.0</green> at <green>unix:///var/run/docker.sock</green>\n",
 		"debug: Authenticating against registry <green>Dockerhub</green>\n",
 		"debug: Logged in\n",
 		"debug: Using build variables commit <green>abc123</green> on branch <green>main</green>\n",
@@ -675,6 +692,7 @@ func TestBuild_Unreadable_Dockerfile(t *testing.T) {
 	logMock.Check(t, []string{
 		"debug: Using CI <green>Gitlab</green>\n",
 		"debug: Using registry <green>Dockerhub</green>\n",
+		"debug: Using engine <green>Docker 27.5.0</green> at <green>unix:///var/run/docker.sock</green>\n",
 		"debug: Authenticating against registry <green>Dockerhub</green>\n",
 		"debug: Logged in\n",
 		fmt.Sprintf("error: <red>read %s: is a directory</red>", dockerfile),
@@ -728,6 +746,7 @@ COPY --from=test file2 .
 	assert.Equal(t, []string{"repo/reponame:test", "repo/reponame:build", "repo/reponame:master", "repo/reponame:latest"}, client.BuildOptions[2].CacheFrom)
 	logMock.Check(t, []string{"debug: Using CI <green>Gitlab</green>\n",
 		"debug: Using registry <green>Dockerhub</green>\n",
+		"debug: Using engine <green>Docker 27.5.0</green> at <green>unix:///var/run/docker.sock</green>\n",
 		"debug: Authenticating against registry <green>Dockerhub</green>\n",
 		"debug: Logged in\n",
 		"debug: Using build variables commit <green>abc123</green> on branch <green>master</green>\n",
@@ -774,6 +793,7 @@ COPY --from=test file2 .
 	logMock.Check(t, []string{
 		"debug: Using CI <green>Gitlab</green>\n",
 		"debug: Using registry <green>Dockerhub</green>\n",
+		"debug: Using engine <green>Docker 27.5.0</green> at <green>unix:///var/run/docker.sock</green>\n",
 		"debug: Authenticating against registry <green>Dockerhub</green>\n",
 		"debug: Logged in\n",
 		"debug: Using build variables commit <green>abc123</green> on branch <green>master</green>\n",
@@ -839,6 +859,7 @@ COPY --from=test file2 .
 	assert.Equal(t, []string{"repo/reponame:export", "repo/reponame:test", "repo/reponame:build", "repo/reponame:master", "repo/reponame:latest"}, client.BuildOptions[3].CacheFrom)
 	logMock.Check(t, []string{"debug: Using CI <green>Gitlab</green>\n",
 		"debug: Using registry <green>Dockerhub</green>\n",
+		"debug: Using engine <green>Docker 27.5.0</green> at <green>unix:///var/run/docker.sock</green>\n",
 		"debug: Authenticating against registry <green>Dockerhub</green>\n",
 		"debug: Logged in\n",
 		"debug: Using build variables commit <green>abc123</green> on branch <green>master</green>\n",
@@ -905,6 +926,7 @@ COPY --from=test file2 .
 	assert.Equal(t, []string{"repo/reponame:export", "repo/reponame:test", "repo/reponame:build", "repo/reponame:master", "repo/reponame:latest"}, client.BuildOptions[3].CacheFrom)
 	logMock.Check(t, []string{"debug: Using CI <green>Gitlab</green>\n",
 		"debug: Using registry <green>Dockerhub</green>\n",
+		"debug: Using engine <green>Docker 27.5.0</green> at <green>unix:///var/run/docker.sock</green>\n",
 		"debug: Authenticating against registry <green>Dockerhub</green>\n",
 		"debug: Logged in\n",
 		"debug: Using build variables commit <green>abc123</green> on branch <green>master</green>\n",
@@ -918,6 +940,186 @@ COPY --from=test file2 .
 		"info: Build successful"})
 }
 
+func TestBuild_ConfiguredExports(t *testing.T) {
+	defer pkg.SetEnv("CI_COMMIT_SHA", "abc123")()
+	defer pkg.SetEnv("CI_PROJECT_NAME", "reponame")()
+	defer pkg.SetEnv("CI_COMMIT_REF_NAME", "master")()
+	defer pkg.SetEnv("DOCKERHUB_NAMESPACE", "repo")()
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	client := &docker.MockDocker{}
+	dockerfile := `
+FROM scratch as build
+RUN echo apa > file
+FROM scratch as test
+RUN echo cepa > file2
+FROM scratch as export
+COPY --from=build file .
+FROM scratch
+COPY --from=build file .
+`
+	defer func() { _ = os.RemoveAll(name) }()
+	_ = write(name, "Dockerfile", dockerfile)
+	_ = write(name, ".buildtools.yaml", `
+build:
+  exports:
+    test:
+      type: tar
+      dest: reports/test.tar
+    export:
+      dest: bin
+`)
+	err := build(client, name, Args{
+		Globals:    args.Globals{},
+		Dockerfile: "Dockerfile",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(client.BuildOptions))
+	assert.Empty(t, client.BuildOptions[0].Outputs)
+	assert.Equal(t, []types.ImageBuildOutput{{Type: "tar", Attrs: map[string]string{}}}, client.BuildOptions[1].Outputs)
+	assert.Equal(t, []types.ImageBuildOutput{{Type: "local", Attrs: map[string]string{}}}, client.BuildOptions[2].Outputs)
+	assert.Empty(t, client.BuildOptions[3].Outputs)
+}
+
+func TestBuild_Variants(t *testing.T) {
+	defer pkg.SetEnv("CI_COMMIT_SHA", "abc123")()
+	defer pkg.SetEnv("CI_PROJECT_NAME", "reponame")()
+	defer pkg.SetEnv("CI_COMMIT_REF_NAME", "main")()
+	defer pkg.SetEnv("DOCKERHUB_NAMESPACE", "repo")()
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	client := &docker.MockDocker{}
+	defer func() { _ = os.RemoveAll(name) }()
+	_ = write(name, "Dockerfile", "FROM alpine")
+	_ = write(name, "Dockerfile.debian", `
+FROM debian as build
+FROM debian as debug
+FROM debian
+`)
+	_ = write(name, ".buildtools.yaml", `
+build:
+  variants:
+    - suffix: -alpine
+      buildArgs:
+        FLAVOUR: alpine
+    - suffix: -debian
+      dockerfile: Dockerfile.debian
+    - suffix: -debug
+      dockerfile: Dockerfile.debian
+      target: debug
+`)
+	err := build(client, name, Args{
+		Globals:    args.Globals{},
+		Dockerfile: "Dockerfile",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 7, len(client.BuildOptions))
+	assert.Equal(t, "Dockerfile", client.BuildOptions[0].Dockerfile)
+	assert.Equal(t, []string{"repo/reponame:abc123-alpine", "repo/reponame:main-alpine", "repo/reponame:latest-alpine"}, client.BuildOptions[0].Tags)
+	assert.Equal(t, []string{"repo/reponame:main-alpine", "repo/reponame:latest-alpine"}, client.BuildOptions[0].CacheFrom)
+	assert.Equal(t, "alpine", *client.BuildOptions[0].BuildArgs["FLAVOUR"])
+	assert.Equal(t, "Dockerfile.debian", client.BuildOptions[1].Dockerfile)
+	assert.Equal(t, []string{"repo/reponame:build-debian"}, client.BuildOptions[1].Tags)
+	assert.Equal(t, []string{"repo/reponame:debug-debian"}, client.BuildOptions[2].Tags)
+	assert.Equal(t, []string{"repo/reponame:abc123-debian", "repo/reponame:main-debian", "repo/reponame:latest-debian"}, client.BuildOptions[3].Tags)
+	assert.Equal(t, "", client.BuildOptions[3].Target)
+	assert.Nil(t, client.BuildOptions[3].BuildArgs["FLAVOUR"])
+	assert.Equal(t, []string{"repo/reponame:abc123-debug", "repo/reponame:main-debug", "repo/reponame:latest-debug"}, client.BuildOptions[6].Tags)
+	assert.Equal(t, "debug", client.BuildOptions[6].Target)
+	logMock.Check(t, []string{
+		"info: Building variant <green>-alpine</green>\n",
+		"info: Build successful",
+		"info: Building variant <green>-debian</green>\n",
+		"info: Build successful",
+		"info: Build successful",
+		"info: Build successful",
+		"info: Building variant <green>-debug</green>\n",
+		"info: Build successful",
+		"info: Build successful",
+		"info: Build successful",
+	})
+}
+
+func TestBuild_Variants_Parallel(t *testing.T) {
+	defer pkg.SetEnv("CI_COMMIT_SHA", "abc123")()
+	defer pkg.SetEnv("CI_PROJECT_NAME", "reponame")()
+	defer pkg.SetEnv("CI_COMMIT_REF_NAME", "feature1")()
+	defer pkg.SetEnv("DOCKERHUB_NAMESPACE", "repo")()
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	client := &docker.MockDocker{}
+	defer func() { _ = os.RemoveAll(name) }()
+	_ = write(name, "Dockerfile", "FROM alpine")
+	_ = write(name, ".buildtools.yaml", `
+build:
+  variants:
+    - suffix: -alpine
+    - suffix: -debug
+      target: debug
+`)
+	err := build(client, name, Args{
+		Globals:    args.Globals{},
+		Dockerfile: "Dockerfile",
+		Parallel:   true,
+	})
+
+	assert.NoError(t, err)
+	var tags [][]string
+	for _, options := range client.BuildOptions {
+		tags = append(tags, options.Tags)
+	}
+	assert.ElementsMatch(t, [][]string{
+		{"repo/reponame:abc123-alpine", "repo/reponame:feature1-alpine"},
+		{"repo/reponame:abc123-debug", "repo/reponame:feature1-debug"},
+	}, tags)
+}
+
+func TestBuild_Variants_StopOnError(t *testing.T) {
+	defer pkg.SetEnv("CI_COMMIT_SHA", "abc123")()
+	defer pkg.SetEnv("CI_PROJECT_NAME", "reponame")()
+	defer pkg.SetEnv("CI_COMMIT_REF_NAME", "feature1")()
+	defer pkg.SetEnv("DOCKERHUB_NAMESPACE", "repo")()
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	client := &docker.MockDocker{BuildError: []error{fmt.Errorf("build error")}}
+	defer func() { _ = os.RemoveAll(name) }()
+	_ = write(name, "Dockerfile", "FROM alpine")
+	_ = write(name, ".buildtools.yaml", `
+build:
+  variants:
+    - suffix: -alpine
+    - suffix: -debug
+      target: debug
+`)
+	err := build(client, name, Args{
+		Globals:    args.Globals{},
+		Dockerfile: "Dockerfile",
+	})
+
+	assert.EqualError(t, err, "build error")
+	assert.Equal(t, 1, len(client.BuildOptions))
+	logMock.Check(t, []string{"info: Building variant <green>-alpine</green>\n"})
+}
+
+func TestVariantExport(t *testing.T) {
+	assert.Nil(t, variantExport(nil, "-alpine"))
+	export := &config.Export{Type: config.ExportTar, Dest: "reports/test.tar"}
+	assert.Same(t, export, variantExport(export, ""))
+	assert.Equal(t, &config.Export{Type: config.ExportTar, Dest: "reports/test-alpine.tar"}, variantExport(export, "-alpine"))
+	assert.Equal(t, &config.Export{Type: config.ExportLocal, Dest: "exported-alpine"}, variantExport(&config.Export{Type: config.ExportLocal, Dest: "exported"}, "-alpine"))
+	assert.Equal(t, "reports/test.tar", export.Dest)
+}
+
 type brokenReader struct{}
 
 func (b brokenReader) Read([]byte) (n int, err error) {
@@ -932,3 +1134,180 @@ func write(dir, file, content string) error {
 	}
 	return os.WriteFile(filepath.Join(dir, file), []byte(fmt.Sprintln(strings.TrimSpace(content))), 0666)
 }
+
+func TestBuild_EngineUnreachable(t *testing.T) {
+	defer pkg.SetEnv("CI_COMMIT_SHA", "abc123")()
+	defer pkg.SetEnv("CI_PROJECT_NAME", "reponame")()
+	defer pkg.SetEnv("CI_COMMIT_REF_NAME", "master")()
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	client := &docker.MockDocker{PingError: errors.New("connection refused")}
+	defer func() { _ = os.RemoveAll(name) }()
+	_ = write(name, "Dockerfile", "FROM scratch")
+	err := build(client, name, Args{Dockerfile: "Dockerfile"})
+
+	assert.EqualError(t, err, "no container engine reachable at unix:///var/run/docker.sock, start Docker, Podman, Colima or Rancher Desktop or set DOCKER_HOST: connection refused")
+	assert.Equal(t, 0, len(client.BuildOptions))
+	logMock.Check(t, []string{"error: <red>no container engine reachable at unix:///var/run/docker.sock, start Docker, Podman, Colima or Rancher Desktop or set DOCKER_HOST: connection refused</red>"})
+}
+
+func TestBuild_ClassicBuilder(t *testing.T) {
+	defer pkg.SetEnv("CI_COMMIT_SHA", "abc123")()
+	defer pkg.SetEnv("CI_PROJECT_NAME", "reponame")()
+	defer pkg.SetEnv("CI_COMMIT_REF_NAME", "master")()
+	defer pkg.SetEnv("DOCKERHUB_NAMESPACE", "repo")()
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	client := &docker.MockDocker{
+		NoBuildKit: true,
+		Components: []types.ComponentVersion{{Name: "Podman Engine", Version: "5.2.0"}},
+	}
+	dockerfile := `
+FROM scratch as export
+COPY file .
+FROM scratch
+`
+	defer func() { _ = os.RemoveAll(name) }()
+	_ = write(name, "Dockerfile", dockerfile)
+	_ = write(name, "file", "content")
+	err := build(client, name, Args{Dockerfile: "Dockerfile", NoLogin: true})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(client.BuildOptions))
+	for i, options := range client.BuildOptions {
+		assert.Equal(t, types.BuilderV1, options.Version)
+		assert.Equal(t, "", options.RemoteContext)
+		assert.Equal(t, "", options.SessionID)
+		assert.Nil(t, options.Outputs)
+		assert.NotNil(t, client.BuildContext[i])
+	}
+	assert.Equal(t, []string{"repo/reponame:export"}, client.BuildOptions[0].Tags)
+	assert.Equal(t, []string{"repo/reponame:abc123", "repo/reponame:master", "repo/reponame:latest"}, client.BuildOptions[1].Tags)
+	logMock.Check(t, []string{
+		"warn: <yellow>Podman does not support BuildKit sessions, falling back to the classic builder</yellow>\n",
+		"warn: <yellow>Exporting stage 'export' requires BuildKit, skipping export</yellow>\n",
+		"info: Build successful",
+		"info: Build successful",
+	})
+}
+
+func TestBuild_UnknownBuildRegistry(t *testing.T) {
+	defer pkg.SetEnv("CI_COMMIT_SHA", "abc123")()
+	defer pkg.SetEnv("CI_PROJECT_NAME", "reponame")()
+	defer pkg.SetEnv("CI_COMMIT_REF_NAME", "master")()
+	defer pkg.SetEnv("DOCKERHUB_NAMESPACE", "repo")()
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	client := &docker.MockDocker{}
+	defer func() { _ = os.RemoveAll(name) }()
+	_ = write(name, "Dockerfile", "FROM scratch")
+	_ = write(name, ".buildtools.yaml", `
+build:
+  registries:
+    - base
+`)
+	err := build(client, name, Args{
+		Globals:    args.Globals{},
+		Dockerfile: "Dockerfile",
+	})
+
+	assert.EqualError(t, err, "no registry matching base found")
+	assert.Empty(t, client.BuildOptions)
+	assert.Equal(t, "error: <red>no registry matching base found</red>", logMock.Logged[len(logMock.Logged)-1])
+}
+
+func TestBuildAuths(t *testing.T) {
+	defer pkg.SetEnv("DOCKERHUB_NAMESPACE", "repo")()
+	defer pkg.SetEnv("DOCKERHUB_USERNAME", "user")()
+	defer pkg.SetEnv("DOCKERHUB_PASSWORD", "pass")()
+	defer pkg.SetEnv("DOCKER_CONFIG", name)()
+
+	defer func() { _ = os.RemoveAll(name) }()
+	_ = write(name, ".buildtools.yaml", `
+registries:
+  base:
+    harbor:
+      url: harbor.example.com
+      project: base
+      username: robot
+      password: secret
+build:
+  registries:
+    - base
+`)
+	cfg, err := config.Load(name)
+	assert.NoError(t, err)
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	auths, err := buildAuths(cfg, cfg.CurrentRegistry())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]dockerregistry.AuthConfig{
+		"docker.io":          {Username: "user", Password: "pass"},
+		"harbor.example.com": {Username: "robot", Password: "secret", ServerAddress: "harbor.example.com"},
+	}, auths)
+	logMock.Check(t, []string{"debug: Using credentials of registry <green>Harbor</green> for <green>harbor.example.com</green>\n"})
+}
+
+func TestBuild_Mirrors(t *testing.T) {
+	defer pkg.SetEnv("CI_COMMIT_SHA", "abc123")()
+	defer pkg.SetEnv("CI_PROJECT_NAME", "reponame")()
+	defer pkg.SetEnv("CI_COMMIT_REF_NAME", "master")()
+	defer pkg.SetEnv("DOCKERHUB_NAMESPACE", "repo")()
+	defer pkg.SetEnv("DOCKER_CONFIG", t.TempDir())()
+
+	mirror := mirrorRegistry(t, "hub/repo/reponame:latest")
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	client := &docker.MockDocker{}
+	defer func() { _ = os.RemoveAll(name) }()
+	_ = write(name, "Dockerfile", "FROM alpine:3")
+	_ = write(name, ".buildtools.yaml", fmt.Sprintf(`
+build:
+  mirrors:
+    docker.io: %s/hub
+`, mirror))
+	err := build(client, name, Args{Dockerfile: "Dockerfile", NoLogin: true})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(client.BuildOptions))
+	assert.Equal(t, []string{"repo/reponame:master", mirror + "/hub/repo/reponame:latest"}, client.BuildOptions[0].CacheFrom)
+}
+
+func TestBuild_MirrorsClassicBuilder(t *testing.T) {
+	defer pkg.SetEnv("CI_COMMIT_SHA", "abc123")()
+	defer pkg.SetEnv("CI_PROJECT_NAME", "reponame")()
+	defer pkg.SetEnv("CI_COMMIT_REF_NAME", "master")()
+	defer pkg.SetEnv("DOCKERHUB_NAMESPACE", "repo")()
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	client := &docker.MockDocker{
+		NoBuildKit: true,
+		Components: []types.ComponentVersion{{Name: "Podman Engine", Version: "5.2.0"}},
+	}
+	defer func() { _ = os.RemoveAll(name) }()
+	_ = write(name, "Dockerfile", "FROM alpine:3")
+	_ = write(name, ".buildtools.yaml", `
+build:
+  mirrors:
+    docker.io: harbor.example.com/hub
+`)
+	err := build(client, name, Args{Dockerfile: "Dockerfile", NoLogin: true})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"repo/reponame:master", "repo/reponame:latest"}, client.BuildOptions[0].CacheFrom)
+	logMock.Check(t, []string{
+		"warn: <yellow>Podman does not support BuildKit sessions, falling back to the classic builder</yellow>\n",
+		"warn: <yellow>Registry mirrors require BuildKit, using upstream registries</yellow>\n",
+		"info: Build successful",
+	})
+}
@@ -0,0 +1,241 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/google/go-containerregistry/pkg/authn"
+	refname "github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/buildtool/build-tools/pkg/ci"
+	"github.com/buildtool/build-tools/pkg/config"
+	"github.com/buildtool/build-tools/pkg/docker"
+)
+
+// goBinaryDir is where the compiled binary is placed in the image
+const goBinaryDir = "/app"
+
+var goCompiler = compileGo
+
+// buildGo compiles the configured Go package for each platform and writes the resulting
+// images, based on the configured base image pulled using the keychain, to an OCI layout to be pushed by `push`
+func buildGo(dir string, buildVars Args, cfg *config.Config, currentCI ci.CI, registryUrl string, keychain authn.Keychain) error {
+	goBuild := *cfg.Build.Go
+	if !ci.IsValid(currentCI) {
+		return fmt.Errorf("commit and/or branch information is <red>missing</red> (perhaps you're not in a Git repository or forgot to set environment variables?)")
+	}
+	commit := currentCI.Commit()
+	branch := currentCI.BranchReplaceSlash()
+	log.Debugf("Using build variables commit <green>%s</green> on branch <green>%s</green>\n", commit, branch)
+
+	tags := []string{
+		docker.Tag(registryUrl, currentCI.BuildName(), commit),
+		docker.Tag(registryUrl, currentCI.BuildName(), branch),
+	}
+	if currentCI.Branch() == "master" || currentCI.Branch() == "main" {
+		tags = append(tags, docker.Tag(registryUrl, currentCI.BuildName(), "latest"))
+	}
+
+	platforms, err := goPlatforms(goBuild, buildVars.Platform)
+	if err != nil {
+		return err
+	}
+	binary := goBuild.Binary
+	if binary == "" {
+		binary = currentCI.BuildName()
+	}
+	main := goBuild.Main
+	if main == "" {
+		main = config.DefaultGoMain
+	}
+	base := goBuild.Base
+	if base == "" {
+		base = config.DefaultGoBase
+	}
+	baseRef, err := refname.ParseReference(base)
+	if err != nil {
+		return err
+	}
+
+	tmpDir, err := os.MkdirTemp(os.TempDir(), "build-tools-go")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	ctx := context.Background()
+	var adds []mutate.IndexAddendum
+	for _, platform := range platforms {
+		log.Infof("Compiling <green>%s</green> for platform <green>%s</green>\n", main, platform.String())
+		out := filepath.Join(tmpDir, strings.ReplaceAll(platform.String(), "/", "-"), binary)
+		if err := goCompiler(ctx, dir, out, main, platform, goBuild); err != nil {
+			return err
+		}
+		baseImage, err := remote.Image(baseRef, remote.WithContext(ctx), remote.WithAuthFromKeychain(keychain), remote.WithPlatform(platform))
+		if err != nil {
+			return fmt.Errorf("unable to fetch base image %s: %w", base, err)
+		}
+		img, err := goImage(baseImage, out, binary, commit)
+		if err != nil {
+			return err
+		}
+		p := platform
+		adds = append(adds, mutate.IndexAddendum{Add: img, Descriptor: v1.Descriptor{Platform: &p}})
+	}
+
+	output := goBuild.OutputPath(dir)
+	if err := os.RemoveAll(output); err != nil {
+		return err
+	}
+	l, err := layout.Write(output, empty.Index)
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		annotations := layout.WithAnnotations(map[string]string{ocispec.AnnotationRefName: tag})
+		if len(adds) == 1 {
+			err = l.AppendImage(adds[0].Add.(v1.Image), annotations)
+		} else {
+			err = l.AppendIndex(mutate.AppendManifests(mutate.IndexMediaType(empty.Index, types.OCIImageIndex), adds...), annotations)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	log.Infof("Wrote image for tags <green>%s</green> to %s\n", strings.Join(tags, ", "), output)
+	return nil
+}
+
+func goPlatforms(goBuild config.GoBuild, platform string) ([]v1.Platform, error) {
+	names := goBuild.Platforms
+	if platform != "" {
+		names = strings.Split(platform, ",")
+	}
+	if len(names) == 0 {
+		names = []string{"linux/amd64"}
+	}
+	var platforms []v1.Platform
+	for _, n := range names {
+		p, err := v1.ParsePlatform(strings.TrimSpace(n))
+		if err != nil {
+			return nil, err
+		}
+		platforms = append(platforms, *p)
+	}
+	return platforms, nil
+}
+
+func compileGo(ctx context.Context, dir, out, main string, platform v1.Platform, goBuild config.GoBuild) error {
+	args := []string{"build", "-trimpath", "-o", out}
+	if goBuild.Ldflags != "" {
+		args = append(args, "-ldflags", goBuild.Ldflags)
+	}
+	args = append(args, goBuild.Flags...)
+	args = append(args, main)
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "CGO_ENABLED=0", "GOOS="+platform.OS, "GOARCH="+platform.Architecture)
+	if platform.Architecture == "arm" && platform.Variant != "" {
+		cmd.Env = append(cmd.Env, "GOARM="+strings.TrimPrefix(platform.Variant, "v"))
+	}
+	cmd.Env = append(cmd.Env, goBuild.Env...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("go build of %s failed: %w", main, err)
+	}
+	return nil
+}
+
+// goImage adds the binary as a new layer on top of base and makes it the entrypoint
+func goImage(base v1.Image, binary, binaryName, commit string) (v1.Image, error) {
+	content, err := os.ReadFile(binary)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	if err := tw.WriteHeader(&tar.Header{Name: strings.TrimPrefix(goBinaryDir, "/") + "/", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+		return nil, err
+	}
+	target := path.Join(goBinaryDir, binaryName)
+	if err := tw.WriteHeader(&tar.Header{Name: strings.TrimPrefix(target, "/"), Typeflag: tar.TypeReg, Mode: 0755, Size: int64(len(content))}); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(content); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	var layerOpts []tarball.LayerOption
+	if mt, err := base.MediaType(); err == nil && mt == types.OCIManifestSchema1 {
+		layerOpts = append(layerOpts, tarball.WithMediaType(types.OCILayer))
+	}
+	data := buf.Bytes()
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}, layerOpts...)
+	if err != nil {
+		return nil, err
+	}
+	img, err := mutate.Append(base, mutate.Addendum{
+		Layer:   layer,
+		History: v1.History{CreatedBy: "buildtools go build " + binaryName},
+	})
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	cfg = cfg.DeepCopy()
+	cfg.Config.Entrypoint = []string{target}
+	cfg.Config.Cmd = nil
+	if cfg.Config.Labels == nil {
+		cfg.Config.Labels = map[string]string{}
+	}
+	cfg.Config.Labels["org.opencontainers.image.revision"] = commit
+	return mutate.ConfigFile(img, cfg)
+}
@@ -0,0 +1,215 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package build
+
+import (
+	"context"
+	"fmt"
+	"io"
+	stdlog "log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	refname "github.com/google/go-containerregistry/pkg/name"
+	ggcr "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+
+	"github.com/buildtool/build-tools/pkg"
+	"github.com/buildtool/build-tools/pkg/config"
+	"github.com/buildtool/build-tools/pkg/docker"
+)
+
+func TestBuild_Go(t *testing.T) {
+	server := httptest.NewServer(ggcr.New(ggcr.Logger(stdlog.New(io.Discard, "", 0))))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	base, err := random.Image(1024, 1)
+	assert.NoError(t, err)
+	baseRef, _ := refname.ParseReference(fmt.Sprintf("%s/base:latest", host))
+	assert.NoError(t, remote.Write(baseRef, base))
+
+	defer pkg.SetEnv("CI_COMMIT_SHA", "abc123")()
+	defer pkg.SetEnv("CI_PROJECT_NAME", "reponame")()
+	defer pkg.SetEnv("CI_COMMIT_REF_NAME", "main")()
+	defer pkg.SetEnv("DOCKERHUB_NAMESPACE", "repo")()
+
+	var compiled []string
+	defer func(c func(context.Context, string, string, string, v1.Platform, config.GoBuild) error) {
+		goCompiler = c
+	}(goCompiler)
+	goCompiler = func(ctx context.Context, dir, out, main string, platform v1.Platform, goBuild config.GoBuild) error {
+		compiled = append(compiled, fmt.Sprintf("%s:%s", main, platform.String()))
+		_ = os.MkdirAll(filepath.Dir(out), 0777)
+		return os.WriteFile(out, []byte(platform.String()), 0755)
+	}
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	client := &docker.MockDocker{}
+	defer func() { _ = os.RemoveAll(name) }()
+	_ = write(name, ".buildtools.yaml", fmt.Sprintf(`
+build:
+  go:
+    main: ./cmd/server
+    base: %s/base:latest
+    platforms:
+      - linux/amd64
+      - linux/arm64
+`, host))
+
+	err = build(client, name, Args{Dockerfile: "Dockerfile"})
+	assert.NoError(t, err)
+	assert.Empty(t, client.BuildOptions)
+	assert.Equal(t, []string{"./cmd/server:linux/amd64", "./cmd/server:linux/arm64"}, compiled)
+
+	output := filepath.Join(name, config.DefaultGoOutput)
+	index, err := layout.ImageIndexFromPath(output)
+	assert.NoError(t, err)
+	manifest, err := index.IndexManifest()
+	assert.NoError(t, err)
+	var tags []string
+	for _, desc := range manifest.Manifests {
+		assert.True(t, desc.MediaType.IsIndex())
+		tags = append(tags, desc.Annotations[ocispec.AnnotationRefName])
+	}
+	assert.Equal(t, []string{"repo/reponame:abc123", "repo/reponame:main", "repo/reponame:latest"}, tags)
+
+	platforms, err := index.ImageIndex(manifest.Manifests[0].Digest)
+	assert.NoError(t, err)
+	platformManifest, err := platforms.IndexManifest()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(platformManifest.Manifests))
+	assert.Equal(t, "arm64", platformManifest.Manifests[1].Platform.Architecture)
+	img, err := platforms.Image(platformManifest.Manifests[1].Digest)
+	assert.NoError(t, err)
+	cfg, err := img.ConfigFile()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/app/reponame"}, cfg.Config.Entrypoint)
+	assert.Equal(t, "abc123", cfg.Config.Labels["org.opencontainers.image.revision"])
+	layers, err := img.Layers()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(layers))
+
+	logMock.Check(t, []string{
+		"info: Compiling <green>./cmd/server</green> for platform <green>linux/amd64</green>\n",
+		"info: Compiling <green>./cmd/server</green> for platform <green>linux/arm64</green>\n",
+		fmt.Sprintf("info: Wrote image for tags <green>repo/reponame:abc123, repo/reponame:main, repo/reponame:latest</green> to %s\n", output),
+	})
+}
+
+func TestBuild_Go_PrivateBase(t *testing.T) {
+	registry := ggcr.New(ggcr.Logger(stdlog.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); r.Method == http.MethodGet && (user != "user" || pass != "pass") {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		registry.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	base, err := random.Image(1024, 1)
+	assert.NoError(t, err)
+	baseRef, _ := refname.ParseReference(fmt.Sprintf("%s/base:latest", host))
+	assert.NoError(t, remote.Write(baseRef, base))
+
+	defer pkg.SetEnv("CI_COMMIT_SHA", "abc123")()
+	defer pkg.SetEnv("CI_PROJECT_NAME", "reponame")()
+	defer pkg.SetEnv("CI_COMMIT_REF_NAME", "main")()
+	defer pkg.SetEnv("DOCKERHUB_NAMESPACE", "repo")()
+	defer pkg.SetEnv("DOCKER_CONFIG", t.TempDir())()
+
+	defer func(c func(context.Context, string, string, string, v1.Platform, config.GoBuild) error) {
+		goCompiler = c
+	}(goCompiler)
+	goCompiler = func(ctx context.Context, dir, out, main string, platform v1.Platform, goBuild config.GoBuild) error {
+		_ = os.MkdirAll(filepath.Dir(out), 0777)
+		return os.WriteFile(out, []byte(platform.String()), 0755)
+	}
+
+	log.SetHandler(mocks.New())
+	defer func() { _ = os.RemoveAll(name) }()
+	_ = write(name, ".buildtools.yaml", fmt.Sprintf(`
+registries:
+  base:
+    generic:
+      url: %s
+      username: user
+      password: pass
+build:
+  registries:
+    - base
+  go:
+    base: %s/base:latest
+`, host, host))
+
+	err = build(&docker.MockDocker{}, name, Args{Dockerfile: "Dockerfile"})
+	assert.NoError(t, err)
+
+	err = build(&docker.MockDocker{}, name, Args{Dockerfile: "Dockerfile", NoLogin: true})
+	assert.ErrorContains(t, err, fmt.Sprintf("unable to fetch base image %s/base:latest: ", host))
+}
+
+func TestBuild_Go_CompileError(t *testing.T) {
+	defer pkg.SetEnv("CI_COMMIT_SHA", "abc123")()
+	defer pkg.SetEnv("CI_PROJECT_NAME", "reponame")()
+	defer pkg.SetEnv("CI_COMMIT_REF_NAME", "main")()
+
+	defer func(c func(context.Context, string, string, string, v1.Platform, config.GoBuild) error) {
+		goCompiler = c
+	}(goCompiler)
+	goCompiler = func(ctx context.Context, dir, out, main string, platform v1.Platform, goBuild config.GoBuild) error {
+		return fmt.Errorf("go build of %s failed: exit status 1", main)
+	}
+
+	defer func() { _ = os.RemoveAll(name) }()
+	_ = write(name, ".buildtools.yaml", `
+build:
+  go: {}
+`)
+	err := build(&docker.MockDocker{}, name, Args{Dockerfile: "Dockerfile", Platform: "linux/arm/v7"})
+	assert.EqualError(t, err, "go build of . failed: exit status 1")
+}
+
+func TestGoPlatforms(t *testing.T) {
+	platforms, err := goPlatforms(config.GoBuild{}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []v1.Platform{{OS: "linux", Architecture: "amd64"}}, platforms)
+
+	platforms, err = goPlatforms(config.GoBuild{Platforms: []string{"linux/arm64"}}, "linux/amd64,linux/arm/v7")
+	assert.NoError(t, err)
+	assert.Equal(t, []v1.Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm", Variant: "v7"}}, platforms)
+}
@@ -0,0 +1,185 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package build
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/apex/log"
+	"github.com/google/go-containerregistry/pkg/authn"
+	refname "github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+var fromLine = regexp.MustCompile(`(?i)^(\s*FROM\s+(?:--\S+\s+)*)(\S+)(.*)$`)
+
+// mirrors rewrites image references to use the configured registry mirrors, falling back
+// to the upstream registry for images which are not available from the mirror.
+// A nil mirrors leaves images unchanged.
+type mirrors struct {
+	// mirrors maps upstream registry hosts to mirror registries, optionally with a path
+	mirrors  map[string]string
+	keychain authn.Keychain
+	mu       sync.Mutex
+	resolved map[string]string
+}
+
+func newMirrors(configured map[string]string, keychain authn.Keychain) *mirrors {
+	if len(configured) == 0 {
+		return nil
+	}
+	normalized := make(map[string]string, len(configured))
+	for upstream, mirror := range configured {
+		normalized[registryHost(upstream)] = strings.TrimSuffix(mirror, "/")
+	}
+	return &mirrors{mirrors: normalized, keychain: keychain, resolved: map[string]string{}}
+}
+
+// image returns the reference to use for image, the mirrored one if the mirror has it
+func (m *mirrors) image(image string) string {
+	if m == nil {
+		return image
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if resolved, exists := m.resolved[image]; exists {
+		return resolved
+	}
+	resolved := image
+	if mirrored, ok := m.mirrored(image); ok {
+		if err := m.available(mirrored); err != nil {
+			log.Debugf("Image '<green>%s</green>' not available from mirror, using upstream: %s\n", mirrored, err)
+		} else {
+			log.Debugf("Using mirror '<green>%s</green>' for '<green>%s</green>'\n", mirrored, image)
+			resolved = mirrored
+		}
+	}
+	m.resolved[image] = resolved
+	return resolved
+}
+
+// images returns the references to use for images
+func (m *mirrors) images(images ...string) []string {
+	result := make([]string, len(images))
+	for i, image := range images {
+		result[i] = m.image(image)
+	}
+	return result
+}
+
+// dockerfile rewrites the FROM instructions of the Dockerfile content to use mirrors,
+// leaving references to stages, scratch and images using build args unchanged
+func (m *mirrors) dockerfile(content string, stages []string) string {
+	if m == nil {
+		return content
+	}
+	lines := strings.Split(content, "\n")
+	changed := false
+	for i, line := range lines {
+		if matches := fromLine.FindStringSubmatch(line); matches != nil && !isStageOrScratch(matches[2], stages) && !strings.Contains(matches[2], "$") {
+			if image := m.image(matches[2]); image != matches[2] {
+				lines[i] = matches[1] + image + matches[3]
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return content
+	}
+	return strings.Join(lines, "\n")
+}
+
+// mirrored returns the reference of image in the mirror of its registry, if there is one
+func (m *mirrors) mirrored(image string) (string, bool) {
+	ref, err := refname.ParseReference(image)
+	if err != nil {
+		return "", false
+	}
+	mirror, exists := m.mirrors[registryHost(ref.Context().RegistryStr())]
+	if !exists {
+		return "", false
+	}
+	separator := ":"
+	if _, digest := ref.(refname.Digest); digest {
+		separator = "@"
+	}
+	return fmt.Sprintf("%s/%s%s%s", mirror, ref.Context().RepositoryStr(), separator, ref.Identifier()), true
+}
+
+// available checks that the image exists in the mirror
+func (m *mirrors) available(image string) error {
+	ref, err := refname.ParseReference(image)
+	if err != nil {
+		return err
+	}
+	_, err = remote.Head(ref, remote.WithContext(context.Background()), remote.WithAuthFromKeychain(m.keychain), remote.WithUserAgent("buildtools"))
+	return err
+}
+
+// mirroredDockerfile writes the rewritten Dockerfile content to a temporary directory, together with its
+// Dockerfile specific ignore file, returning the directory
+func mirroredDockerfile(dir, dockerfile, content string) (string, error) {
+	tmp, err := os.MkdirTemp("", "buildtools-dockerfile")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(filepath.Join(tmp, dockerfile)), 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(tmp, dockerfile), []byte(content), 0644); err != nil {
+		return "", err
+	}
+	if ignore, err := os.ReadFile(filepath.Join(dir, dockerfile+".dockerignore")); err == nil {
+		if err := os.WriteFile(filepath.Join(tmp, dockerfile+".dockerignore"), ignore, 0644); err != nil {
+			return "", err
+		}
+	}
+	return tmp, nil
+}
+
+func isStageOrScratch(image string, stages []string) bool {
+	if strings.EqualFold(image, "scratch") {
+		return true
+	}
+	for _, stage := range stages {
+		if strings.EqualFold(image, stage) {
+			return true
+		}
+	}
+	return false
+}
+
+// registryHost normalizes the Docker Hub registry names
+func registryHost(host string) string {
+	switch host {
+	case "docker.io", "index.docker.io", "registry-1.docker.io":
+		return refname.DefaultRegistry
+	}
+	return host
+}
@@ -0,0 +1,155 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package build
+
+import (
+	"fmt"
+	"io"
+	stdlog "log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	refname "github.com/google/go-containerregistry/pkg/name"
+	ggcr "github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+
+	"github.com/buildtool/build-tools/pkg/docker"
+)
+
+// mirrorRegistry starts an in-memory registry with the images pushed to it, returning its host
+func mirrorRegistry(t *testing.T, images ...string) string {
+	server := httptest.NewServer(ggcr.New(ggcr.Logger(stdlog.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "http://")
+	for _, image := range images {
+		img, err := random.Image(1024, 1)
+		assert.NoError(t, err)
+		ref, err := refname.ParseReference(fmt.Sprintf("%s/%s", host, image))
+		assert.NoError(t, err)
+		assert.NoError(t, remote.Write(ref, img))
+	}
+	return host
+}
+
+func testMirrors(t *testing.T, configured map[string]string) *mirrors {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	return newMirrors(configured, docker.NewKeychain(nil))
+}
+
+func TestMirrors_None(t *testing.T) {
+	m := testMirrors(t, nil)
+	assert.Nil(t, m)
+	assert.Equal(t, "alpine:3", m.image("alpine:3"))
+	assert.Equal(t, []string{"a", "b"}, m.images("a", "b"))
+	assert.Equal(t, "FROM alpine:3\n", m.dockerfile("FROM alpine:3\n", nil))
+}
+
+func TestMirrors_Image(t *testing.T) {
+	host := mirrorRegistry(t, "dockerhub/library/alpine:3", "dockerhub/org/tool:1.0")
+	m := testMirrors(t, map[string]string{"docker.io": host + "/dockerhub/"})
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+
+	assert.Equal(t, host+"/dockerhub/library/alpine:3", m.image("alpine:3"))
+	assert.Equal(t, host+"/dockerhub/org/tool:1.0", m.image("docker.io/org/tool:1.0"))
+	// checked once
+	assert.Equal(t, host+"/dockerhub/library/alpine:3", m.image("alpine:3"))
+	assert.Equal(t, "alpine:edge", m.image("alpine:edge"))
+	assert.Equal(t, "quay.io/org/image:1", m.image("quay.io/org/image:1"))
+	assert.Equal(t, 3, len(logMock.Logged))
+	assert.Equal(t, fmt.Sprintf("debug: Using mirror '<green>%s/dockerhub/library/alpine:3</green>' for '<green>alpine:3</green>'\n", host), logMock.Logged[0])
+	assert.True(t, strings.HasPrefix(logMock.Logged[2], fmt.Sprintf("debug: Image '<green>%s/dockerhub/library/alpine:edge</green>' not available from mirror, using upstream: ", host)))
+}
+
+func TestMirrors_Digest(t *testing.T) {
+	host := mirrorRegistry(t)
+	m := testMirrors(t, map[string]string{"quay.io": host})
+	mirrored, ok := m.mirrored("quay.io/org/image@sha256:0000000000000000000000000000000000000000000000000000000000000000")
+	assert.True(t, ok)
+	assert.Equal(t, host+"/org/image@sha256:0000000000000000000000000000000000000000000000000000000000000000", mirrored)
+}
+
+func TestMirrors_Dockerfile(t *testing.T) {
+	host := mirrorRegistry(t, "library/golang:1.23", "library/alpine:3")
+	m := testMirrors(t, map[string]string{"index.docker.io": host})
+	log.SetHandler(mocks.New())
+	content := `ARG BASE=alpine:3
+FROM --platform=$BUILDPLATFORM golang:1.23 AS build
+RUN go build
+FROM build as test
+FROM ${BASE}
+FROM scratch
+from alpine:3
+COPY --from=build /app /app
+`
+	assert.Equal(t, fmt.Sprintf(`ARG BASE=alpine:3
+FROM --platform=$BUILDPLATFORM %[1]s/library/golang:1.23 AS build
+RUN go build
+FROM build as test
+FROM ${BASE}
+FROM scratch
+from %[1]s/library/alpine:3
+COPY --from=build /app /app
+`, host), m.dockerfile(content, docker.FindStages(content)))
+}
+
+func TestMirrors_DockerfileUnchanged(t *testing.T) {
+	host := mirrorRegistry(t)
+	m := testMirrors(t, map[string]string{"docker.io": host})
+	log.SetHandler(mocks.New())
+	content := "FROM alpine:3\nRUN echo"
+	assert.Equal(t, content, m.dockerfile(content, nil))
+}
+
+func TestMirrors_DockerfileLongLine(t *testing.T) {
+	host := mirrorRegistry(t, "library/alpine:3")
+	m := testMirrors(t, map[string]string{"docker.io": host})
+	log.SetHandler(mocks.New())
+	run := "RUN echo " + strings.Repeat("a", 100*1024)
+	content := "FROM alpine:3 AS build\n" + run + "\nFROM alpine:3\n"
+	assert.Equal(t, fmt.Sprintf("FROM %[1]s/library/alpine:3 AS build\n%[2]s\nFROM %[1]s/library/alpine:3\n", host, run), m.dockerfile(content, docker.FindStages(content)))
+}
+
+func TestMirroredDockerfile(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "docker"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "docker", "Dockerfile.dockerignore"), []byte("*.md"), 0644))
+
+	tmp, err := mirroredDockerfile(dir, filepath.Join("docker", "Dockerfile"), "FROM mirror/alpine:3\n")
+	assert.NoError(t, err)
+	defer func() { _ = os.RemoveAll(tmp) }()
+	content, err := os.ReadFile(filepath.Join(tmp, "docker", "Dockerfile"))
+	assert.NoError(t, err)
+	assert.Equal(t, "FROM mirror/alpine:3\n", string(content))
+	ignore, err := os.ReadFile(filepath.Join(tmp, "docker", "Dockerfile.dockerignore"))
+	assert.NoError(t, err)
+	assert.Equal(t, "*.md", string(ignore))
+}
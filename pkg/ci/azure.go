@@ -36,7 +36,7 @@ func (c Azure) Name() string {
 }
 
 func (c Azure) BranchReplaceSlash() string {
-	return branchReplaceSlash(c.Branch())
+	return BranchReplaceSlash(c.Branch())
 }
 
 func (c Azure) BuildName() string {
@@ -36,7 +36,7 @@ func (c *Buildkite) Name() string {
 }
 
 func (c *Buildkite) BranchReplaceSlash() string {
-	return branchReplaceSlash(c.Branch())
+	return BranchReplaceSlash(c.Branch())
 }
 
 func (c *Buildkite) BuildName() string {
@@ -82,7 +82,8 @@ func (c *Common) Commit(name string) string {
 	return c.VCS.Commit()
 }
 
-func branchReplaceSlash(name string) string {
+// BranchReplaceSlash returns the branch name as used in image tags, with slashes and spaces replaced
+func BranchReplaceSlash(name string) string {
 	return strings.ReplaceAll(strings.ReplaceAll(name, "/", "_"), " ", "_")
 }
 
@@ -40,7 +40,7 @@ func (c *Github) Name() string {
 }
 
 func (c *Github) BranchReplaceSlash() string {
-	return branchReplaceSlash(c.Branch())
+	return BranchReplaceSlash(c.Branch())
 }
 
 func (c *Github) BuildName() string {
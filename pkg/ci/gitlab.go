@@ -36,7 +36,7 @@ func (c *Gitlab) Name() string {
 }
 
 func (c *Gitlab) BranchReplaceSlash() string {
-	return branchReplaceSlash(c.Branch())
+	return BranchReplaceSlash(c.Branch())
 }
 
 func (c *Gitlab) BuildName() string {
@@ -33,7 +33,7 @@ func (c No) Name() string {
 }
 
 func (c No) BranchReplaceSlash() string {
-	return branchReplaceSlash(c.Branch())
+	return BranchReplaceSlash(c.Branch())
 }
 
 func (c No) BuildName() string {
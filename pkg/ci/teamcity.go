@@ -36,7 +36,7 @@ func (c TeamCity) Name() string {
 }
 
 func (c TeamCity) BranchReplaceSlash() string {
-	return branchReplaceSlash(c.Branch())
+	return BranchReplaceSlash(c.Branch())
 }
 
 func (c TeamCity) BuildName() string {
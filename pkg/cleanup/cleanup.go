@@ -0,0 +1,331 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cleanup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	refname "github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"github.com/buildtool/build-tools/pkg/args"
+	"github.com/buildtool/build-tools/pkg/ci"
+	"github.com/buildtool/build-tools/pkg/config"
+	"github.com/buildtool/build-tools/pkg/docker"
+	"github.com/buildtool/build-tools/pkg/registry"
+	"github.com/buildtool/build-tools/pkg/version"
+)
+
+type Args struct {
+	args.Globals
+	Dockerfile string        `name:"file" short:"f" help:"name of the Dockerfile to use." default:"Dockerfile"`
+	Registry   string        `name:"registry" help:"name of the registry in registries to clean up, defaults to the current registry" default:""`
+	MaxAge     time.Duration `name:"max-age" help:"delete commit tags of images older than this" default:"720h"`
+	Keep       []string      `name:"keep" help:"patterns of tags which are never deleted" default:"latest*,sha256-*"`
+	BranchTags []string      `name:"branch-tags" help:"patterns of branch tags, which are deleted when the branch no longer exists" default:"*_*"`
+	DryRun     bool          `name:"dry-run" help:"only show the tags which would be deleted"`
+	Force      bool          `name:"force" help:"clean up even if no branches other than the current one are found"`
+}
+
+var commitTag = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// now is the current time, replaced in tests
+var now = time.Now
+
+func DoCleanup(dir string, info version.Info, osArgs ...string) int {
+	var cleanupArgs Args
+	err := args.ParseArgs(dir, osArgs, info, &cleanupArgs)
+	if err != nil {
+		if err != args.Done {
+			return -1
+		} else {
+			return 0
+		}
+	}
+
+	cfg, err := config.Load(dir)
+	if err != nil {
+		log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+		return -2
+	}
+	return cleanup(cfg, dir, cleanupArgs)
+}
+
+func cleanup(cfg *config.Config, dir string, cleanupArgs Args) int {
+	currentCI := cfg.CurrentCI()
+	branches := cfg.CurrentVCS().Branches()
+	currentBranch := docker.SlugifyTag(currentCI.BranchReplaceSlash())
+	if !cleanupArgs.Force && !otherBranches(branches, currentBranch) {
+		log.Error("No branches other than the current one <red>found</red>. Cleanup must be run in a Git repository with the branches of the remote fetched, or with --force")
+		return -3
+	}
+	reg, err := cfg.NamedRegistry(cleanupArgs.Registry)
+	if err != nil {
+		log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+		return -3
+	}
+	log.Debugf("Using registry <green>%s</green>\n", reg.Name())
+	if err := registry.Authenticate(reg); err != nil {
+		log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+		return -4
+	}
+	options := registry.RemoteOptions(context.Background(), reg)
+
+	repo, err := refname.NewRepository(fmt.Sprintf("%s/%s", reg.RegistryUrl(), currentCI.BuildName()), registry.NameOptions(reg)...)
+	if err != nil {
+		log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+		return -5
+	}
+	tags, err := remote.List(repo, options...)
+	if err != nil {
+		log.Error(fmt.Sprintf("<red>unable to list tags of %s: %s</red>", repo, err.Error()))
+		return -5
+	}
+
+	p := policy{
+		branches:   make(map[string]bool),
+		commits:    make(map[string]bool),
+		stages:     make(map[string]bool),
+		keep:       cleanupArgs.Keep,
+		branchTags: cleanupArgs.BranchTags,
+		maxAge:     cleanupArgs.MaxAge,
+	}
+	for branch, commit := range branches {
+		p.branches[docker.SlugifyTag(ci.BranchReplaceSlash(branch))] = true
+		p.commits[commit] = true
+	}
+	p.branches[currentBranch] = true
+	p.commits[currentCI.Commit()] = true
+	for _, variant := range cfg.BuildVariants(cleanupArgs.Dockerfile) {
+		if variant.Suffix != "" {
+			p.suffixes = append(p.suffixes, variant.Suffix)
+		}
+		if content, err := os.ReadFile(filepath.Join(dir, variant.Dockerfile)); err == nil {
+			for _, stage := range docker.FindStages(string(content)) {
+				p.stages[stage] = true
+			}
+		}
+	}
+
+	reasons := make(map[string]string)
+	var candidates []string
+	for _, tag := range tags {
+		ref := repo.Tag(tag)
+		if reason := p.deleteReason(tag, func() (time.Time, error) { return created(ref, options) }); reason != "" {
+			reasons[tag] = reason
+			candidates = append(candidates, tag)
+		}
+	}
+	if len(candidates) == 0 {
+		log.Infof("Deleted 0 of %d tags\n", len(tags))
+		return 0
+	}
+
+	// Tags are deleted by deleting the manifest they point to, since not all registries support deleting tags.
+	// A manifest is only deleted if all of its tags are to be deleted
+	digests, unresolved := resolveDigests(repo, tags, options)
+	failed := 0
+	for _, tag := range unresolved {
+		if reasons[tag] == "" {
+			log.Error(fmt.Sprintf("<red>unable to resolve kept tag %s, not deleting any tags since it might share an image with them</red>", tag))
+			return -6
+		}
+		failed++
+	}
+	tagged := make(map[string][]string)
+	for _, tag := range tags {
+		if digest, exists := digests[tag]; exists {
+			tagged[digest] = append(tagged[digest], tag)
+		}
+	}
+	deleted := 0
+	handled := make(map[string]bool)
+	for _, tag := range candidates {
+		digest, exists := digests[tag]
+		if !exists || handled[digest] {
+			continue
+		}
+		handled[digest] = true
+		var kept []string
+		for _, other := range tagged[digest] {
+			if reasons[other] == "" {
+				kept = append(kept, other)
+			}
+		}
+		if len(kept) > 0 {
+			log.Infof("Keeping '<green>%s</green>', %s, the image is also tagged %s\n", repo.Tag(tag), reasons[tag], strings.Join(kept, ", "))
+			continue
+		}
+		for _, other := range tagged[digest] {
+			if cleanupArgs.DryRun {
+				log.Infof("Would delete '<green>%s</green>', %s\n", repo.Tag(other), reasons[other])
+			} else {
+				log.Infof("Deleting '<green>%s</green>', %s\n", repo.Tag(other), reasons[other])
+			}
+		}
+		if !cleanupArgs.DryRun {
+			if err := remote.Delete(repo.Digest(digest), options...); err != nil {
+				log.Error(fmt.Sprintf("<red>unable to delete %s: %s</red>", repo.Digest(digest), err.Error()))
+				failed++
+				continue
+			}
+		}
+		deleted += len(tagged[digest])
+	}
+	if cleanupArgs.DryRun {
+		log.Infof("%d of %d tags would be deleted\n", deleted, len(tags))
+	} else {
+		log.Infof("Deleted %d of %d tags\n", deleted, len(tags))
+	}
+	if failed > 0 {
+		log.Error(fmt.Sprintf("<red>%d tags could not be deleted</red>", failed))
+		return -6
+	}
+	return 0
+}
+
+// otherBranches returns true if any branch other than current is known,
+// i.e. the branches of the remote have been fetched
+func otherBranches(branches map[string]string, current string) bool {
+	for branch := range branches {
+		if docker.SlugifyTag(ci.BranchReplaceSlash(branch)) != current {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveDigests returns the digests of the manifests of the tags, and the tags which could not be resolved
+func resolveDigests(repo refname.Repository, tags []string, options []remote.Option) (map[string]string, []string) {
+	digests := make(map[string]string)
+	var unresolved []string
+	for _, tag := range tags {
+		desc, err := remote.Head(repo.Tag(tag), options...)
+		if err != nil {
+			log.Error(fmt.Sprintf("<red>unable to resolve %s: %s</red>", repo.Tag(tag), err.Error()))
+			unresolved = append(unresolved, tag)
+			continue
+		}
+		digests[tag] = desc.Digest.String()
+	}
+	return digests, unresolved
+}
+
+// policy decides which tags to delete
+type policy struct {
+	// branches are the tags of existing branches
+	branches map[string]bool
+	// commits are the heads of existing branches, which are kept regardless of age
+	commits  map[string]bool
+	stages   map[string]bool
+	suffixes []string
+	keep     []string
+	// branchTags are patterns of the tags of branches, other tags are never considered branch tags
+	branchTags []string
+	maxAge     time.Duration
+}
+
+// deleteReason returns why the tag should be deleted, or an empty string if it should be kept.
+// created is only called for commit tags, to find the age of the image.
+func (p policy) deleteReason(tag string, created func() (time.Time, error)) string {
+	for _, pattern := range p.keep {
+		if matched, _ := path.Match(pattern, tag); matched {
+			return ""
+		}
+	}
+	names := []string{tag}
+	for _, suffix := range p.suffixes {
+		if name, found := strings.CutSuffix(tag, suffix); found {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		if p.stages[name] || p.branches[name] || p.commits[name] {
+			return ""
+		}
+	}
+	for _, name := range names {
+		if !commitTag.MatchString(name) {
+			continue
+		}
+		at, err := created()
+		if err != nil {
+			log.Warnf("<yellow>Unable to find age of '%s', keeping it: %s</yellow>\n", tag, err)
+			return ""
+		}
+		if at.IsZero() || now().Sub(at) < p.maxAge {
+			return ""
+		}
+		return fmt.Sprintf("commit image created %s is older than %s", at.Format(time.RFC3339), p.maxAge)
+	}
+	for _, name := range names {
+		for _, pattern := range p.branchTags {
+			if matched, _ := path.Match(pattern, name); matched {
+				return "branch no longer exists"
+			}
+		}
+	}
+	return ""
+}
+
+// created returns when the image tagged ref was created, for an index the first image is used
+func created(ref refname.Reference, options []remote.Option) (time.Time, error) {
+	desc, err := remote.Get(ref, options...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var img v1.Image
+	if desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return time.Time{}, err
+		}
+		manifest, err := index.IndexManifest()
+		if err != nil {
+			return time.Time{}, err
+		}
+		if len(manifest.Manifests) == 0 {
+			return time.Time{}, nil
+		}
+		img, err = index.Image(manifest.Manifests[0].Digest)
+		if err != nil {
+			return time.Time{}, err
+		}
+	} else if img, err = desc.Image(); err != nil {
+		return time.Time{}, err
+	}
+	config, err := img.ConfigFile()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return config.Created.Time, nil
+}
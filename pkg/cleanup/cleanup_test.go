@@ -0,0 +1,341 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cleanup
+
+import (
+	"fmt"
+	"io"
+	stdlog "log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	refname "github.com/google/go-containerregistry/pkg/name"
+	ggcr "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+
+	"github.com/buildtool/build-tools/pkg/config"
+	"github.com/buildtool/build-tools/pkg/vcs"
+	"github.com/buildtool/build-tools/pkg/version"
+)
+
+var (
+	oldCommit  = strings.Repeat("1", 40)
+	newCommit  = strings.Repeat("2", 40)
+	headCommit = strings.Repeat("3", 40)
+	today      = time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+)
+
+// setupRegistry starts an in-memory registry with images in repo/reponame for the tags, created at the given times
+func setupRegistry(t *testing.T, tags map[string]time.Time) string {
+	host, _ := setupRegistryWithDeletes(t, tags, nil)
+	return host
+}
+
+// setupRegistryWithDeletes is like setupRegistry, but deleting a manifest removes its tags like a distribution registry does,
+// and deleting the manifest of the failing tag fails. The returned function returns the deleted manifests
+func setupRegistryWithDeletes(t *testing.T, tags map[string]time.Time, failing *string) (string, func() []string) {
+	var deleted []string
+	var host string
+	handler := ggcr.New(ggcr.Logger(stdlog.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if digest, found := strings.CutPrefix(r.URL.Path, "/v2/repo/reponame/manifests/"); found && r.Method == http.MethodDelete && strings.HasPrefix(digest, "sha256:") {
+			repo, _ := refname.NewRepository(fmt.Sprintf("%s/repo/reponame", host))
+			if failing != nil {
+				if desc, err := remote.Head(repo.Tag(*failing)); err == nil && desc.Digest.String() == digest {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+					return
+				}
+			}
+			existing, _ := remote.List(repo)
+			for _, tag := range existing {
+				if desc, err := remote.Head(repo.Tag(tag)); err == nil && desc.Digest.String() == digest {
+					assert.NoError(t, remote.Delete(repo.Tag(tag)))
+				}
+			}
+			deleted = append(deleted, digest)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	host = strings.TrimPrefix(server.URL, "http://")
+	for tag, created := range tags {
+		img, err := random.Image(1024, 1)
+		assert.NoError(t, err)
+		img, err = mutate.CreatedAt(img, v1.Time{Time: created})
+		assert.NoError(t, err)
+		ref, err := refname.ParseReference(fmt.Sprintf("%s/repo/reponame:%s", host, tag))
+		assert.NoError(t, err)
+		assert.NoError(t, remote.Write(ref, img))
+	}
+	return host, func() []string { return deleted }
+}
+
+func testConfig(host string) *config.Config {
+	cfg := config.InitEmptyConfig()
+	cfg.CI.Gitlab.CIBuildName = "reponame"
+	cfg.VCS.VCS = vcs.NewMockVcsWithBranches(map[string]string{"main": headCommit, "feature/kept": newCommit})
+	cfg.Registry.Dockerhub.Namespace = fmt.Sprintf("%s/repo", host)
+	return cfg
+}
+
+func withNow(t *testing.T) {
+	original := now
+	now = func() time.Time { return today }
+	t.Cleanup(func() { now = original })
+}
+
+func remainingTags(t *testing.T, host string) []string {
+	repo, err := refname.NewRepository(fmt.Sprintf("%s/repo/reponame", host))
+	assert.NoError(t, err)
+	tags, err := remote.List(repo)
+	assert.NoError(t, err)
+	return tags
+}
+
+func TestCleanup(t *testing.T) {
+	withNow(t)
+	old := today.Add(-60 * 24 * time.Hour)
+	host := setupRegistry(t, map[string]time.Time{
+		oldCommit:             old,
+		newCommit:             today.Add(-time.Hour),
+		headCommit:            old,
+		"builder":             old,
+		"feature_gone":        old,
+		"feature_gone-alpine": old,
+		"feature_kept":        old,
+		"latest":              old,
+		"main-alpine":         old,
+		"prod":                old,
+		"release-2024-10":     old,
+		"abc1234":             old,
+		"def5678":             today.Add(-time.Hour),
+	})
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM scratch as builder\nFROM scratch"), 0666))
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	cfg := testConfig(host)
+	cfg.Build.Variants = []config.Variant{{}, {Suffix: "-alpine"}}
+
+	exitCode := cleanup(cfg, dir, Args{Dockerfile: "Dockerfile", MaxAge: 30 * 24 * time.Hour, Keep: []string{"latest*"}, BranchTags: []string{"*_*"}})
+
+	assert.Equal(t, 0, exitCode)
+	assert.Equal(t, []string{newCommit, headCommit, "builder", "def5678", "feature_kept", "latest", "main-alpine", "prod", "release-2024-10"}, remainingTags(t, host))
+	logMock.Check(t, []string{
+		"debug: Using registry <green>Dockerhub</green>\n",
+		fmt.Sprintf("info: Deleting '<green>%s/repo/reponame:%s</green>', commit image created 2024-08-02T12:00:00Z is older than 720h0m0s\n", host, oldCommit),
+		fmt.Sprintf("info: Deleting '<green>%s/repo/reponame:abc1234</green>', commit image created 2024-08-02T12:00:00Z is older than 720h0m0s\n", host),
+		fmt.Sprintf("info: Deleting '<green>%s/repo/reponame:feature_gone</green>', branch no longer exists\n", host),
+		fmt.Sprintf("info: Deleting '<green>%s/repo/reponame:feature_gone-alpine</green>', branch no longer exists\n", host),
+		"info: Deleted 4 of 13 tags\n",
+	})
+}
+
+func TestCleanup_DryRun(t *testing.T) {
+	withNow(t)
+	host := setupRegistry(t, map[string]time.Time{
+		"feature_gone": today,
+		"main":         today,
+	})
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+
+	exitCode := cleanup(testConfig(host), t.TempDir(), Args{Dockerfile: "Dockerfile", MaxAge: time.Hour, BranchTags: []string{"*_*"}, DryRun: true})
+
+	assert.Equal(t, 0, exitCode)
+	assert.Equal(t, []string{"feature_gone", "main"}, remainingTags(t, host))
+	logMock.Check(t, []string{
+		fmt.Sprintf("info: Would delete '<green>%s/repo/reponame:feature_gone</green>', branch no longer exists\n", host),
+		"info: 1 of 2 tags would be deleted\n",
+	})
+}
+
+func TestCleanup_UnknownCreated(t *testing.T) {
+	withNow(t)
+	host := setupRegistry(t, map[string]time.Time{oldCommit: {}})
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+
+	exitCode := cleanup(testConfig(host), t.TempDir(), Args{Dockerfile: "Dockerfile", MaxAge: time.Hour})
+
+	assert.Equal(t, 0, exitCode)
+	assert.Equal(t, []string{oldCommit}, remainingTags(t, host))
+	logMock.Check(t, []string{"info: Deleted 0 of 1 tags\n"})
+}
+
+func TestCleanup_NoBranches(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	cfg := testConfig("localhost")
+	cfg.VCS.VCS = vcs.NewMockVcs()
+
+	exitCode := cleanup(cfg, t.TempDir(), Args{})
+
+	assert.Equal(t, -3, exitCode)
+	logMock.Check(t, []string{"error: No branches other than the current one <red>found</red>. Cleanup must be run in a Git repository with the branches of the remote fetched, or with --force"})
+}
+
+func TestCleanup_OnlyCurrentBranch(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	cfg := testConfig("localhost")
+	cfg.VCS.VCS = vcs.NewMockVcsWithBranches(map[string]string{"main": headCommit})
+
+	exitCode := cleanup(cfg, t.TempDir(), Args{})
+
+	assert.Equal(t, -3, exitCode)
+	logMock.Check(t, []string{"error: No branches other than the current one <red>found</red>. Cleanup must be run in a Git repository with the branches of the remote fetched, or with --force"})
+}
+
+func TestCleanup_OnlyCurrentBranchForced(t *testing.T) {
+	withNow(t)
+	host := setupRegistry(t, map[string]time.Time{
+		"feature_gone": today,
+		"main":         today,
+	})
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	cfg := testConfig(host)
+	cfg.VCS.VCS = vcs.NewMockVcsWithBranches(map[string]string{"main": headCommit})
+
+	exitCode := cleanup(cfg, t.TempDir(), Args{Dockerfile: "Dockerfile", MaxAge: time.Hour, BranchTags: []string{"*_*"}, DryRun: true, Force: true})
+
+	assert.Equal(t, 0, exitCode)
+	logMock.Check(t, []string{
+		fmt.Sprintf("info: Would delete '<green>%s/repo/reponame:feature_gone</green>', branch no longer exists\n", host),
+		"info: 1 of 2 tags would be deleted\n",
+	})
+}
+
+func TestCleanup_SharedImage(t *testing.T) {
+	withNow(t)
+	host, deleted := setupRegistryWithDeletes(t, map[string]time.Time{"feature_gone": today, "feature_other": today}, nil)
+	repo, err := refname.NewRepository(fmt.Sprintf("%s/repo/reponame", host))
+	assert.NoError(t, err)
+	for source, tag := range map[string]string{"feature_gone": "prod", "feature_other": "feature_merged"} {
+		img, err := remote.Image(repo.Tag(source))
+		assert.NoError(t, err)
+		assert.NoError(t, remote.Tag(repo.Tag(tag), img))
+	}
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+
+	exitCode := cleanup(testConfig(host), t.TempDir(), Args{Dockerfile: "Dockerfile", MaxAge: time.Hour, BranchTags: []string{"*_*"}})
+
+	assert.Equal(t, 0, exitCode)
+	assert.Len(t, deleted(), 1)
+	assert.Equal(t, []string{"feature_gone", "prod"}, remainingTags(t, host))
+	logMock.Check(t, []string{
+		fmt.Sprintf("info: Keeping '<green>%s/repo/reponame:feature_gone</green>', branch no longer exists, the image is also tagged prod\n", host),
+		fmt.Sprintf("info: Deleting '<green>%s/repo/reponame:feature_merged</green>', branch no longer exists\n", host),
+		fmt.Sprintf("info: Deleting '<green>%s/repo/reponame:feature_other</green>', branch no longer exists\n", host),
+		"info: Deleted 2 of 4 tags\n",
+	})
+}
+
+func TestCleanup_DeleteFailed(t *testing.T) {
+	withNow(t)
+	failing := "feature_a"
+	host, deleted := setupRegistryWithDeletes(t, map[string]time.Time{"feature_a": today, "feature_b": today}, &failing)
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+
+	exitCode := cleanup(testConfig(host), t.TempDir(), Args{Dockerfile: "Dockerfile", MaxAge: time.Hour, BranchTags: []string{"*_*"}})
+
+	assert.Equal(t, -6, exitCode)
+	assert.Len(t, deleted(), 1)
+	assert.Equal(t, []string{"feature_a"}, remainingTags(t, host))
+	assert.Len(t, logMock.Logged, 5)
+	assert.Equal(t, "info: Deleted 1 of 2 tags\n", logMock.Logged[3])
+	assert.Equal(t, "error: <red>1 tags could not be deleted</red>", logMock.Logged[4])
+}
+
+func TestPolicy_DeleteReason(t *testing.T) {
+	withNow(t)
+	p := policy{
+		branches:   map[string]bool{"main": true},
+		commits:    map[string]bool{},
+		stages:     map[string]bool{},
+		branchTags: []string{"*_*"},
+		maxAge:     time.Hour,
+	}
+	old := func() (time.Time, error) { return today.Add(-2 * time.Hour), nil }
+	assert.Equal(t, "", p.deleteReason("main", old))
+	assert.Equal(t, "", p.deleteReason("prod", old))
+	assert.Equal(t, "", p.deleteReason("release-2024-10", old))
+	assert.Equal(t, "", p.deleteReason("abc123", old))
+	assert.Equal(t, "branch no longer exists", p.deleteReason("feature_gone", old))
+	assert.Equal(t, "commit image created 2024-10-01T10:00:00Z is older than 1h0m0s", p.deleteReason("abc1234", old))
+	assert.Equal(t, "commit image created 2024-10-01T10:00:00Z is older than 1h0m0s", p.deleteReason(strings.Repeat("a", 40), old))
+	assert.Equal(t, "", p.deleteReason(strings.Repeat("a", 41), old))
+}
+
+func TestCleanup_UnknownRegistry(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+
+	exitCode := cleanup(testConfig("localhost"), t.TempDir(), Args{Registry: "missing"})
+
+	assert.Equal(t, -3, exitCode)
+	logMock.Check(t, []string{"error: <red>no registry matching missing found</red>"})
+}
+
+func TestCleanup_MissingRepository(t *testing.T) {
+	host := setupRegistry(t, nil)
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+
+	exitCode := cleanup(testConfig(host), t.TempDir(), Args{})
+
+	assert.Equal(t, -5, exitCode)
+	assert.Len(t, logMock.Logged, 1)
+}
+
+func TestDoCleanup_BrokenConfig(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, ".buildtools.yaml"), []byte("ci: [] "), 0777))
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+
+	exitCode := DoCleanup(dir, version.Info{})
+
+	assert.Equal(t, -2, exitCode)
+}
@@ -43,12 +43,15 @@ import (
 )
 
 type Config struct {
-	VCS                 *VCSConfig        `yaml:"vcs"`
-	CI                  *CIConfig         `yaml:"ci"`
-	Registry            *RegistryConfig   `yaml:"registry"`
-	Targets             map[string]Target `yaml:"targets"`
-	Git                 Git               `yaml:"git"`
-	Gitops              map[string]Gitops `yaml:"gitops"`
+	VCS                 *VCSConfig                 `yaml:"vcs"`
+	CI                  *CIConfig                  `yaml:"ci"`
+	Registry            *RegistryConfig            `yaml:"registry"`
+	Registries          map[string]*RegistryConfig `yaml:"registries"`
+	Targets             map[string]Target          `yaml:"targets"`
+	Git                 Git                        `yaml:"git"`
+	Gitops              map[string]Gitops          `yaml:"gitops"`
+	Build               Build                      `yaml:"build"`
+	Push                Push                       `yaml:"push"`
 	AvailableCI         []ci.CI
 	AvailableRegistries []registry.Registry
 }
@@ -67,12 +70,17 @@ type CIConfig struct {
 }
 
 type RegistryConfig struct {
-	Dockerhub *registry.Dockerhub `yaml:"dockerhub"`
-	ECR       *registry.ECR       `yaml:"ecr"`
-	Github    *registry.Github    `yaml:"github"`
-	Gitlab    *registry.Gitlab    `yaml:"gitlab"`
-	Quay      *registry.Quay      `yaml:"quay"`
-	GCR       *registry.GCR       `yaml:"gcr"`
+	Dockerhub *registry.Dockerhub        `yaml:"dockerhub"`
+	ECR       *registry.ECR              `yaml:"ecr"`
+	ECRPublic *registry.ECRPublic        `yaml:"ecrPublic"`
+	Github    *registry.Github           `yaml:"github"`
+	Gitlab    *registry.Gitlab           `yaml:"gitlab"`
+	Quay      *registry.Quay             `yaml:"quay"`
+	GCR       *registry.GCR              `yaml:"gcr"`
+	GAR       *registry.ArtifactRegistry `yaml:"artifactRegistry"`
+	ACR       *registry.ACR              `yaml:"acr"`
+	Harbor    *registry.Harbor           `yaml:"harbor"`
+	Generic   *registry.Generic          `yaml:"generic"`
 }
 
 type Target struct {
@@ -82,9 +90,10 @@ type Target struct {
 }
 
 type Git struct {
-	Name  string `yaml:"name"`
-	Email string `yaml:"email"`
-	Key   string `yaml:"key"`
+	Name              string `yaml:"name"`
+	Email             string `yaml:"email"`
+	Key               string `yaml:"key"`
+	CredentialProcess string `yaml:"credentialProcess,omitempty" env:"GIT_CREDENTIAL_PROCESS"`
 }
 
 type Gitops struct {
@@ -92,6 +101,76 @@ type Gitops struct {
 	Path string `yaml:"path,omitempty"`
 }
 
+type Build struct {
+	Exports  map[string]Export `yaml:"exports,omitempty"`
+	Variants []Variant         `yaml:"variants,omitempty"`
+	Go       *GoBuild          `yaml:"go,omitempty"`
+	// Registries are names of registries in registries whose credentials are used when pulling images during the build
+	Registries []string `yaml:"registries,omitempty"`
+	// Mirrors maps upstream registries, like docker.io, to mirrors used for base images and caches
+	Mirrors map[string]string `yaml:"mirrors,omitempty"`
+}
+
+// Push configures the push command
+type Push struct {
+	// Registries are names of registries in registries which are pushed to in addition to the current registry
+	Registries []string `yaml:"registries,omitempty"`
+}
+
+// Variant is a flavour of the image, built from its own Dockerfile, target and build-args
+// and tagged with the regular tags with Suffix appended
+type Variant struct {
+	Suffix     string            `yaml:"suffix"`
+	Dockerfile string            `yaml:"dockerfile,omitempty"`
+	Target     string            `yaml:"target,omitempty"`
+	BuildArgs  map[string]string `yaml:"buildArgs,omitempty"`
+}
+
+// Export defines where the output of a Dockerfile stage should be written
+type Export struct {
+	Type string `yaml:"type,omitempty"`
+	Dest string `yaml:"dest,omitempty"`
+}
+
+// GoBuild configures building the image by compiling a Go binary and adding it on top of
+// a base image, without using Docker or BuildKit
+type GoBuild struct {
+	Main      string   `yaml:"main,omitempty"`
+	Binary    string   `yaml:"binary,omitempty"`
+	Base      string   `yaml:"base,omitempty"`
+	Platforms []string `yaml:"platforms,omitempty"`
+	Ldflags   string   `yaml:"ldflags,omitempty"`
+	Flags     []string `yaml:"flags,omitempty"`
+	Env       []string `yaml:"env,omitempty"`
+	Output    string   `yaml:"output,omitempty"`
+}
+
+const (
+	DefaultGoMain   = "."
+	DefaultGoBase   = "gcr.io/distroless/static:nonroot"
+	DefaultGoOutput = ".buildtools/oci"
+)
+
+// OutputPath returns the path of the OCI layout where built images are stored until pushed
+func (g GoBuild) OutputPath(dir string) string {
+	if g.Output == "" {
+		return filepath.Join(dir, DefaultGoOutput)
+	}
+	if filepath.IsAbs(g.Output) {
+		return g.Output
+	}
+	return filepath.Join(dir, g.Output)
+}
+
+const (
+	ExportLocal = "local"
+	ExportTar   = "tar"
+	ExportOCI   = "oci"
+)
+
+// DefaultExport is used for stages with a name starting with `export` which are not explicitly configured
+var DefaultExport = Export{Type: ExportLocal, Dest: "exported"}
+
 const envBuildtoolsContent = "BUILDTOOLS_CONTENT"
 
 func Load(dir string) (*Config, error) {
@@ -101,11 +180,11 @@ func Load(dir string) (*Config, error) {
 		log.Debugf("Parsing config from env: %s\n", envBuildtoolsContent)
 		if decoded, err := base64.StdEncoding.DecodeString(content); err != nil {
 			log.Debugf("Failed to decode BASE64, falling back to plaintext\n")
-			if err := parseConfig([]byte(content), cfg); err != nil {
+			if err := parseConfig([]byte(content), dir, cfg); err != nil {
 				return cfg, err
 			}
 		} else {
-			if err := parseConfig(decoded, cfg); err != nil {
+			if err := parseConfig(decoded, dir, cfg); err != nil {
 				return cfg, err
 			}
 		}
@@ -141,14 +220,19 @@ func InitEmptyConfig() *Config {
 		Registry: &RegistryConfig{
 			Dockerhub: &registry.Dockerhub{},
 			ECR:       &registry.ECR{},
+			ECRPublic: &registry.ECRPublic{},
 			Github:    &registry.Github{},
 			Gitlab:    &registry.Gitlab{},
 			Quay:      &registry.Quay{},
 			GCR:       &registry.GCR{},
+			GAR:       &registry.ArtifactRegistry{},
+			ACR:       &registry.ACR{},
+			Harbor:    &registry.Harbor{},
+			Generic:   &registry.Generic{},
 		},
 	}
 	c.AvailableCI = []ci.CI{c.CI.Azure, c.CI.Buildkite, c.CI.Gitlab, c.CI.TeamCity, c.CI.Github}
-	c.AvailableRegistries = []registry.Registry{c.Registry.Dockerhub, c.Registry.ECR, c.Registry.Github, c.Registry.Gitlab, c.Registry.Quay, c.Registry.GCR}
+	c.AvailableRegistries = []registry.Registry{c.Registry.Dockerhub, c.Registry.ECR, c.Registry.ECRPublic, c.Registry.Github, c.Registry.Gitlab, c.Registry.Quay, c.Registry.GCR, c.Registry.GAR, c.Registry.ACR, c.Registry.Harbor, c.Registry.Generic}
 	return c
 }
 
@@ -179,6 +263,78 @@ func (c *Config) CurrentRegistry() registry.Registry {
 	return registry.NoDockerRegistry{}
 }
 
+// NamedRegistry returns the registry configured with name in registries, or the current registry if name is empty
+func (c *Config) NamedRegistry(name string) (registry.Registry, error) {
+	if name == "" {
+		return c.CurrentRegistry(), nil
+	}
+	if r, exists := c.Registries[name]; exists {
+		if configured := r.configured(); len(configured) == 1 {
+			return configured[0], nil
+		}
+	}
+	return nil, fmt.Errorf("no registry matching %s found", name)
+}
+
+// PushRegistries returns the current registry followed by the registries listed in push
+func (c *Config) PushRegistries() ([]registry.Registry, error) {
+	registries := []registry.Registry{c.CurrentRegistry()}
+	for _, name := range c.Push.Registries {
+		reg, err := c.NamedRegistry(name)
+		if err != nil {
+			return nil, err
+		}
+		registries = append(registries, reg)
+	}
+	return registries, nil
+}
+
+// BuildRegistries returns the registries listed in build
+func (c *Config) BuildRegistries() ([]registry.Registry, error) {
+	var registries []registry.Registry
+	for _, name := range c.Build.Registries {
+		reg, err := c.NamedRegistry(name)
+		if err != nil {
+			return nil, err
+		}
+		registries = append(registries, reg)
+	}
+	return registries, nil
+}
+
+// configured returns the registries which are configured in the block
+func (r *RegistryConfig) configured() []registry.Registry {
+	var result []registry.Registry
+	elem := reflect.ValueOf(r).Elem()
+	for i := 0; i < elem.NumField(); i++ {
+		f := elem.Field(i)
+		if f.IsNil() {
+			continue
+		}
+		if reg := f.Interface().(registry.Registry); reg.Configured() {
+			result = append(result, reg)
+		}
+	}
+	return result
+}
+
+// resolvePaths resolves relative paths in the settings of the registries against dir
+func (r *RegistryConfig) resolvePaths(dir string) {
+	if r == nil {
+		return
+	}
+	elem := reflect.ValueOf(r).Elem()
+	for i := 0; i < elem.NumField(); i++ {
+		f := elem.Field(i)
+		if f.IsNil() {
+			continue
+		}
+		if p, ok := f.Interface().(registry.PathResolver); ok {
+			p.ResolvePaths(dir)
+		}
+	}
+}
+
 func (c *Config) Print(target io.Writer) error {
 	p := struct {
 		CI       string            `yaml:"ci"`
@@ -206,6 +362,40 @@ func (c *Config) CurrentTarget(target string) (*Target, error) {
 	return nil, fmt.Errorf("no target matching %s found", target)
 }
 
+// StageExport returns the export to use for the named stage, or nil if the stage should be built as an image
+func (c *Config) StageExport(stage string) *Export {
+	if e, exists := c.Build.Exports[stage]; exists {
+		if e.Type == "" {
+			e.Type = ExportLocal
+		}
+		if e.Dest == "" {
+			e.Dest = DefaultExport.Dest
+		}
+		return &e
+	}
+	if strings.HasPrefix(stage, "export") {
+		e := DefaultExport
+		return &e
+	}
+	return nil
+}
+
+// BuildVariants returns the configured variants, using dockerfile for variants not specifying one.
+// If no variants are configured a single variant without suffix is returned.
+func (c *Config) BuildVariants(dockerfile string) []Variant {
+	if len(c.Build.Variants) == 0 {
+		return []Variant{{Dockerfile: dockerfile}}
+	}
+	var variants []Variant
+	for _, v := range c.Build.Variants {
+		if v.Dockerfile == "" {
+			v.Dockerfile = dockerfile
+		}
+		variants = append(variants, v)
+	}
+	return variants
+}
+
 func (c *Config) CurrentGitops(target string) (*Gitops, error) {
 	if e, exists := c.Gitops[target]; exists {
 		return &e, nil
@@ -251,14 +441,19 @@ func parseConfigFile(filename string, cfg *Config) error {
 	if err != nil {
 		return err
 	}
-	return parseConfig(data, cfg)
+	return parseConfig(data, filepath.Dir(filename), cfg)
 }
 
-func parseConfig(content []byte, config *Config) error {
+// parseConfig merges the content into config, with paths in it relative to dir
+func parseConfig(content []byte, dir string, config *Config) error {
 	temp := &Config{}
 	if err := UnmarshalStrict(content, temp); err != nil {
 		return err
 	} else {
+		temp.Registry.resolvePaths(dir)
+		for _, r := range temp.Registries {
+			r.resolvePaths(dir)
+		}
 		if err := mergo.Merge(config, temp); err != nil {
 			return err
 		}
@@ -277,15 +472,36 @@ func UnmarshalStrict(content []byte, out interface{}) error {
 }
 
 func validate(config *Config) error {
-	elem := reflect.ValueOf(config.Registry).Elem()
-	found := false
-	for i := 0; i < elem.NumField(); i++ {
-		f := elem.Field(i)
-		if f.Interface().(registry.Registry).Configured() {
-			if found {
-				return fmt.Errorf("registry already defined, please check configuration")
+	if len(config.Registry.configured()) > 1 {
+		return fmt.Errorf("registry already defined, please check configuration")
+	}
+	for name, r := range config.Registries {
+		if r == nil || len(r.configured()) != 1 {
+			return fmt.Errorf("registry '%s' must define exactly one registry, please check configuration", name)
+		}
+	}
+
+	if config.Build.Go != nil && len(config.Build.Variants) > 0 {
+		return fmt.Errorf("variants are not supported for go builds, please check configuration")
+	}
+
+	suffixes := make(map[string]bool)
+	for _, variant := range config.Build.Variants {
+		if suffixes[variant.Suffix] {
+			return fmt.Errorf("variant suffix '%s' already defined, please check configuration", variant.Suffix)
+		}
+		suffixes[variant.Suffix] = true
+	}
+
+	for stage, export := range config.Build.Exports {
+		switch export.Type {
+		case "", ExportLocal:
+		case ExportTar, ExportOCI:
+			if export.Dest == "" {
+				return fmt.Errorf("export for stage '%s' of type '%s' must specify dest", stage, export.Type)
 			}
-			found = true
+		default:
+			return fmt.Errorf("unsupported export type '%s' for stage '%s', must be one of %s, %s or %s", export.Type, stage, ExportLocal, ExportTar, ExportOCI)
 		}
 	}
 
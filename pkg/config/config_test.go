@@ -325,3 +325,271 @@ targets:
 	assert.EqualError(t, err, "registry already defined, please check configuration")
 	logMock.Check(t, []string{fmt.Sprintf("debug: Parsing config from file: <green>'%s/.buildtools.yaml'</green>\n", name)})
 }
+
+func TestLoad_YAML_ECR_Repository(t *testing.T) {
+	name, _ := os.MkdirTemp(os.TempDir(), "build-tools")
+	defer func() { _ = os.RemoveAll(name) }()
+	yaml := `
+registry:
+  ecr:
+    url: 1234.dkr.ecr.eu-west-1.amazonaws.com
+    repository:
+      lifecyclePolicy: ecr/lifecycle.json
+      immutableTags: true
+      scanOnPush: true
+      kmsKey: key
+      tags:
+        team: platform
+      reconcile: true
+`
+	_ = os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0777)
+
+	cfg, err := Load(name)
+	assert.NoError(t, err)
+	enabled := true
+	assert.Equal(t, &registry.ECRRepository{
+		LifecyclePolicy: filepath.Join(name, "ecr", "lifecycle.json"),
+		ImmutableTags:   &enabled,
+		ScanOnPush:      &enabled,
+		KMSKey:          "key",
+		Tags:            map[string]string{"team": "platform"},
+		Reconcile:       true,
+	}, cfg.Registry.ECR.Repository)
+}
+
+func TestLoad_YAML_ECR_Repository_ParentConfig(t *testing.T) {
+	name, _ := os.MkdirTemp(os.TempDir(), "build-tools")
+	defer func() { _ = os.RemoveAll(name) }()
+	yaml := `
+registries:
+  production:
+    ecr:
+      url: 1234.dkr.ecr.eu-west-1.amazonaws.com
+      repository:
+        policy: policy.json
+`
+	_ = os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0777)
+	project := filepath.Join(name, "project")
+	_ = os.Mkdir(project, 0777)
+
+	cfg, err := Load(project)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(name, "policy.json"), cfg.Registries["production"].ECR.Repository.Policy)
+}
+
+func TestLoad_YAML_Dockerhub_Readme(t *testing.T) {
+	name, _ := os.MkdirTemp(os.TempDir(), "build-tools")
+	defer func() { _ = os.RemoveAll(name) }()
+	yaml := `
+registry:
+  dockerhub:
+    namespace: team
+    readme: README.md
+`
+	_ = os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0777)
+	project := filepath.Join(name, "project")
+	_ = os.Mkdir(project, 0777)
+
+	cfg, err := Load(project)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(name, "README.md"), cfg.Registry.Dockerhub.Readme)
+}
+
+func TestLoad_YAML_Build_Exports(t *testing.T) {
+	name, _ := os.MkdirTemp(os.TempDir(), "build-tools")
+	defer func() { _ = os.RemoveAll(name) }()
+	yaml := `
+build:
+  exports:
+    test:
+      type: tar
+      dest: reports.tar
+    binaries:
+      dest: bin
+`
+	_ = os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0777)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	cfg, err := Load(name)
+	assert.NoError(t, err)
+	assert.Equal(t, &Export{Type: ExportTar, Dest: "reports.tar"}, cfg.StageExport("test"))
+	assert.Equal(t, &Export{Type: ExportLocal, Dest: "bin"}, cfg.StageExport("binaries"))
+	assert.Equal(t, &Export{Type: ExportLocal, Dest: "exported"}, cfg.StageExport("export-files"))
+	assert.Nil(t, cfg.StageExport("build"))
+	logMock.Check(t, []string{fmt.Sprintf("debug: Parsing config from file: <green>'%s/.buildtools.yaml'</green>\n", name)})
+}
+
+func TestLoad_YAML_Build_Exports_Invalid(t *testing.T) {
+	name, _ := os.MkdirTemp(os.TempDir(), "build-tools")
+	defer func() { _ = os.RemoveAll(name) }()
+
+	_ = os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(`
+build:
+  exports:
+    test:
+      type: zip
+`), 0777)
+	_, err := Load(name)
+	assert.EqualError(t, err, "unsupported export type 'zip' for stage 'test', must be one of local, tar or oci")
+
+	_ = os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(`
+build:
+  exports:
+    test:
+      type: oci
+`), 0777)
+	_, err = Load(name)
+	assert.EqualError(t, err, "export for stage 'test' of type 'oci' must specify dest")
+}
+
+func TestLoad_YAML_Build_Variants(t *testing.T) {
+	name, _ := os.MkdirTemp(os.TempDir(), "build-tools")
+	defer func() { _ = os.RemoveAll(name) }()
+	yaml := `
+build:
+  variants:
+    - suffix: -alpine
+    - suffix: -debug
+      dockerfile: Dockerfile.debug
+      target: debug
+      buildArgs:
+        DEBUG: "true"
+`
+	_ = os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0777)
+
+	cfg, err := Load(name)
+	assert.NoError(t, err)
+	assert.Equal(t, []Variant{
+		{Suffix: "-alpine", Dockerfile: "Dockerfile"},
+		{Suffix: "-debug", Dockerfile: "Dockerfile.debug", Target: "debug", BuildArgs: map[string]string{"DEBUG": "true"}},
+	}, cfg.BuildVariants("Dockerfile"))
+	assert.Equal(t, []Variant{{Dockerfile: "Dockerfile"}}, InitEmptyConfig().BuildVariants("Dockerfile"))
+}
+
+func TestLoad_YAML_Registries(t *testing.T) {
+	name, _ := os.MkdirTemp(os.TempDir(), "build-tools")
+	defer func() { _ = os.RemoveAll(name) }()
+	yaml := `
+registry:
+  dockerhub:
+    namespace: dockerhub
+registries:
+  staging:
+    ecr:
+      url: 1111.dkr.ecr.eu-west-1.amazonaws.com
+      region: eu-west-1
+  production:
+    quay:
+      repository: org
+`
+	_ = os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0777)
+
+	cfg, err := Load(name)
+	assert.NoError(t, err)
+	current, err := cfg.NamedRegistry("")
+	assert.NoError(t, err)
+	assert.Equal(t, "dockerhub", current.RegistryUrl())
+	staging, err := cfg.NamedRegistry("staging")
+	assert.NoError(t, err)
+	assert.Equal(t, "1111.dkr.ecr.eu-west-1.amazonaws.com", staging.RegistryUrl())
+	production, err := cfg.NamedRegistry("production")
+	assert.NoError(t, err)
+	assert.Equal(t, "quay.io/org", production.RegistryUrl())
+	_, err = cfg.NamedRegistry("missing")
+	assert.EqualError(t, err, "no registry matching missing found")
+}
+
+func TestLoad_YAML_PushRegistries(t *testing.T) {
+	name, _ := os.MkdirTemp(os.TempDir(), "build-tools")
+	defer func() { _ = os.RemoveAll(name) }()
+	yaml := `
+registry:
+  dockerhub:
+    namespace: dockerhub
+registries:
+  production:
+    quay:
+      repository: org
+push:
+  registries:
+    - production
+`
+	_ = os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0777)
+
+	cfg, err := Load(name)
+	assert.NoError(t, err)
+	registries, err := cfg.PushRegistries()
+	assert.NoError(t, err)
+	assert.Len(t, registries, 2)
+	assert.Equal(t, "dockerhub", registries[0].RegistryUrl())
+	assert.Equal(t, "quay.io/org", registries[1].RegistryUrl())
+
+	cfg.Push.Registries = []string{"missing"}
+	_, err = cfg.PushRegistries()
+	assert.EqualError(t, err, "no registry matching missing found")
+}
+
+func TestLoad_YAML_BuildRegistries(t *testing.T) {
+	name, _ := os.MkdirTemp(os.TempDir(), "build-tools")
+	defer func() { _ = os.RemoveAll(name) }()
+	yaml := `
+registry:
+  dockerhub:
+    namespace: dockerhub
+registries:
+  base:
+    harbor:
+      url: harbor.example.com
+      project: base
+build:
+  registries:
+    - base
+`
+	_ = os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0777)
+
+	cfg, err := Load(name)
+	assert.NoError(t, err)
+	registries, err := cfg.BuildRegistries()
+	assert.NoError(t, err)
+	assert.Len(t, registries, 1)
+	assert.Equal(t, "harbor.example.com/base", registries[0].RegistryUrl())
+
+	cfg.Build.Registries = []string{"missing"}
+	_, err = cfg.BuildRegistries()
+	assert.EqualError(t, err, "no registry matching missing found")
+}
+
+func TestLoad_YAML_Registries_Invalid(t *testing.T) {
+	name, _ := os.MkdirTemp(os.TempDir(), "build-tools")
+	defer func() { _ = os.RemoveAll(name) }()
+	yaml := `
+registries:
+  staging:
+    dockerhub:
+      namespace: dockerhub
+    quay:
+      repository: quay.io/org
+`
+	_ = os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0777)
+
+	_, err := Load(name)
+	assert.EqualError(t, err, "registry 'staging' must define exactly one registry, please check configuration")
+}
+
+func TestLoad_YAML_Build_Variants_DuplicateSuffix(t *testing.T) {
+	name, _ := os.MkdirTemp(os.TempDir(), "build-tools")
+	defer func() { _ = os.RemoveAll(name) }()
+	yaml := `
+build:
+  variants:
+    - suffix: -alpine
+    - suffix: -alpine
+      target: debug
+`
+	_ = os.WriteFile(filepath.Join(name, ".buildtools.yaml"), []byte(yaml), 0777)
+
+	_, err := Load(name)
+	assert.EqualError(t, err, "variant suffix '-alpine' already defined, please check configuration")
+}
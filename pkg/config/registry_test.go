@@ -95,6 +95,60 @@ func TestEcr_Identify_MissingDockerRegistry(t *testing.T) {
 	assert.Equal(t, "", out.String())
 }
 
+func TestEcrPublic_Identify(t *testing.T) {
+	defer pkg.SetEnv("ECR_PUBLIC_ALIAS", "alias")()
+
+	cfg, err := Load(name)
+	assert.NoError(t, err)
+	registry := cfg.CurrentRegistry()
+	assert.Equal(t, "ECR Public", registry.Name())
+	assert.Equal(t, "public.ecr.aws/alias", registry.RegistryUrl())
+}
+
+func TestAcr_Identify(t *testing.T) {
+	defer pkg.SetEnv("ACR_URL", "example.azurecr.io")()
+
+	cfg, err := Load(name)
+	assert.NoError(t, err)
+	registry := cfg.CurrentRegistry()
+	assert.Equal(t, "ACR", registry.Name())
+	assert.Equal(t, "example.azurecr.io", registry.RegistryUrl())
+}
+
+func TestArtifactRegistry_Identify(t *testing.T) {
+	defer pkg.SetEnv("GAR_URL", "europe-north1-docker.pkg.dev/project/repository")()
+	defer pkg.SetEnv("GAR_ACCESS_TOKEN", "token")()
+
+	cfg, err := Load(name)
+	assert.NoError(t, err)
+	registry := cfg.CurrentRegistry()
+	assert.Equal(t, "Artifact Registry", registry.Name())
+	assert.Equal(t, "europe-north1-docker.pkg.dev/project/repository", registry.RegistryUrl())
+}
+
+func TestHarbor_Identify(t *testing.T) {
+	defer pkg.SetEnv("HARBOR_URL", "harbor.example.com")()
+	defer pkg.SetEnv("HARBOR_PROJECT", "project")()
+
+	cfg, err := Load(name)
+	assert.NoError(t, err)
+	registry := cfg.CurrentRegistry()
+	assert.Equal(t, "Harbor", registry.Name())
+	assert.Equal(t, "harbor.example.com/project", registry.RegistryUrl())
+}
+
+func TestGeneric_Identify(t *testing.T) {
+	defer pkg.SetEnv("GENERIC_REGISTRY_URL", "localhost:5000/team")()
+	defer pkg.SetEnv("GENERIC_REGISTRY_INSECURE", "true")()
+
+	cfg, err := Load(name)
+	assert.NoError(t, err)
+	reg := cfg.CurrentRegistry()
+	assert.Equal(t, "Generic", reg.Name())
+	assert.Equal(t, "localhost:5000/team", reg.RegistryUrl())
+	assert.True(t, cfg.Registry.Generic.Insecure)
+}
+
 func TestGitlab_Identify(t *testing.T) {
 	defer pkg.SetEnv("CI_REGISTRY", "registry.gitlab.com")()
 	defer pkg.SetEnv("CI_REGISTRY_USER", "gitlab-ci-token")()
@@ -0,0 +1,118 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package credentials runs external credential processes, like the credential_process of the AWS CLI,
+// so secrets can be fetched from password managers and token brokers instead of being configured
+package credentials
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/google/shlex"
+)
+
+// Credentials is the JSON printed on stdout by a credential process
+type Credentials struct {
+	// Version of the format, only 1 is supported
+	Version  int    `json:"Version"`
+	Username string `json:"Username"`
+	Password string `json:"Password"`
+	// Expiration is when the credentials expire, in RFC 3339 format. Credentials without one never expire
+	Expiration *time.Time `json:"Expiration,omitempty"`
+}
+
+// expiryWindow is how long before their expiration credentials are fetched again
+const expiryWindow = time.Minute
+
+var (
+	now   = time.Now
+	mu    sync.Mutex
+	cache = map[string]Credentials{}
+)
+
+// Process runs command and returns the credentials it prints, reusing earlier credentials
+// of the same command until they are about to expire. The command is the credentialProcess of a registry,
+// used instead of its other credentials, or of the Git configuration, used instead of the SSH key
+// for HTTPS access by promote. It is split into arguments like a shell would, but not run by a shell
+func Process(command string) (Credentials, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if creds, exists := cache[command]; exists && !creds.expired() {
+		return creds, nil
+	}
+	creds, err := run(command)
+	if err != nil {
+		return Credentials{}, err
+	}
+	cache[command] = creds
+	return creds, nil
+}
+
+func (c Credentials) expired() bool {
+	return Expired(c.Expiration)
+}
+
+// Expired returns true if credentials expiring at expiration should be fetched again,
+// credentials without an expiration never expire
+func Expired(expiration *time.Time) bool {
+	return expiration != nil && now().Add(expiryWindow).After(*expiration)
+}
+
+func run(command string) (Credentials, error) {
+	args, err := shlex.Split(command)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("unable to parse credential process '%s': %w", command, err)
+	}
+	if len(args) == 0 {
+		return Credentials{}, errors.New("empty credential process")
+	}
+	log.Debugf("Running credential process '<green>%s</green>'\n", args[0])
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return Credentials{}, fmt.Errorf("credential process '%s' failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	var creds Credentials
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return Credentials{}, fmt.Errorf("unable to parse output of credential process '%s': %w", args[0], err)
+	}
+	if creds.Version != 1 {
+		return Credentials{}, fmt.Errorf("unsupported version %d of credential process '%s' output, expected 1", creds.Version, args[0])
+	}
+	if creds.Password == "" {
+		return Credentials{}, fmt.Errorf("credential process '%s' returned no password", args[0])
+	}
+	if creds.expired() {
+		return Credentials{}, fmt.Errorf("credential process '%s' returned expired credentials", args[0])
+	}
+	return creds, nil
+}
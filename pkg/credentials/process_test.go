@@ -0,0 +1,121 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package credentials
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeProcess writes a credential process printing output, which counts its invocations in the returned file
+func writeProcess(t *testing.T, output string) (string, string) {
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := filepath.Join(dir, "process")
+	content := fmt.Sprintf("#!/bin/sh\necho call $@ >> %s\ncat <<'JSON'\n%s\nJSON\n", calls, output)
+	assert.NoError(t, os.WriteFile(script, []byte(content), 0700))
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(cache, script)
+		delete(cache, script+" --profile ci")
+	})
+	return script, calls
+}
+
+func invocations(t *testing.T, calls string) []string {
+	content, err := os.ReadFile(calls)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	assert.NoError(t, err)
+	return strings.Split(strings.TrimSpace(string(content)), "\n")
+}
+
+func TestProcess(t *testing.T) {
+	script, calls := writeProcess(t, `{"Version":1,"Username":"user","Password":"secret"}`)
+
+	creds, err := Process(script + " --profile ci")
+	assert.NoError(t, err)
+	assert.Equal(t, "user", creds.Username)
+	assert.Equal(t, "secret", creds.Password)
+	assert.Nil(t, creds.Expiration)
+	assert.Equal(t, []string{"call --profile ci"}, invocations(t, calls))
+}
+
+func TestProcess_Cached(t *testing.T) {
+	script, calls := writeProcess(t, `{"Version":1,"Username":"user","Password":"secret","Expiration":"2026-01-01T12:00:00Z"}`)
+	now = func() time.Time { return time.Date(2026, 1, 1, 11, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+
+	_, err := Process(script)
+	assert.NoError(t, err)
+	creds, err := Process(script)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", creds.Password)
+	assert.Equal(t, time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC), *creds.Expiration)
+	assert.Len(t, invocations(t, calls), 1)
+
+	now = func() time.Time { return time.Date(2026, 1, 1, 11, 59, 30, 0, time.UTC) }
+	_, err = Process(script)
+	assert.EqualError(t, err, fmt.Sprintf("credential process '%s' returned expired credentials", script))
+	assert.Len(t, invocations(t, calls), 2)
+}
+
+func TestProcess_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		err    string
+	}{
+		{name: "invalid json", output: `not json`, err: "unable to parse output of credential process '%s': invalid character 'o' in literal null (expecting 'u')"},
+		{name: "unsupported version", output: `{"Version":2,"Password":"secret"}`, err: "unsupported version 2 of credential process '%s' output, expected 1"},
+		{name: "no password", output: `{"Version":1,"Username":"user"}`, err: "credential process '%s' returned no password"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script, _ := writeProcess(t, tt.output)
+			_, err := Process(script)
+			assert.EqualError(t, err, fmt.Sprintf(tt.err, script))
+		})
+	}
+}
+
+func TestProcess_Failed(t *testing.T) {
+	_, err := Process(`sh -c "echo locked >&2; exit 3"`)
+	assert.EqualError(t, err, "credential process 'sh' failed: exit status 3: locked")
+}
+
+func TestProcess_InvalidCommand(t *testing.T) {
+	_, err := Process(`tool "unterminated`)
+	assert.EqualError(t, err, "unable to parse credential process 'tool \"unterminated': EOF found when expecting closing quote")
+
+	_, err = Process(" ")
+	assert.EqualError(t, err, "empty credential process")
+}
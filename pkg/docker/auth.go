@@ -31,9 +31,10 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/apex/log"
 	authutil "github.com/containerd/containerd/remotes/docker/auth"
-
 	"github.com/docker/docker/api/types/registry"
+	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/moby/buildkit/session"
 	"github.com/moby/buildkit/session/auth"
 	"golang.org/x/crypto/nacl/sign"
@@ -41,14 +42,27 @@ import (
 )
 
 type authenticator struct {
-	authConfig   registry.AuthConfig
-	registryHost string
+	auths map[string]registry.AuthConfig
+}
+
+// NewAuthenticator returns an authenticator providing the credentials in auths, keyed by registry host,
+// to BuildKit. Credentials for other hosts are looked up like docker login stores them.
+func NewAuthenticator(auths map[string]registry.AuthConfig) Authenticator {
+	return newAuthenticator(auths)
+}
+
+// NewKeychain returns a keychain for registry API calls, providing the same credentials as NewAuthenticator
+func NewKeychain(auths map[string]registry.AuthConfig) authn.Keychain {
+	return &keychain{authenticator: newAuthenticator(auths)}
 }
 
-func NewAuthenticator(registryHost string, authConfig registry.AuthConfig) Authenticator {
+func newAuthenticator(auths map[string]registry.AuthConfig) *authenticator {
+	normalized := make(map[string]registry.AuthConfig, len(auths))
+	for host, authConfig := range auths {
+		normalized[configKey(host)] = authConfig
+	}
 	return &authenticator{
-		authConfig:   authConfig,
-		registryHost: registryHost,
+		auths: normalized,
 	}
 }
 
@@ -56,14 +70,37 @@ func (a *authenticator) Register(server *grpc.Server) {
 	auth.RegisterAuthServer(server, a)
 }
 
+// Credentials returns the identity token, like the refresh token of az acr login, as secret without a username,
+// or the username and password, like docker does
 func (a authenticator) Credentials(ctx context.Context, req *auth.CredentialsRequest) (*auth.CredentialsResponse, error) {
-	if req.Host != a.registryHost {
-		return &auth.CredentialsResponse{}, nil
+	authConfig := a.credentials(req.Host)
+	if authConfig.IdentityToken != "" {
+		return &auth.CredentialsResponse{Secret: authConfig.IdentityToken}, nil
+	}
+	return &auth.CredentialsResponse{Username: authConfig.Username, Secret: authConfig.Password}, nil
+}
+
+// credentials returns the credentials given for the host, or the credentials
+// stored by docker login or a credential helper if none were given
+func (a authenticator) credentials(host string) registry.AuthConfig {
+	if authConfig, exists := a.auths[configKey(host)]; exists && HasCredentials(authConfig) {
+		return authConfig
 	}
-	return &auth.CredentialsResponse{Username: a.authConfig.Username, Secret: a.authConfig.Password}, nil
+	authConfig, err := ConfigCredentials(host)
+	if err != nil {
+		log.Debugf("Unable to get Docker credentials for <green>%s</green>: %s\n", host, err)
+		return registry.AuthConfig{}
+	}
+	return authConfig
 }
 
+// FetchToken returns a configured registry token as bearer token, or fetches one anonymously or using the credentials.
+// An identity token is exchanged for a token using OAuth
 func (a authenticator) FetchToken(ctx context.Context, req *auth.FetchTokenRequest) (*auth.FetchTokenResponse, error) {
+	authConfig := a.credentials(req.Host)
+	if authConfig.RegistryToken != "" {
+		return toTokenResponse(authConfig.RegistryToken, time.Time{}, 0), nil
+	}
 	to := authutil.TokenOptions{
 		Realm:    req.Realm,
 		Service:  req.Service,
@@ -75,8 +112,16 @@ func (a authenticator) FetchToken(ctx context.Context, req *auth.FetchTokenReque
 	resp, err := authutil.FetchToken(ctx, http.DefaultClient, nil, to)
 	if err != nil {
 		// try with auth
-		to.Username = a.authConfig.Username
-		to.Secret = a.authConfig.Password
+		if authConfig.IdentityToken != "" {
+			to.Secret = authConfig.IdentityToken
+			oauth, err := authutil.FetchTokenWithOAuth(ctx, http.DefaultClient, nil, "buildtools", to)
+			if err != nil {
+				return nil, fmt.Errorf("failed to fetch anonymous and identity token authenticated token, %w", err)
+			}
+			return toTokenResponse(oauth.AccessToken, oauth.IssuedAt, oauth.ExpiresIn), nil
+		}
+		to.Username = authConfig.Username
+		to.Secret = authConfig.Password
 		resp, err = authutil.FetchToken(ctx, http.DefaultClient, nil, to)
 		if err != nil {
 			return nil, fmt.Errorf("failed to fetch anonymous and authenticated token, %w", err)
@@ -102,6 +147,24 @@ func (ap *authenticator) VerifyTokenAuthority(ctx context.Context, req *auth.Ver
 
 var _ Authenticator = &authenticator{}
 
+type keychain struct {
+	authenticator *authenticator
+}
+
+func (k *keychain) Resolve(resource authn.Resource) (authn.Authenticator, error) {
+	authConfig := k.authenticator.credentials(resource.RegistryStr())
+	if !HasCredentials(authConfig) {
+		return authn.Anonymous, nil
+	}
+	return authn.FromConfig(authn.AuthConfig{
+		Username:      authConfig.Username,
+		Password:      authConfig.Password,
+		Auth:          authConfig.Auth,
+		IdentityToken: authConfig.IdentityToken,
+		RegistryToken: authConfig.RegistryToken,
+	}), nil
+}
+
 type Authenticator interface {
 	auth.AuthServer
 	session.Attachable
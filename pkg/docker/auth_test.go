@@ -24,18 +24,26 @@ package docker
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/docker/docker/api/types/registry"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
 	auth2 "github.com/moby/buildkit/session/auth"
 	"github.com/stretchr/testify/require"
 )
 
 func Test_Credentials(t *testing.T) {
-	auth := NewAuthenticator("use-auth.com", registry.AuthConfig{
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	auth := NewAuthenticator(map[string]registry.AuthConfig{"use-auth.com": {
 		Username: "user",
 		Password: "password",
-	})
+	}})
 	anonymousCreds, err := auth.Credentials(context.TODO(), &auth2.CredentialsRequest{Host: "docker.io"})
 	require.NoError(t, err)
 	require.Equal(t, "", anonymousCreds.Username)
@@ -45,5 +53,147 @@ func Test_Credentials(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "user", creds.Username)
 	require.Equal(t, "password", creds.Secret)
+}
+
+func Test_Credentials_DockerConfig(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"auths":{"base.example.com":{"auth":"YmFzZTpzZWNyZXQ="}}}`), 0600))
+	auth := NewAuthenticator(map[string]registry.AuthConfig{"use-auth.com": {Username: "user", Password: "password"}})
+
+	creds, err := auth.Credentials(context.TODO(), &auth2.CredentialsRequest{Host: "base.example.com"})
+	require.NoError(t, err)
+	require.Equal(t, "base", creds.Username)
+	require.Equal(t, "secret", creds.Secret)
+
+	creds, err = auth.Credentials(context.TODO(), &auth2.CredentialsRequest{Host: "use-auth.com"})
+	require.NoError(t, err)
+	require.Equal(t, "user", creds.Username)
+}
 
+func Test_Credentials_MultipleRegistries(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	auth := NewAuthenticator(map[string]registry.AuthConfig{
+		"push.example.com": {Username: "push", Password: "push-secret"},
+		"base.example.com": {Username: "base", Password: "base-secret"},
+		"docker.io":        {Username: "hub", Password: "hub-secret"},
+	})
+
+	creds, err := auth.Credentials(context.TODO(), &auth2.CredentialsRequest{Host: "base.example.com"})
+	require.NoError(t, err)
+	require.Equal(t, "base", creds.Username)
+	require.Equal(t, "base-secret", creds.Secret)
+
+	creds, err = auth.Credentials(context.TODO(), &auth2.CredentialsRequest{Host: "push.example.com"})
+	require.NoError(t, err)
+	require.Equal(t, "push", creds.Username)
+
+	creds, err = auth.Credentials(context.TODO(), &auth2.CredentialsRequest{Host: "registry-1.docker.io"})
+	require.NoError(t, err)
+	require.Equal(t, "hub", creds.Username)
+	require.Equal(t, "hub-secret", creds.Secret)
+
+	creds, err = auth.Credentials(context.TODO(), &auth2.CredentialsRequest{Host: "other.example.com"})
+	require.NoError(t, err)
+	require.Equal(t, "", creds.Username)
+}
+
+func Test_Credentials_IdentityToken(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	auth := NewAuthenticator(map[string]registry.AuthConfig{
+		"push.example.com":      {Username: "push", Password: "push-secret"},
+		"myregistry.azurecr.io": {Username: "00000000-0000-0000-0000-000000000000", IdentityToken: "refresh-token"},
+	})
+
+	creds, err := auth.Credentials(context.TODO(), &auth2.CredentialsRequest{Host: "myregistry.azurecr.io"})
+	require.NoError(t, err)
+	require.Equal(t, "", creds.Username)
+	require.Equal(t, "refresh-token", creds.Secret)
+
+	creds, err = auth.Credentials(context.TODO(), &auth2.CredentialsRequest{Host: "push.example.com"})
+	require.NoError(t, err)
+	require.Equal(t, "push", creds.Username)
+	require.Equal(t, "push-secret", creds.Secret)
+}
+
+// tokenServer is a token service requiring a refresh token or basic auth, returning the grant used as token
+func tokenServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			require.NoError(t, r.ParseForm())
+			if r.Form.Get("grant_type") != "refresh_token" || r.Form.Get("refresh_token") != "refresh-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = fmt.Fprint(w, `{"access_token":"oauth-token","expires_in":300}`)
+			return
+		}
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "password" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"token":"basic-token","expires_in":300}`)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func Test_FetchToken(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	server := tokenServer(t)
+	auth := NewAuthenticator(map[string]registry.AuthConfig{
+		"basic.example.com":     {Username: "user", Password: "password"},
+		"myregistry.azurecr.io": {IdentityToken: "refresh-token"},
+		"token.example.com":     {RegistryToken: "registry-token"},
+	})
+
+	tests := []struct {
+		host  string
+		token string
+	}{
+		{host: "basic.example.com", token: "basic-token"},
+		{host: "myregistry.azurecr.io", token: "oauth-token"},
+		{host: "token.example.com", token: "registry-token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			resp, err := auth.FetchToken(context.TODO(), &auth2.FetchTokenRequest{Host: tt.host, Realm: server.URL, Service: "registry", Scopes: []string{"repository:image:pull"}})
+			require.NoError(t, err)
+			require.Equal(t, tt.token, resp.Token)
+		})
+	}
+
+	_, err := auth.FetchToken(context.TODO(), &auth2.FetchTokenRequest{Host: "other.example.com", Realm: server.URL, Service: "registry"})
+	require.Error(t, err)
+}
+
+func Test_Credentials_DockerAuthConfig(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	t.Setenv("DOCKER_AUTH_CONFIG", `{"auths":{"https://base.example.com":{"auth":"YmFzZTpzZWNyZXQ="}}}`)
+	auth := NewAuthenticator(map[string]registry.AuthConfig{"use-auth.com": {Username: "user", Password: "password"}})
+
+	creds, err := auth.Credentials(context.TODO(), &auth2.CredentialsRequest{Host: "base.example.com"})
+	require.NoError(t, err)
+	require.Equal(t, "base", creds.Username)
+	require.Equal(t, "secret", creds.Secret)
+}
+
+func Test_Keychain(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	keychain := NewKeychain(map[string]registry.AuthConfig{"docker.io": {Username: "hub", Password: "hub-secret"}})
+
+	repo, err := name.NewRepository("alpine")
+	require.NoError(t, err)
+	authenticator, err := keychain.Resolve(repo)
+	require.NoError(t, err)
+	config, err := authenticator.Authorization()
+	require.NoError(t, err)
+	require.Equal(t, "hub", config.Username)
+	require.Equal(t, "hub-secret", config.Password)
+
+	repo, err = name.NewRepository("quay.io/org/image")
+	require.NoError(t, err)
+	authenticator, err = keychain.Resolve(repo)
+	require.NoError(t, err)
+	require.Equal(t, authn.Anonymous, authenticator)
 }
@@ -0,0 +1,90 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package docker
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/credentials"
+	"github.com/docker/cli/cli/config/types"
+	"github.com/docker/docker/api/types/registry"
+)
+
+// dockerHubConfigKey is the key docker login stores Docker Hub credentials under
+const dockerHubConfigKey = "https://index.docker.io/v1/"
+
+// authConfigEnv is the environment variable with the content of a Docker config file,
+// as used by GitLab CI for pulling private images
+const authConfigEnv = "DOCKER_AUTH_CONFIG"
+
+// ConfigCredentials returns the credentials for the registry host in DOCKER_AUTH_CONFIG, or stored by docker login
+// in the Docker config file, or by the credential helper configured for the host.
+// Empty credentials are returned if there are none.
+func ConfigCredentials(host string) (registry.AuthConfig, error) {
+	if content := os.Getenv(authConfigEnv); content != "" {
+		cf, err := config.LoadFromReader(strings.NewReader(content))
+		if err != nil {
+			return registry.AuthConfig{}, fmt.Errorf("invalid %s: %w", authConfigEnv, err)
+		}
+		if auth, err := credentials.NewFileStore(cf).Get(configKey(host)); err == nil && HasCredentials(toAuthConfig(host, auth)) {
+			return toAuthConfig(host, auth), nil
+		}
+	}
+	cf, err := config.Load(configDir())
+	if err != nil {
+		return registry.AuthConfig{}, err
+	}
+	auth, err := cf.GetAuthConfig(configKey(host))
+	if err != nil {
+		return registry.AuthConfig{}, err
+	}
+	return toAuthConfig(host, auth), nil
+}
+
+func toAuthConfig(host string, auth types.AuthConfig) registry.AuthConfig {
+	return registry.AuthConfig{
+		Username:      auth.Username,
+		Password:      auth.Password,
+		Auth:          auth.Auth,
+		IdentityToken: auth.IdentityToken,
+		RegistryToken: auth.RegistryToken,
+		ServerAddress: host,
+	}
+}
+
+// HasCredentials returns true if auth contains any credentials
+func HasCredentials(auth registry.AuthConfig) bool {
+	return auth.Username != "" || auth.Password != "" || auth.Auth != "" || auth.IdentityToken != "" || auth.RegistryToken != ""
+}
+
+// configKey returns the key the credentials of host are stored under
+func configKey(host string) string {
+	switch host {
+	case "", "docker.io", "index.docker.io", "registry-1.docker.io":
+		return dockerHubConfigKey
+	}
+	return host
+}
@@ -0,0 +1,157 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package docker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeDockerConfig(t *testing.T, content string) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(content), 0600))
+}
+
+// writeCredentialHelper adds a docker-credential-<name> helper to PATH, returning credentials for registry.example.com
+func writeCredentialHelper(t *testing.T, name string) {
+	dir := t.TempDir()
+	script := `#!/bin/sh
+read host
+if [ "$1" = "get" ] && [ "$host" = "registry.example.com" ]; then
+  echo '{"ServerURL":"registry.example.com","Username":"helper","Secret":"helper-secret"}'
+else
+  echo "credentials not found in native keychain"
+  exit 1
+fi
+`
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, fmt.Sprintf("docker-credential-%s", name)), []byte(script), 0700))
+	t.Setenv("PATH", fmt.Sprintf("%s%c%s", dir, os.PathListSeparator, os.Getenv("PATH")))
+}
+
+func TestConfigCredentials_NoConfig(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	auth, err := ConfigCredentials("registry.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, registry.AuthConfig{ServerAddress: "registry.example.com"}, auth)
+	assert.False(t, HasCredentials(auth))
+}
+
+func TestConfigCredentials_Auths(t *testing.T) {
+	writeDockerConfig(t, `{"auths":{"https://registry.example.com":{"auth":"dXNlcjpwYXNz"},"https://index.docker.io/v1/":{"auth":"aHViOmh1Yi1wYXNz"}}}`)
+
+	auth, err := ConfigCredentials("registry.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "user", auth.Username)
+	assert.Equal(t, "pass", auth.Password)
+	assert.Equal(t, "registry.example.com", auth.ServerAddress)
+	assert.True(t, HasCredentials(auth))
+
+	auth, err = ConfigCredentials("docker.io")
+	assert.NoError(t, err)
+	assert.Equal(t, "hub", auth.Username)
+	assert.Equal(t, "hub-pass", auth.Password)
+
+	auth, err = ConfigCredentials("other.example.com")
+	assert.NoError(t, err)
+	assert.False(t, HasCredentials(auth))
+}
+
+func TestConfigCredentials_IdentityToken(t *testing.T) {
+	writeDockerConfig(t, `{"auths":{"registry.example.com":{"identitytoken":"token"}}}`)
+
+	auth, err := ConfigCredentials("registry.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "token", auth.IdentityToken)
+	assert.True(t, HasCredentials(auth))
+}
+
+func TestConfigCredentials_CredentialHelper(t *testing.T) {
+	writeCredentialHelper(t, "buildtools-test")
+	writeDockerConfig(t, `{"credHelpers":{"registry.example.com":"buildtools-test"}}`)
+
+	auth, err := ConfigCredentials("registry.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "helper", auth.Username)
+	assert.Equal(t, "helper-secret", auth.Password)
+}
+
+func TestConfigCredentials_CredentialsStore(t *testing.T) {
+	writeCredentialHelper(t, "buildtools-test")
+	writeDockerConfig(t, `{"credsStore":"buildtools-test"}`)
+
+	auth, err := ConfigCredentials("registry.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "helper", auth.Username)
+
+	auth, err = ConfigCredentials("other.example.com")
+	assert.NoError(t, err)
+	assert.False(t, HasCredentials(auth))
+}
+
+func TestConfigCredentials_MissingHelper(t *testing.T) {
+	writeDockerConfig(t, `{"credHelpers":{"registry.example.com":"buildtools-missing"}}`)
+
+	_, err := ConfigCredentials("registry.example.com")
+	assert.Error(t, err)
+}
+
+func TestConfigCredentials_InvalidConfig(t *testing.T) {
+	writeDockerConfig(t, `{"auths":`)
+
+	_, err := ConfigCredentials("registry.example.com")
+	assert.Error(t, err)
+}
+
+func TestConfigCredentials_DockerAuthConfig(t *testing.T) {
+	writeDockerConfig(t, `{"auths":{"registry.example.com":{"auth":"ZmlsZTpmaWxlLXBhc3M="}}}`)
+	t.Setenv("DOCKER_AUTH_CONFIG", `{"auths":{"registry.example.com":{"auth":"ZW52OmVudi1wYXNz"}}}`)
+
+	auth, err := ConfigCredentials("registry.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "env", auth.Username)
+	assert.Equal(t, "env-pass", auth.Password)
+}
+
+func TestConfigCredentials_DockerAuthConfigOtherHost(t *testing.T) {
+	writeDockerConfig(t, `{"auths":{"registry.example.com":{"auth":"ZmlsZTpmaWxlLXBhc3M="}}}`)
+	t.Setenv("DOCKER_AUTH_CONFIG", `{"auths":{"other.example.com":{"auth":"ZW52OmVudi1wYXNz"}}}`)
+
+	auth, err := ConfigCredentials("registry.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "file", auth.Username)
+	assert.Equal(t, "file-pass", auth.Password)
+}
+
+func TestConfigCredentials_InvalidDockerAuthConfig(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	t.Setenv("DOCKER_AUTH_CONFIG", `{"auths":`)
+
+	_, err := ConfigCredentials("registry.example.com")
+	assert.ErrorContains(t, err, "invalid DOCKER_AUTH_CONFIG: ")
+}
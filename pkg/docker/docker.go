@@ -45,8 +45,13 @@ type Client interface {
 	RegistryLogin(ctx context.Context, auth registry.AuthConfig) (registry.AuthenticateOKBody, error)
 	ImageBuild(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error)
 	ImagePush(ctx context.Context, image string, options image.PushOptions) (io.ReadCloser, error)
+	ImageTag(ctx context.Context, source, target string) error
+	ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error)
 	DialHijack(ctx context.Context, url, proto string, meta map[string][]string) (net.Conn, error)
 	BuildCancel(ctx context.Context, id string) error
+	Ping(ctx context.Context) (types.Ping, error)
+	ServerVersion(ctx context.Context) (types.Version, error)
+	DaemonHost() string
 }
 
 var _ Client = &client.Client{}
@@ -108,9 +113,9 @@ func FindStages(content string) []string {
 }
 
 func DefaultClient() (Client, error) {
-	return client.NewClientWithOpts(
-		client.WithTLSClientConfigFromEnv(),
-		client.WithHostFromEnv(),
-		client.WithAPIVersionNegotiation(),
-		client.WithVersionFromEnv())
+	opts, err := clientOpts()
+	if err != nil {
+		return nil, err
+	}
+	return client.NewClientWithOpts(opts...)
 }
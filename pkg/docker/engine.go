@@ -0,0 +1,74 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package docker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// Engine describes the container engine behind a Client
+type Engine struct {
+	Name    string
+	Version string
+	Host    string
+	// BuildKit is true if the engine supports BuildKit sessions
+	BuildKit bool
+}
+
+func (e Engine) String() string {
+	if e.Version == "" {
+		return e.Name
+	}
+	return fmt.Sprintf("%s %s", e.Name, e.Version)
+}
+
+// DetectEngine checks that the engine is reachable and determines which engine it is
+func DetectEngine(ctx context.Context, client Client) (Engine, error) {
+	engine := Engine{Name: "Docker", Host: client.DaemonHost()}
+	ping, err := client.Ping(ctx)
+	if err != nil {
+		return engine, fmt.Errorf("no container engine reachable at %s, start Docker, Podman, Colima or Rancher Desktop or set DOCKER_HOST: %w", engine.Host, err)
+	}
+	version, err := client.ServerVersion(ctx)
+	if err != nil {
+		return engine, err
+	}
+	engine.Version = version.Version
+	podman := false
+	for _, component := range version.Components {
+		if strings.Contains(component.Name, "Podman") {
+			podman = true
+			engine.Name = "Podman"
+			engine.Version = component.Version
+		}
+	}
+	if !podman && version.Platform.Name != "" {
+		engine.Name = version.Platform.Name
+	}
+	engine.BuildKit = !podman || ping.BuilderVersion == types.BuilderBuildKit
+	return engine, nil
+}
@@ -0,0 +1,63 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectEngine_Docker(t *testing.T) {
+	engine, err := DetectEngine(context.Background(), &MockDocker{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, Engine{Name: "Docker", Version: "27.5.0", Host: "unix:///var/run/docker.sock", BuildKit: true}, engine)
+	assert.Equal(t, "Docker 27.5.0", engine.String())
+}
+
+func TestDetectEngine_Podman(t *testing.T) {
+	engine, err := DetectEngine(context.Background(), &MockDocker{
+		NoBuildKit: true,
+		Components: []types.ComponentVersion{{Name: "Podman Engine", Version: "5.2.0"}},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, Engine{Name: "Podman", Version: "5.2.0", Host: "unix:///var/run/docker.sock", BuildKit: false}, engine)
+}
+
+func TestDetectEngine_DockerWithoutDefaultBuildKit(t *testing.T) {
+	engine, err := DetectEngine(context.Background(), &MockDocker{NoBuildKit: true})
+
+	assert.NoError(t, err)
+	assert.True(t, engine.BuildKit)
+}
+
+func TestDetectEngine_Unreachable(t *testing.T) {
+	_, err := DetectEngine(context.Background(), &MockDocker{PingError: errors.New("connection refused")})
+
+	assert.EqualError(t, err, "no container engine reachable at unix:///var/run/docker.sock, start Docker, Podman, Colima or Rancher Desktop or set DOCKER_HOST: connection refused")
+}
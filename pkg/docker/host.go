@@ -0,0 +1,209 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package docker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/connhelper"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/tlsconfig"
+)
+
+const defaultContext = "default"
+
+// defaultSocket is the socket used by Docker unless configured otherwise
+var defaultSocket = "/var/run/docker.sock"
+
+// endpoint is the docker endpoint of a Docker context
+type endpoint struct {
+	Host          string
+	SkipTLSVerify bool
+	tlsDir        string
+}
+
+type contextMeta struct {
+	Name      string
+	Endpoints map[string]endpoint
+}
+
+// clientOpts returns the options for connecting to the daemon given by DOCKER_HOST or the current Docker context,
+// with ssh:// hosts connected through the ssh connection helper
+func clientOpts() ([]client.Opt, error) {
+	opts := []client.Opt{
+		client.WithTLSClientConfigFromEnv(),
+		client.WithHostFromEnv(),
+		client.WithAPIVersionNegotiation(),
+		client.WithVersionFromEnv(),
+	}
+	host := os.Getenv(client.EnvOverrideHost)
+	if host == "" {
+		ep, err := contextEndpoint()
+		if err != nil {
+			return nil, err
+		}
+		if ep == nil {
+			if socket := detectSocket(); socket != "" {
+				host = socket
+				opts = append(opts, client.WithHost(socket))
+			}
+		} else {
+			host = ep.Host
+			httpClient, err := ep.httpClient()
+			if err != nil {
+				return nil, err
+			}
+			if httpClient != nil {
+				opts = append(opts, client.WithHTTPClient(httpClient))
+			}
+			opts = append(opts, client.WithHost(ep.Host))
+		}
+	}
+	if strings.HasPrefix(host, "ssh://") {
+		helper, err := connhelper.GetConnectionHelper(host)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts,
+			client.WithHTTPClient(&http.Client{Transport: &http.Transport{DialContext: helper.Dialer}}),
+			client.WithHost(helper.Host),
+			client.WithDialContext(helper.Dialer),
+		)
+	}
+	return opts, nil
+}
+
+// contextEndpoint returns the docker endpoint of the context given by DOCKER_CONTEXT or the current context
+// in the Docker config, or nil if the default context is used
+func contextEndpoint() (*endpoint, error) {
+	name := os.Getenv("DOCKER_CONTEXT")
+	if name == "" {
+		cfg, err := config.Load(configDir())
+		if err != nil {
+			return nil, err
+		}
+		name = cfg.CurrentContext
+	}
+	if name == "" || name == defaultContext {
+		return nil, nil
+	}
+	sum := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(sum[:])
+	content, err := os.ReadFile(filepath.Join(filepath.Join(configDir(), "contexts"), "meta", id, "meta.json"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read docker context '%s': %w", name, err)
+	}
+	meta := &contextMeta{}
+	if err := json.Unmarshal(content, meta); err != nil {
+		return nil, fmt.Errorf("unable to parse docker context '%s': %w", name, err)
+	}
+	ep, exists := meta.Endpoints["docker"]
+	if !exists || ep.Host == "" {
+		return nil, fmt.Errorf("docker context '%s' has no docker endpoint", name)
+	}
+	ep.tlsDir = filepath.Join(filepath.Join(configDir(), "contexts"), "tls", id, "docker")
+	return &ep, nil
+}
+
+// httpClient returns a client using the TLS settings stored with the context, or nil if there are none
+func (e endpoint) httpClient() (*http.Client, error) {
+	ca := filepath.Join(e.tlsDir, "ca.pem")
+	cert := filepath.Join(e.tlsDir, "cert.pem")
+	key := filepath.Join(e.tlsDir, "key.pem")
+	opts := tlsconfig.Options{InsecureSkipVerify: e.SkipTLSVerify, ExclusiveRootPools: true}
+	if exists(ca) {
+		opts.CAFile = ca
+	}
+	if exists(cert) {
+		opts.CertFile = cert
+		opts.KeyFile = key
+	}
+	if opts.CAFile == "" && opts.CertFile == "" && !e.SkipTLSVerify {
+		return nil, nil
+	}
+	tlsConfig, err := tlsconfig.Client(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Transport:     &http.Transport{TLSClientConfig: tlsConfig},
+		CheckRedirect: client.CheckRedirect,
+	}, nil
+}
+
+// detectSocket returns the host of the first existing socket of the known engines, starting with the default
+// Docker socket, or an empty string if none exists
+func detectSocket() string {
+	if runtime.GOOS == "windows" {
+		return ""
+	}
+	for _, socket := range knownSockets() {
+		if exists(socket) {
+			return "unix://" + socket
+		}
+	}
+	return ""
+}
+
+// knownSockets returns the socket locations of Docker, rootless Docker, Podman, Colima and Rancher Desktop
+func knownSockets() []string {
+	sockets := []string{defaultSocket}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		sockets = append(sockets,
+			filepath.Join(runtimeDir, "docker.sock"),
+			filepath.Join(runtimeDir, "podman", "podman.sock"),
+		)
+	}
+	sockets = append(sockets, "/run/podman/podman.sock")
+	if home, err := os.UserHomeDir(); err == nil {
+		sockets = append(sockets,
+			filepath.Join(home, ".colima", "default", "docker.sock"),
+			filepath.Join(home, ".colima", "docker.sock"),
+			filepath.Join(home, ".rd", "docker.sock"),
+		)
+	}
+	return sockets
+}
+
+// configDir returns the Docker config directory, DOCKER_CONFIG is checked on each call
+// since config.Dir only reads it once
+func configDir() string {
+	if dir := os.Getenv(config.EnvOverrideConfigDir); dir != "" {
+		return dir
+	}
+	return config.Dir()
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
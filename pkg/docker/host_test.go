@@ -0,0 +1,212 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package docker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeContext(t *testing.T, dir, name, meta string) string {
+	sum := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(sum[:])
+	metaDir := filepath.Join(dir, "contexts", "meta", id)
+	assert.NoError(t, os.MkdirAll(metaDir, 0777))
+	assert.NoError(t, os.WriteFile(filepath.Join(metaDir, "meta.json"), []byte(meta), 0666))
+	return filepath.Join(dir, "contexts", "tls", id, "docker")
+}
+
+func TestDefaultClient_DockerHost(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	t.Setenv("DOCKER_HOST", "tcp://127.0.0.1:2375")
+
+	c, err := DefaultClient()
+	assert.NoError(t, err)
+	assert.Equal(t, "tcp://127.0.0.1:2375", c.(*client.Client).DaemonHost())
+}
+
+func TestDefaultClient_SSHHost(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	t.Setenv("DOCKER_HOST", "ssh://user@builder")
+
+	c, err := DefaultClient()
+	assert.NoError(t, err)
+	assert.Equal(t, "http://docker.example.com", c.(*client.Client).DaemonHost())
+}
+
+func TestDefaultClient_InvalidSSHHost(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	t.Setenv("DOCKER_HOST", "ssh://user@builder:abc")
+
+	_, err := DefaultClient()
+	assert.Error(t, err)
+}
+
+func TestDefaultClient_CurrentContext(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	t.Setenv("DOCKER_HOST", "")
+	t.Setenv("DOCKER_CONTEXT", "")
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"currentContext":"remote"}`), 0666))
+	writeContext(t, dir, "remote", `{"Name":"remote","Endpoints":{"docker":{"Host":"ssh://user@builder"}}}`)
+
+	c, err := DefaultClient()
+	assert.NoError(t, err)
+	assert.Equal(t, "http://docker.example.com", c.(*client.Client).DaemonHost())
+}
+
+func TestDefaultClient_ContextFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	t.Setenv("DOCKER_HOST", "")
+	t.Setenv("DOCKER_CONTEXT", "other")
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"currentContext":"remote"}`), 0666))
+	writeContext(t, dir, "other", `{"Name":"other","Endpoints":{"docker":{"Host":"tcp://10.0.0.1:2376","SkipTLSVerify":true}}}`)
+
+	c, err := DefaultClient()
+	assert.NoError(t, err)
+	assert.Equal(t, "tcp://10.0.0.1:2376", c.(*client.Client).DaemonHost())
+}
+
+func withoutSockets(t *testing.T) string {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	t.Setenv("DOCKER_HOST", "")
+	t.Setenv("DOCKER_CONTEXT", "")
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_RUNTIME_DIR", filepath.Join(dir, "run"))
+	socket := defaultSocket
+	defaultSocket = filepath.Join(dir, "docker.sock")
+	t.Cleanup(func() { defaultSocket = socket })
+	return dir
+}
+
+func writeSocket(t *testing.T, path string) {
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0777))
+	assert.NoError(t, os.WriteFile(path, []byte{}, 0666))
+}
+
+func TestDefaultClient_NoSocketFound(t *testing.T) {
+	withoutSockets(t)
+
+	c, err := DefaultClient()
+	assert.NoError(t, err)
+	assert.Equal(t, client.DefaultDockerHost, c.(*client.Client).DaemonHost())
+}
+
+func TestDefaultClient_DefaultSocketPreferred(t *testing.T) {
+	dir := withoutSockets(t)
+	writeSocket(t, filepath.Join(dir, "docker.sock"))
+	writeSocket(t, filepath.Join(dir, "run", "podman", "podman.sock"))
+
+	c, err := DefaultClient()
+	assert.NoError(t, err)
+	assert.Equal(t, "unix://"+filepath.Join(dir, "docker.sock"), c.(*client.Client).DaemonHost())
+}
+
+func TestDefaultClient_PodmanSocket(t *testing.T) {
+	dir := withoutSockets(t)
+	writeSocket(t, filepath.Join(dir, "run", "podman", "podman.sock"))
+
+	c, err := DefaultClient()
+	assert.NoError(t, err)
+	assert.Equal(t, "unix://"+filepath.Join(dir, "run", "podman", "podman.sock"), c.(*client.Client).DaemonHost())
+}
+
+func TestDefaultClient_ColimaSocket(t *testing.T) {
+	dir := withoutSockets(t)
+	writeSocket(t, filepath.Join(dir, ".colima", "default", "docker.sock"))
+
+	c, err := DefaultClient()
+	assert.NoError(t, err)
+	assert.Equal(t, "unix://"+filepath.Join(dir, ".colima", "default", "docker.sock"), c.(*client.Client).DaemonHost())
+}
+
+func TestDefaultClient_RancherDesktopSocket(t *testing.T) {
+	dir := withoutSockets(t)
+	writeSocket(t, filepath.Join(dir, ".rd", "docker.sock"))
+
+	c, err := DefaultClient()
+	assert.NoError(t, err)
+	assert.Equal(t, "unix://"+filepath.Join(dir, ".rd", "docker.sock"), c.(*client.Client).DaemonHost())
+}
+
+func TestDefaultClient_DefaultContext(t *testing.T) {
+	withoutSockets(t)
+	t.Setenv("DOCKER_CONTEXT", "default")
+
+	c, err := DefaultClient()
+	assert.NoError(t, err)
+	assert.Equal(t, client.DefaultDockerHost, c.(*client.Client).DaemonHost())
+}
+
+func TestDefaultClient_MissingContext(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	t.Setenv("DOCKER_HOST", "")
+	t.Setenv("DOCKER_CONTEXT", "missing")
+
+	_, err := DefaultClient()
+	assert.ErrorContains(t, err, "unable to read docker context 'missing'")
+}
+
+func TestDefaultClient_ContextWithoutDockerEndpoint(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	t.Setenv("DOCKER_HOST", "")
+	t.Setenv("DOCKER_CONTEXT", "k8s")
+	writeContext(t, dir, "k8s", `{"Name":"k8s","Endpoints":{}}`)
+
+	_, err := DefaultClient()
+	assert.EqualError(t, err, "docker context 'k8s' has no docker endpoint")
+}
+
+func TestDefaultClient_InvalidContext(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	t.Setenv("DOCKER_HOST", "")
+	t.Setenv("DOCKER_CONTEXT", "broken")
+	writeContext(t, dir, "broken", `{`)
+
+	_, err := DefaultClient()
+	assert.ErrorContains(t, err, "unable to parse docker context 'broken'")
+}
+
+func TestDefaultClient_ContextTLS(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	t.Setenv("DOCKER_HOST", "")
+	t.Setenv("DOCKER_CONTEXT", "tls")
+	tlsDir := writeContext(t, dir, "tls", `{"Name":"tls","Endpoints":{"docker":{"Host":"tcp://10.0.0.1:2376"}}}`)
+	assert.NoError(t, os.MkdirAll(tlsDir, 0777))
+	assert.NoError(t, os.WriteFile(filepath.Join(tlsDir, "ca.pem"), []byte("not a cert"), 0666))
+
+	_, err := DefaultClient()
+	assert.Error(t, err)
+}
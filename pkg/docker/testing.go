@@ -31,6 +31,7 @@ import (
 	"io"
 	"net"
 	"strings"
+	"sync"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/image"
@@ -52,9 +53,19 @@ type MockDocker struct {
 	BrokenOutput  bool
 	ResponseError error
 	ResponseBody  io.Reader
+	PingError     error
+	Tagged        []string
+	TagError      error
+	ImageID       string
+	RepoDigests   []string
+	Components    []types.ComponentVersion
+	NoBuildKit    bool
+	mu            sync.Mutex
 }
 
 func (m *MockDocker) ImageBuild(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	defer func() { m.BuildCount = m.BuildCount + 1 }()
 	m.BuildContext = append(m.BuildContext, buildContext)
 	m.BuildOptions = append(m.BuildOptions, options)
@@ -76,6 +87,8 @@ func (m *MockDocker) ImageBuild(ctx context.Context, buildContext io.Reader, opt
 }
 
 func (m *MockDocker) ImagePush(ctx context.Context, image string, options image.PushOptions) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.Images = append(m.Images, image)
 
 	if m.PushError != nil {
@@ -85,6 +98,20 @@ func (m *MockDocker) ImagePush(ctx context.Context, image string, options image.
 	return io.NopCloser(strings.NewReader(*m.PushOutput)), nil
 }
 
+func (m *MockDocker) ImageTag(ctx context.Context, source, target string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Tagged = append(m.Tagged, fmt.Sprintf("%s -> %s", source, target))
+	return m.TagError
+}
+
+func (m *MockDocker) ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+	if m.ImageID == "" {
+		return types.ImageInspect{}, nil, fmt.Errorf("No such image: %s", image)
+	}
+	return types.ImageInspect{ID: m.ImageID, RepoDigests: m.RepoDigests}, nil, nil
+}
+
 func (m *MockDocker) RegistryLogin(ctx context.Context, auth registry.AuthConfig) (registry.AuthenticateOKBody, error) {
 	m.Username = auth.Username
 	m.Password = auth.Password
@@ -103,4 +130,22 @@ func (m *MockDocker) BuildCancel(ctx context.Context, id string) error {
 	return nil
 }
 
+func (m *MockDocker) Ping(ctx context.Context) (types.Ping, error) {
+	if m.PingError != nil {
+		return types.Ping{}, m.PingError
+	}
+	if m.NoBuildKit {
+		return types.Ping{APIVersion: "1.41"}, nil
+	}
+	return types.Ping{APIVersion: "1.47", BuilderVersion: types.BuilderBuildKit}, nil
+}
+
+func (m *MockDocker) ServerVersion(ctx context.Context) (types.Version, error) {
+	return types.Version{Version: "27.5.0", Components: m.Components}, nil
+}
+
+func (m *MockDocker) DaemonHost() string {
+	return "unix:///var/run/docker.sock"
+}
+
 var _ Client = &MockDocker{}
@@ -0,0 +1,210 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package imagecopy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/apex/log"
+	refname "github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+
+	"github.com/buildtool/build-tools/pkg/args"
+	"github.com/buildtool/build-tools/pkg/ci"
+	"github.com/buildtool/build-tools/pkg/config"
+	"github.com/buildtool/build-tools/pkg/docker"
+	"github.com/buildtool/build-tools/pkg/registry"
+	"github.com/buildtool/build-tools/pkg/version"
+)
+
+type Args struct {
+	args.Globals
+	Tags []string `arg:"" optional:"" name:"tags" help:"the tags to copy, defaults to the commit evaluated from CI or VCS"`
+	From string   `name:"from" help:"name of the registry in registries to copy from, defaults to the current registry" default:""`
+	To   string   `name:"to" help:"name of the registry in registries to copy to" required:""`
+}
+
+// signatureSuffixes are the tag suffixes used by cosign for signatures, attestations and SBOMs
+var signatureSuffixes = []string{".sig", ".att", ".sbom"}
+
+func DoCopy(dir string, info version.Info, osArgs ...string) int {
+	var copyArgs Args
+	err := args.ParseArgs(dir, osArgs, info, &copyArgs)
+	if err != nil {
+		if err != args.Done {
+			return -1
+		} else {
+			return 0
+		}
+	}
+
+	cfg, err := config.Load(dir)
+	if err != nil {
+		log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+		return -2
+	}
+	return doCopy(cfg, copyArgs)
+}
+
+func doCopy(cfg *config.Config, copyArgs Args) int {
+	currentCI := cfg.CurrentCI()
+	tags := copyArgs.Tags
+	if len(tags) == 0 {
+		if !ci.IsValid(currentCI) || currentCI.Commit() == "" {
+			log.Error("Commit and/or branch information is <red>missing</red>. Perhaps your not in a Git repository or forgot to set environment variables?")
+			return -3
+		}
+		tags = []string{currentCI.Commit()}
+	}
+
+	from, err := cfg.NamedRegistry(copyArgs.From)
+	if err != nil {
+		log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+		return -3
+	}
+	to, err := cfg.NamedRegistry(copyArgs.To)
+	if err != nil {
+		log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+		return -3
+	}
+	for _, r := range []registry.Registry{from, to} {
+		if err := registry.Authenticate(r); err != nil {
+			log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+			return -4
+		}
+	}
+	if err := to.Create(currentCI.BuildName()); err != nil {
+		log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+		return -5
+	}
+
+	ctx := context.Background()
+	c := &copier{
+		fromOptions: registry.RemoteOptions(ctx, from),
+		toOptions:   registry.RemoteOptions(ctx, to),
+		fromNames:   registry.NameOptions(from),
+		toNames:     registry.NameOptions(to),
+	}
+	for _, tag := range tags {
+		source := docker.Tag(from.RegistryUrl(), currentCI.BuildName(), tag)
+		target := docker.Tag(to.RegistryUrl(), currentCI.BuildName(), tag)
+		log.Infof("Copying '<green>%s</green>' to '<green>%s</green>'\n", source, target)
+		if err := c.copyTag(source, target); err != nil {
+			log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+			return -6
+		}
+	}
+	return 0
+}
+
+type copier struct {
+	fromOptions []remote.Option
+	toOptions   []remote.Option
+	fromNames   []refname.Option
+	toNames     []refname.Option
+}
+
+// copyTag copies the manifest (or index) of the source tag, followed by its signatures, attestations
+// and other referrers
+func (c *copier) copyTag(source, target string) error {
+	sourceRef, err := refname.NewTag(source, c.fromNames...)
+	if err != nil {
+		return err
+	}
+	targetRef, err := refname.NewTag(target, c.toNames...)
+	if err != nil {
+		return err
+	}
+	desc, err := c.copy(sourceRef, targetRef)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Copied digest <green>%s</green>\n", desc.Digest)
+	return c.copyReferrers(sourceRef.Context(), targetRef.Context(), desc.Digest)
+}
+
+// copy copies a manifest with all the blobs it references. Blobs are mounted by the target registry
+// if it's the same as the source registry, otherwise they're streamed through.
+func (c *copier) copy(source, target refname.Reference) (*remote.Descriptor, error) {
+	desc, err := remote.Get(source, c.fromOptions...)
+	if err != nil {
+		return nil, err
+	}
+	if desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return nil, err
+		}
+		return desc, remote.WriteIndex(target, index, c.toOptions...)
+	}
+	img, err := desc.Image()
+	if err != nil {
+		return nil, err
+	}
+	return desc, remote.Write(target, img, c.toOptions...)
+}
+
+// copyReferrers copies the cosign signatures, attestations and SBOMs of the digest, and the referrers
+// found using the OCI referrers API
+func (c *copier) copyReferrers(source, target refname.Repository, digest v1.Hash) error {
+	for _, suffix := range signatureSuffixes {
+		tag := fmt.Sprintf("%s-%s%s", digest.Algorithm, digest.Hex, suffix)
+		_, err := c.copy(source.Tag(tag), target.Tag(tag))
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		log.Debugf("Copied <green>%s</green>\n", tag)
+	}
+
+	referrers, err := remote.Referrers(source.Digest(digest.String()), c.fromOptions...)
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	manifest, err := referrers.IndexManifest()
+	if err != nil {
+		return err
+	}
+	for _, referrer := range manifest.Manifests {
+		if _, err := c.copy(source.Digest(referrer.Digest.String()), target.Digest(referrer.Digest.String())); err != nil {
+			return err
+		}
+		log.Debugf("Copied referrer <green>%s</green> of type <green>%s</green>\n", referrer.Digest, referrer.ArtifactType)
+	}
+	return nil
+}
+
+func isNotFound(err error) bool {
+	var terr *transport.Error
+	return errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound
+}
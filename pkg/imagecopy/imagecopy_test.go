@@ -0,0 +1,216 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package imagecopy
+
+import (
+	"fmt"
+	"io"
+	stdlog "log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	refname "github.com/google/go-containerregistry/pkg/name"
+	ggcr "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+
+	"github.com/buildtool/build-tools/pkg/config"
+	"github.com/buildtool/build-tools/pkg/registry"
+	"github.com/buildtool/build-tools/pkg/version"
+)
+
+func newRegistry(t *testing.T) string {
+	server := httptest.NewServer(ggcr.New(ggcr.Logger(stdlog.New(io.Discard, "", 0)), ggcr.WithReferrersSupport(true)))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func testConfig(from, to string) *config.Config {
+	cfg := config.InitEmptyConfig()
+	cfg.CI.Gitlab.CIBuildName = "reponame"
+	cfg.CI.Gitlab.CICommit = "abc123"
+	cfg.CI.Gitlab.CIBranchName = "main"
+	cfg.Registry.Dockerhub.Namespace = fmt.Sprintf("%s/repo", from)
+	cfg.Registries = map[string]*config.RegistryConfig{
+		"production": {Dockerhub: &registry.Dockerhub{Namespace: fmt.Sprintf("%s/repo", to)}},
+	}
+	return cfg
+}
+
+func ref(t *testing.T, image string) refname.Reference {
+	r, err := refname.ParseReference(image)
+	assert.NoError(t, err)
+	return r
+}
+
+func TestCopy_IndexWithSignatureAndReferrer(t *testing.T) {
+	from, to := newRegistry(t), newRegistry(t)
+	index, err := random.Index(256, 1, 2)
+	assert.NoError(t, err)
+	assert.NoError(t, remote.WriteIndex(ref(t, fmt.Sprintf("%s/repo/reponame:abc123", from)), index))
+	digest, _ := index.Digest()
+	signature, _ := random.Image(64, 1)
+	assert.NoError(t, remote.Write(ref(t, fmt.Sprintf("%s/repo/reponame:sha256-%s.sig", from, digest.Hex)), signature))
+	attestation, _ := random.Image(64, 1)
+	manifest, _ := index.IndexManifest()
+	attestation = mutate.Subject(mutate.ConfigMediaType(attestation, types.MediaType("application/vnd.example.sbom")), v1.Descriptor{
+		MediaType: manifest.MediaType,
+		Digest:    digest,
+		Size:      mustSize(t, index),
+	}).(v1.Image)
+	attestationDigest, _ := attestation.Digest()
+	assert.NoError(t, remote.Write(ref(t, fmt.Sprintf("%s/repo/reponame@%s", from, attestationDigest)), attestation))
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+
+	exitCode := doCopy(testConfig(from, to), Args{To: "production"})
+
+	assert.Equal(t, 0, exitCode)
+	copied, err := remote.Head(ref(t, fmt.Sprintf("%s/repo/reponame:abc123", to)))
+	if assert.NoError(t, err) {
+		assert.Equal(t, digest, copied.Digest)
+	}
+	copiedIndex, err := remote.Index(ref(t, fmt.Sprintf("%s/repo/reponame:abc123", to)))
+	assert.NoError(t, err)
+	copiedManifest, _ := copiedIndex.IndexManifest()
+	assert.Equal(t, 2, len(copiedManifest.Manifests))
+	signatureDigest, _ := signature.Digest()
+	copiedSignature, err := remote.Head(ref(t, fmt.Sprintf("%s/repo/reponame:sha256-%s.sig", to, digest.Hex)))
+	if assert.NoError(t, err) {
+		assert.Equal(t, signatureDigest, copiedSignature.Digest)
+	}
+	_, err = remote.Head(ref(t, fmt.Sprintf("%s/repo/reponame@%s", to, attestationDigest)))
+	assert.NoError(t, err)
+	logMock.Check(t, []string{
+		fmt.Sprintf("info: Copying '<green>%s/repo/reponame:abc123</green>' to '<green>%s/repo/reponame:abc123</green>'\n", from, to),
+		fmt.Sprintf("debug: Copied digest <green>%s</green>\n", digest),
+		fmt.Sprintf("debug: Copied <green>sha256-%s.sig</green>\n", digest.Hex),
+		fmt.Sprintf("debug: Copied referrer <green>%s</green> of type <green>application/vnd.example.sbom</green>\n", attestationDigest),
+	})
+}
+
+func mustSize(t *testing.T, index v1.ImageIndex) int64 {
+	size, err := index.Size()
+	assert.NoError(t, err)
+	return size
+}
+
+func TestCopy_Tags(t *testing.T) {
+	from, to := newRegistry(t), newRegistry(t)
+	img, _ := random.Image(256, 1)
+	assert.NoError(t, remote.Write(ref(t, fmt.Sprintf("%s/repo/reponame:main", from)), img))
+	assert.NoError(t, remote.Write(ref(t, fmt.Sprintf("%s/repo/reponame:latest", from)), img))
+	cfg := testConfig(from, to)
+	cfg.Registries["staging"] = cfg.Registries["production"]
+	cfg.Registries["production"] = &config.RegistryConfig{Dockerhub: cfg.Registry.Dockerhub}
+	cfg.Registry.Dockerhub = &registry.Dockerhub{}
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+
+	exitCode := doCopy(cfg, Args{Tags: []string{"main", "latest"}, From: "production", To: "staging"})
+
+	assert.Equal(t, 0, exitCode)
+	expected, _ := img.Digest()
+	for _, tag := range []string{"main", "latest"} {
+		copied, err := remote.Head(ref(t, fmt.Sprintf("%s/repo/reponame:%s", to, tag)))
+		if assert.NoError(t, err) {
+			assert.Equal(t, expected, copied.Digest)
+		}
+	}
+	logMock.Check(t, []string{
+		fmt.Sprintf("info: Copying '<green>%s/repo/reponame:main</green>' to '<green>%s/repo/reponame:main</green>'\n", from, to),
+		fmt.Sprintf("info: Copying '<green>%s/repo/reponame:latest</green>' to '<green>%s/repo/reponame:latest</green>'\n", from, to),
+	})
+}
+
+func TestCopy_MissingSource(t *testing.T) {
+	from, to := newRegistry(t), newRegistry(t)
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.ErrorLevel)
+
+	exitCode := doCopy(testConfig(from, to), Args{Tags: []string{"missing"}, To: "production"})
+
+	assert.Equal(t, -6, exitCode)
+	logMock.Check(t, []string{
+		fmt.Sprintf("error: <red>GET http://%s/v2/repo/reponame/manifests/missing: NAME_UNKNOWN: Unknown name</red>", from),
+	})
+}
+
+func TestCopy_UnknownRegistry(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+
+	exitCode := doCopy(testConfig("from", "to"), Args{To: "staging"})
+
+	assert.Equal(t, -3, exitCode)
+	logMock.Check(t, []string{"error: <red>no registry matching staging found</red>"})
+}
+
+func TestDoCopy_BrokenConfig(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.WriteFile(filepath.Join(dir, ".buildtools.yaml"), []byte(`ci: []`), 0666)
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+
+	exitCode := DoCopy(dir, version.Info{}, "--to", "production")
+
+	assert.Equal(t, -2, exitCode)
+	logMock.Check(t, []string{
+		fmt.Sprintf("debug: Parsing config from file: <green>'%s'</green>\n", filepath.Join(dir, ".buildtools.yaml")),
+		"error: <red>yaml: unmarshal errors:\n  line 1: cannot unmarshal !!seq into config.CIConfig</red>",
+	})
+}
+
+func TestDoCopy_MissingTo(t *testing.T) {
+	log.SetHandler(mocks.New())
+
+	exitCode := DoCopy(t.TempDir(), version.Info{})
+
+	assert.Equal(t, -1, exitCode)
+}
+
+func TestIsNotFound(t *testing.T) {
+	notFound := &transport.Error{StatusCode: http.StatusNotFound}
+	assert.True(t, isNotFound(notFound))
+	assert.True(t, isNotFound(fmt.Errorf("fetching referrers: %w", notFound)))
+	assert.False(t, isNotFound(&transport.Error{StatusCode: http.StatusUnauthorized}))
+	assert.False(t, isNotFound(nil))
+}
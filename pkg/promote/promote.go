@@ -34,6 +34,8 @@ import (
 	"github.com/apex/log"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	"golang.org/x/exp/utf8string"
 
@@ -42,6 +44,7 @@ import (
 	"github.com/buildtool/build-tools/pkg/args"
 	"github.com/buildtool/build-tools/pkg/ci"
 	"github.com/buildtool/build-tools/pkg/config"
+	"github.com/buildtool/build-tools/pkg/credentials"
 	"github.com/buildtool/build-tools/pkg/version"
 )
 
@@ -120,16 +123,16 @@ func Promote(dir, name, timestamp string, target *config.Gitops, args Args, cfg
 		return err
 	}
 	if args.Out == "" {
-		keys, err := handleSSHKey(args, cfg.Git)
+		auth, err := gitAuth(args, cfg.Git)
 		if err != nil {
 			return err
 		}
-		err = commitAndPush(target, keys, name, buffer, args, cfg.Git)
+		err = commitAndPush(target, auth, name, buffer, args, cfg.Git)
 		if err != nil {
 			if strings.HasPrefix(err.Error(), "git push error") || strings.Contains(err.Error(), "cannot lock ref") {
 				// Retry one more time
 				log.Infof("error during push, retrying\n")
-				err = commitAndPush(target, keys, name, buffer, args, cfg.Git)
+				err = commitAndPush(target, auth, name, buffer, args, cfg.Git)
 				if err != nil {
 					return err
 				}
@@ -147,7 +150,7 @@ func Promote(dir, name, timestamp string, target *config.Gitops, args Args, cfg
 	return nil
 }
 
-func commitAndPush(target *config.Gitops, keys *ssh.PublicKeys, name string, buffer *bytes.Buffer, args Args, gitConfig config.Git) error {
+func commitAndPush(target *config.Gitops, auth transport.AuthMethod, name string, buffer *bytes.Buffer, args Args, gitConfig config.Git) error {
 	cloneDir, err := os.MkdirTemp(os.TempDir(), "build-tools")
 	if err != nil {
 		return err
@@ -158,7 +161,7 @@ func commitAndPush(target *config.Gitops, keys *ssh.PublicKeys, name string, buf
 	log.Debugf("Cloning into %s\n", cloneDir)
 	repo, err := git.PlainClone(cloneDir, false, &git.CloneOptions{
 		URL:  target.URL,
-		Auth: keys,
+		Auth: auth,
 	})
 	if err != nil {
 		return err
@@ -203,7 +206,7 @@ func commitAndPush(target *config.Gitops, keys *ssh.PublicKeys, name string, buf
 	}
 	log.Infof("pushing commit %s to %s\n", commit.Hash, filepath.Join(target.URL, target.Path, normalized))
 	err = repo.Push(&git.PushOptions{
-		Auth: keys,
+		Auth: auth,
 	})
 	if err != nil {
 		return fmt.Errorf("git push error: %w", err)
@@ -211,6 +214,25 @@ func commitAndPush(target *config.Gitops, keys *ssh.PublicKeys, name string, buf
 	return nil
 }
 
+// gitAuth returns basic auth with the credentials of the configured credential process, for HTTPS URLs,
+// or the SSH key otherwise
+func gitAuth(args Args, gitConfig config.Git) (transport.AuthMethod, error) {
+	if gitConfig.CredentialProcess == "" {
+		keys, err := handleSSHKey(args, gitConfig)
+		if err != nil {
+			return nil, err
+		}
+		return keys, nil
+	}
+	creds, err := credentials.Process(gitConfig.CredentialProcess)
+	if err != nil {
+		return nil, fmt.Errorf("git credentials: %w", err)
+	}
+	username := defaultIfEmpty(creds.Username, args.User)
+	log.Debugf("Will use credentials for %s from credential process\n", username)
+	return &http.BasicAuth{Username: username, Password: creds.Password}, nil
+}
+
 func handleSSHKey(args Args, gitConfig config.Git) (*ssh.PublicKeys, error) {
 	privKey := "~/.ssh/id_rsa"
 	if args.PrivateKey != "" {
@@ -258,6 +258,65 @@ data:
 			},
 			wantCommitMessage: strPointer("ci: promoting dummy to target, commit providedlongtag"),
 		},
+		{
+			name: "credential process from config",
+			config: `
+gitops:
+  target:
+    url: "{{.repo}}"
+git:
+  credentialProcess: echo '{"Version":1,"Username":"promoter","Password":"token"}'
+`,
+			descriptor: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test
+data:
+  BASE_URL: https://example.org
+`,
+			args: []string{"target", "--tag", "providedlongtag", "--key", "/missing/key"},
+			env: map[string]string{
+				"CI_COMMIT_SHA":      "abc123",
+				"CI_PROJECT_NAME":    "dummy",
+				"CI_COMMIT_REF_NAME": "master",
+			},
+			want: 0,
+			wantLogged: []string{
+				"info: Using passed tag <green>providedlongtag</green> to promote\n",
+				"info: generating...",
+				"^info: pushing commit [0-9a-f]+ to .*git-repo.*\\/dummy\n$",
+			},
+			wantCommitMessage: strPointer("ci: promoting dummy to target, commit providedlongtag"),
+		},
+		{
+			name: "failing credential process",
+			config: `
+gitops:
+  target:
+    url: "{{.repo}}"
+`,
+			descriptor: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test
+data:
+  BASE_URL: https://example.org
+`,
+			args: []string{"target"},
+			env: map[string]string{
+				"CI_COMMIT_SHA":          "abc123",
+				"CI_PROJECT_NAME":        "dummy",
+				"CI_COMMIT_REF_NAME":     "master",
+				"GIT_CREDENTIAL_PROCESS": `sh -c "echo token expired >&2; exit 1"`,
+			},
+			want: -4,
+			wantLogged: []string{
+				"info: generating...",
+				"error: git credentials: credential process 'sh' failed: exit status 1: token expired",
+			},
+		},
 		{
 			name: "clone error",
 			config: `
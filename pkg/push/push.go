@@ -23,13 +23,21 @@
 package push
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/apex/log"
+	refname "github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 
 	"github.com/buildtool/build-tools/pkg/args"
+	"github.com/buildtool/build-tools/pkg/registry"
 	"github.com/buildtool/build-tools/pkg/version"
 
 	"github.com/buildtool/build-tools/pkg/ci"
@@ -39,7 +47,11 @@ import (
 
 type Args struct {
 	args.Globals
-	Dockerfile string `name:"file" short:"f" help:"name of the Dockerfile to use." default:"Dockerfile"`
+	Dockerfile  string `name:"file" short:"f" help:"name of the Dockerfile to use." default:"Dockerfile"`
+	Concurrency int    `help:"number of tags to push concurrently" default:"4"`
+	Retries     int    `help:"number of retries for pushes failing with a transient error" default:"3"`
+	DigestFile  string `name:"digest-file" help:"write a JSON file mapping each pushed tag to its digest" default:""`
+	Force       bool   `help:"push all tags, even those already pointing at the image in the registry"`
 }
 
 var dockerClient = docker.DefaultClient
@@ -65,54 +77,192 @@ func Push(dir string, info version.Info, osArgs ...string) int {
 		log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
 		return -2
 	}
-	return doPush(client, cfg, dir, pushArgs.Dockerfile)
+	return doPush(client, cfg, dir, pushArgs)
 }
 
-func doPush(client docker.Client, cfg *config.Config, dir, dockerfile string) int {
+func doPush(client docker.Client, cfg *config.Config, dir string, pushArgs Args) int {
 	currentCI := cfg.CurrentCI()
-	currentRegistry := cfg.CurrentRegistry()
-
-	if err := currentRegistry.Login(client); err != nil {
+	registries, err := cfg.PushRegistries()
+	if err != nil {
 		log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
 		return -3
 	}
+	if cfg.Build.Go != nil {
+		return pushLayout(cfg, registries, cfg.Build.Go.OutputPath(dir), pushArgs)
+	}
 
-	auth := currentRegistry.GetAuthInfo()
+	auths := make([]string, len(registries))
+	for i, reg := range registries {
+		if err := reg.Login(client); err != nil {
+			log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+			return -3
+		}
 
-	if err := currentRegistry.Create(currentCI.BuildName()); err != nil {
-		log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
-		return -4
-	}
+		auths[i] = reg.GetAuthInfo()
 
-	content, err := os.ReadFile(filepath.Join(dir, dockerfile))
-	if err != nil {
-		log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
-		return -5
+		if err := reg.Create(currentCI.BuildName()); err != nil {
+			log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+			return -4
+		}
 	}
-	stages := docker.FindStages(string(content))
 
-	var tags []string
-	for _, stage := range stages {
-		tags = append(tags, docker.Tag(currentRegistry.RegistryUrl(), currentCI.BuildName(), stage))
+	variants := cfg.BuildVariants(pushArgs.Dockerfile)
+	stages := make([][]string, len(variants))
+	for i, variant := range variants {
+		content, err := os.ReadFile(filepath.Join(dir, variant.Dockerfile))
+		if err != nil {
+			log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+			return -5
+		}
+		stages[i] = docker.FindStages(string(content))
 	}
 
 	if !ci.IsValid(currentCI) {
 		log.Error("Commit and/or branch information is <red>missing</red>. Perhaps your not in a Git repository or forgot to set environment variables?")
 		return -6
 	}
-	tags = append(tags,
-		docker.Tag(currentRegistry.RegistryUrl(), currentCI.BuildName(), currentCI.Commit()),
-		docker.Tag(currentRegistry.RegistryUrl(), currentCI.BuildName(), currentCI.BranchReplaceSlash()),
-	)
-	if currentCI.Branch() == "master" || currentCI.Branch() == "main" {
-		tags = append(tags, docker.Tag(currentRegistry.RegistryUrl(), currentCI.BuildName(), "latest"))
+
+	var names []string
+	for i, variant := range variants {
+		for _, stage := range stages[i] {
+			if cfg.StageExport(stage) != nil {
+				continue
+			}
+			names = append(names, stage+variant.Suffix)
+		}
+		names = append(names, currentCI.Commit()+variant.Suffix, currentCI.BranchReplaceSlash()+variant.Suffix)
+		if currentCI.Branch() == "master" || currentCI.Branch() == "main" {
+			names = append(names, "latest"+variant.Suffix)
+		}
+	}
+
+	// images are built with the tags of the current registry, so they are
+	// tagged with the names in the other registries before pushing
+	var tags []string
+	targets := make(map[string]pushTarget)
+	for i, reg := range registries {
+		for j, name := range names {
+			tag := docker.Tag(reg.RegistryUrl(), currentCI.BuildName(), name)
+			source := tag
+			if i > 0 {
+				source = tags[j]
+			}
+			tags = append(tags, tag)
+			targets[tag] = pushTarget{registry: reg, auth: auths[i], source: source}
+		}
 	}
-	for _, tag := range tags {
-		log.Info(fmt.Sprintf("Pushing tag '<green>%s</green>'\n", tag))
-		if err := currentRegistry.PushImage(client, auth, tag); err != nil {
+	unchanged := func(tag string) string {
+		target := targets[tag]
+		inspect, _, err := client.ImageInspectWithRaw(context.Background(), target.source)
+		if err != nil {
+			return ""
+		}
+		return unchangedDigest(tag, localDigests(tag, inspect), registry.NameOptions(target.registry), registry.RemoteOptions(context.Background(), target.registry))
+	}
+	digests, skipped, err := pushTags(tags, pushArgs, unchanged, func(tag string) (string, error) {
+		target := targets[tag]
+		if target.source != tag {
+			if err := client.ImageTag(context.Background(), target.source, tag); err != nil {
+				return "", err
+			}
+		}
+		return target.registry.PushImage(client, target.auth, tag)
+	})
+	if err != nil {
+		return -7
+	}
+	return reportDigests(tags, digests, skipped, pushArgs.DigestFile)
+}
+
+// pushTarget is where a tag is pushed, and the tag of the built image it's created from
+type pushTarget struct {
+	registry registry.Registry
+	auth     string
+	source   string
+}
+
+// pushLayout pushes the images written to an OCI layout by a Go build, using the registry API
+func pushLayout(cfg *config.Config, registries []registry.Registry, path string, pushArgs Args) int {
+	buildName := cfg.CurrentCI().BuildName()
+	for _, reg := range registries {
+		if err := registry.Authenticate(reg); err != nil {
+			log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+			return -3
+		}
+		if err := reg.Create(buildName); err != nil {
 			log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
-			return -7
+			return -4
+		}
+	}
+
+	index, err := layout.ImageIndexFromPath(path)
+	if err != nil {
+		log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+		return -5
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+		return -5
+	}
+	// the layout is written with the tags of the current registry, which
+	// are renamed to the repositories of the other registries
+	repository := fmt.Sprintf("%s/%s:", registries[0].RegistryUrl(), buildName)
+	var tags []string
+	descriptors := make(map[string]v1.Descriptor)
+	options := make(map[string][]remote.Option)
+	nameOptions := make(map[string][]refname.Option)
+	for i, reg := range registries {
+		regOptions := registry.RemoteOptions(context.Background(), reg)
+		regNameOptions := registry.NameOptions(reg)
+		for _, desc := range manifest.Manifests {
+			tag := desc.Annotations[ocispec.AnnotationRefName]
+			if tag == "" {
+				continue
+			}
+			if i > 0 {
+				if !strings.HasPrefix(tag, repository) {
+					continue
+				}
+				tag = fmt.Sprintf("%s/%s:%s", reg.RegistryUrl(), buildName, strings.TrimPrefix(tag, repository))
+			}
+			tags = append(tags, tag)
+			descriptors[tag] = desc
+			options[tag] = regOptions
+			nameOptions[tag] = regNameOptions
+		}
+	}
+	unchanged := func(tag string) string {
+		return unchangedDigest(tag, []string{descriptors[tag].Digest.String()}, nameOptions[tag], options[tag])
+	}
+	digests, skipped, err := pushTags(tags, pushArgs, unchanged, func(tag string) (string, error) {
+		desc := descriptors[tag]
+		if err := pushDescriptor(index, desc, tag, nameOptions[tag], options[tag]); err != nil {
+			return "", err
+		}
+		return desc.Digest.String(), nil
+	})
+	if err != nil {
+		return -7
+	}
+	return reportDigests(tags, digests, skipped, pushArgs.DigestFile)
+}
+
+func pushDescriptor(index v1.ImageIndex, desc v1.Descriptor, tag string, nameOptions []refname.Option, options []remote.Option) error {
+	ref, err := refname.ParseReference(tag, nameOptions...)
+	if err != nil {
+		return err
+	}
+	if desc.MediaType.IsIndex() {
+		idx, err := index.ImageIndex(desc.Digest)
+		if err != nil {
+			return err
 		}
+		return remote.WriteIndex(ref, idx, options...)
+	}
+	img, err := index.Image(desc.Digest)
+	if err != nil {
+		return err
 	}
-	return 0
+	return remote.Write(ref, img, options...)
 }
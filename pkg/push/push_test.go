@@ -25,12 +25,22 @@ package push
 import (
 	"errors"
 	"fmt"
+	"io"
+	stdlog "log"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/apex/log"
+	refname "github.com/google/go-containerregistry/pkg/name"
+	ggcr "github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	mocks "gitlab.com/unboundsoftware/apex-mocks"
 
 	"github.com/buildtool/build-tools/pkg"
@@ -105,7 +115,7 @@ func TestPush_NoRegistry(t *testing.T) {
 	cfg := config.InitEmptyConfig()
 	cfg.VCS.VCS = &no{}
 
-	exitCode := doPush(client, cfg, name, "Dockerfile")
+	exitCode := doPush(client, cfg, name, Args{Dockerfile: "Dockerfile"})
 
 	assert.Equal(t, -6, exitCode)
 	logMock.Check(t, []string{
@@ -125,7 +135,7 @@ func TestPush_PushError(t *testing.T) {
 	cfg.VCS.VCS = &no{}
 	cfg.Registry.Dockerhub.Namespace = "repo"
 
-	exitCode := doPush(client, cfg, name, "Dockerfile")
+	exitCode := doPush(client, cfg, name, Args{Dockerfile: "Dockerfile"})
 
 	assert.NotNil(t, exitCode)
 	assert.Equal(t, -6, exitCode)
@@ -150,7 +160,7 @@ func TestPush_PushFeatureBranch(t *testing.T) {
 	cfg.CI.Gitlab.CIBranchName = "feature1"
 	cfg.Registry.Dockerhub.Namespace = "repo"
 
-	exitCode := doPush(client, cfg, name, "Dockerfile")
+	exitCode := doPush(client, cfg, name, Args{Dockerfile: "Dockerfile"})
 
 	assert.Equal(t, 0, exitCode)
 	assert.Equal(t, []string{"repo/reponame:abc123", "repo/reponame:feature1"}, client.Images)
@@ -174,7 +184,7 @@ func TestPush_PushMasterBranch(t *testing.T) {
 	cfg.CI.Gitlab.CICommit = "abc123"
 	cfg.CI.Gitlab.CIBranchName = "master"
 	cfg.Registry.Dockerhub.Namespace = "repo"
-	exitCode := doPush(client, cfg, name, "Dockerfile")
+	exitCode := doPush(client, cfg, name, Args{Dockerfile: "Dockerfile"})
 
 	assert.Equal(t, 0, exitCode)
 	assert.Equal(t, []string{
@@ -198,7 +208,7 @@ func TestPush_PushMainBranch(t *testing.T) {
 	cfg.CI.Gitlab.CICommit = "abc123"
 	cfg.CI.Gitlab.CIBranchName = "main"
 	cfg.Registry.Dockerhub.Namespace = "repo"
-	exitCode := doPush(client, cfg, name, "Dockerfile")
+	exitCode := doPush(client, cfg, name, Args{Dockerfile: "Dockerfile"})
 
 	assert.Equal(t, 0, exitCode)
 	assert.Equal(t, []string{"repo/reponame:abc123", "repo/reponame:main", "repo/reponame:latest"}, client.Images)
@@ -232,7 +242,7 @@ COPY --from=test file2 .
 	cfg.CI.Gitlab.CIBranchName = "master"
 	cfg.Registry.Dockerhub.Namespace = "repo"
 
-	exitCode := doPush(client, cfg, name, "Dockerfile")
+	exitCode := doPush(client, cfg, name, Args{Dockerfile: "Dockerfile"})
 
 	assert.Equal(t, 0, exitCode)
 	assert.Equal(t, []string{"repo/reponame:build", "repo/reponame:test", "repo/reponame:abc123", "repo/reponame:master", "repo/reponame:latest"}, client.Images)
@@ -244,6 +254,340 @@ COPY --from=test file2 .
 		"info: Pushing tag '<green>repo/reponame:latest</green>'\n"})
 }
 
+func TestPush_ExportStage(t *testing.T) {
+	defer func() { _ = os.RemoveAll(name) }()
+	dockerfile := `
+FROM scratch as build
+RUN echo apa > file
+FROM scratch as export
+COPY --from=build file .
+FROM scratch
+COPY --from=build file .
+`
+	_ = write(name, "Dockerfile", dockerfile)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	pushOut := `{"status":"Push successful"}`
+	client := &docker.MockDocker{PushOutput: &pushOut}
+	cfg := config.InitEmptyConfig()
+	cfg.CI.Gitlab.CIBuildName = "reponame"
+	cfg.CI.Gitlab.CICommit = "abc123"
+	cfg.CI.Gitlab.CIBranchName = "master"
+	cfg.Registry.Dockerhub.Namespace = "repo"
+
+	exitCode := doPush(client, cfg, name, Args{Dockerfile: "Dockerfile"})
+
+	assert.Equal(t, 0, exitCode)
+	assert.Equal(t, []string{"repo/reponame:build", "repo/reponame:abc123", "repo/reponame:master", "repo/reponame:latest"}, client.Images)
+	logMock.Check(t, []string{"debug: Logged in\n",
+		"info: Pushing tag '<green>repo/reponame:build</green>'\n",
+		"info: Pushing tag '<green>repo/reponame:abc123</green>'\n",
+		"info: Pushing tag '<green>repo/reponame:master</green>'\n",
+		"info: Pushing tag '<green>repo/reponame:latest</green>'\n"})
+}
+
+func TestPush_Variants(t *testing.T) {
+	defer func() { _ = os.RemoveAll(name) }()
+	_ = write(name, "Dockerfile", "FROM alpine")
+	_ = write(name, "Dockerfile.debian", `
+FROM debian as build
+FROM debian
+`)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	pushOut := `{"status":"Push successful"}`
+	client := &docker.MockDocker{PushOutput: &pushOut}
+	cfg := config.InitEmptyConfig()
+	cfg.CI.Gitlab.CIBuildName = "reponame"
+	cfg.CI.Gitlab.CICommit = "abc123"
+	cfg.CI.Gitlab.CIBranchName = "feature1"
+	cfg.Registry.Dockerhub.Namespace = "repo"
+	cfg.Build.Variants = []config.Variant{
+		{Suffix: "-alpine"},
+		{Suffix: "-debian", Dockerfile: "Dockerfile.debian"},
+	}
+
+	exitCode := doPush(client, cfg, name, Args{Dockerfile: "Dockerfile"})
+
+	assert.Equal(t, 0, exitCode)
+	assert.Equal(t, []string{
+		"repo/reponame:abc123-alpine", "repo/reponame:feature1-alpine",
+		"repo/reponame:build-debian", "repo/reponame:abc123-debian", "repo/reponame:feature1-debian",
+	}, client.Images)
+}
+
+func TestPush_AdditionalRegistries(t *testing.T) {
+	defer func() { _ = os.RemoveAll(name) }()
+	_ = write(name, "Dockerfile", "FROM scratch")
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	pushOut := `{"status":"Push successful"}`
+	client := &docker.MockDocker{PushOutput: &pushOut}
+	cfg := config.InitEmptyConfig()
+	cfg.CI.Gitlab.CIBuildName = "reponame"
+	cfg.CI.Gitlab.CICommit = "abc123"
+	cfg.CI.Gitlab.CIBranchName = "feature1"
+	cfg.Registry.Dockerhub.Namespace = "repo"
+	cfg.Registries = map[string]*config.RegistryConfig{
+		"mirror": {Dockerhub: &registry.Dockerhub{Namespace: "mirror"}},
+	}
+	cfg.Push.Registries = []string{"mirror"}
+
+	exitCode := doPush(client, cfg, name, Args{Dockerfile: "Dockerfile"})
+
+	assert.Equal(t, 0, exitCode)
+	assert.Equal(t, []string{"repo/reponame:abc123", "repo/reponame:feature1", "mirror/reponame:abc123", "mirror/reponame:feature1"}, client.Images)
+	assert.Equal(t, []string{"repo/reponame:abc123 -> mirror/reponame:abc123", "repo/reponame:feature1 -> mirror/reponame:feature1"}, client.Tagged)
+	logMock.Check(t, []string{
+		"debug: Logged in\n",
+		"debug: Logged in\n",
+		"info: Pushing tag '<green>repo/reponame:abc123</green>'\n",
+		"info: Pushing tag '<green>repo/reponame:feature1</green>'\n",
+		"info: Pushing tag '<green>mirror/reponame:abc123</green>'\n",
+		"info: Pushing tag '<green>mirror/reponame:feature1</green>'\n"})
+}
+
+func TestPush_UnknownAdditionalRegistry(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	cfg := config.InitEmptyConfig()
+	cfg.Push.Registries = []string{"missing"}
+
+	exitCode := doPush(&docker.MockDocker{}, cfg, name, Args{Dockerfile: "Dockerfile"})
+
+	assert.Equal(t, -3, exitCode)
+	logMock.Check(t, []string{"error: <red>no registry matching missing found</red>"})
+}
+
+func TestPush_TagError(t *testing.T) {
+	defer func() { _ = os.RemoveAll(name) }()
+	_ = write(name, "Dockerfile", "FROM scratch")
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	pushOut := `{"status":"Push successful"}`
+	client := &docker.MockDocker{PushOutput: &pushOut, TagError: fmt.Errorf("no such image")}
+	cfg := config.InitEmptyConfig()
+	cfg.CI.Gitlab.CIBuildName = "reponame"
+	cfg.CI.Gitlab.CICommit = "abc123"
+	cfg.CI.Gitlab.CIBranchName = "feature1"
+	cfg.Registry.Dockerhub.Namespace = "repo"
+	cfg.Registries = map[string]*config.RegistryConfig{
+		"mirror": {Dockerhub: &registry.Dockerhub{Namespace: "mirror"}},
+	}
+	cfg.Push.Registries = []string{"mirror"}
+
+	exitCode := doPush(client, cfg, name, Args{Dockerfile: "Dockerfile"})
+
+	assert.Equal(t, -7, exitCode)
+	assert.Equal(t, []string{"repo/reponame:abc123", "repo/reponame:feature1"}, client.Images)
+}
+
+func TestPush_Unchanged(t *testing.T) {
+	server := httptest.NewServer(ggcr.New(ggcr.Logger(stdlog.New(io.Discard, "", 0))))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	defer func() { _ = os.RemoveAll(name) }()
+	_ = write(name, "Dockerfile", "FROM scratch")
+
+	img, err := random.Image(1024, 1)
+	assert.NoError(t, err)
+	ref, _ := refname.ParseReference(fmt.Sprintf("%s/repo/reponame:abc123", host))
+	assert.NoError(t, remote.Write(ref, img))
+	configName, _ := img.ConfigName()
+	digest, _ := img.Digest()
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	pushOut := `{"status":"Push successful"}`
+	client := &docker.MockDocker{PushOutput: &pushOut, ImageID: configName.String()}
+	cfg := config.InitEmptyConfig()
+	cfg.CI.Gitlab.CIBuildName = "reponame"
+	cfg.CI.Gitlab.CICommit = "abc123"
+	cfg.CI.Gitlab.CIBranchName = "feature1"
+	cfg.Registry.Dockerhub.Namespace = fmt.Sprintf("%s/repo", host)
+
+	exitCode := doPush(client, cfg, name, Args{Dockerfile: "Dockerfile"})
+
+	assert.Equal(t, 0, exitCode)
+	assert.Equal(t, []string{fmt.Sprintf("%s/repo/reponame:feature1", host)}, client.Images)
+	logMock.Check(t, []string{
+		"debug: Logged in\n",
+		fmt.Sprintf("info: Pushing tag '<green>%s/repo/reponame:feature1</green>'\n", host),
+		fmt.Sprintf("info: Skipped '<green>%s/repo/reponame:abc123</green>', already at digest <green>%s</green>\n", host, digest),
+	})
+}
+
+func TestPush_UnchangedForce(t *testing.T) {
+	server := httptest.NewServer(ggcr.New(ggcr.Logger(stdlog.New(io.Discard, "", 0))))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	defer func() { _ = os.RemoveAll(name) }()
+	_ = write(name, "Dockerfile", "FROM scratch")
+
+	img, err := random.Image(1024, 1)
+	assert.NoError(t, err)
+	ref, _ := refname.ParseReference(fmt.Sprintf("%s/repo/reponame:abc123", host))
+	assert.NoError(t, remote.Write(ref, img))
+	digest, _ := img.Digest()
+
+	log.SetHandler(mocks.New())
+	pushOut := `{"status":"Push successful"}`
+	client := &docker.MockDocker{PushOutput: &pushOut, ImageID: "sha256:other", RepoDigests: []string{fmt.Sprintf("%s/repo/reponame@%s", host, digest)}}
+	cfg := config.InitEmptyConfig()
+	cfg.CI.Gitlab.CIBuildName = "reponame"
+	cfg.CI.Gitlab.CICommit = "abc123"
+	cfg.CI.Gitlab.CIBranchName = "feature1"
+	cfg.Registry.Dockerhub.Namespace = fmt.Sprintf("%s/repo", host)
+
+	exitCode := doPush(client, cfg, name, Args{Dockerfile: "Dockerfile"})
+	assert.Equal(t, 0, exitCode)
+	assert.Equal(t, []string{fmt.Sprintf("%s/repo/reponame:feature1", host)}, client.Images)
+
+	client.Images = nil
+	exitCode = doPush(client, cfg, name, Args{Dockerfile: "Dockerfile", Force: true})
+	assert.Equal(t, 0, exitCode)
+	assert.Equal(t, []string{fmt.Sprintf("%s/repo/reponame:abc123", host), fmt.Sprintf("%s/repo/reponame:feature1", host)}, client.Images)
+}
+
+func TestPush_GoLayout(t *testing.T) {
+	server := httptest.NewServer(ggcr.New(ggcr.Logger(stdlog.New(io.Discard, "", 0))))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	defer func() { _ = os.RemoveAll(name) }()
+
+	img, err := random.Image(1024, 1)
+	assert.NoError(t, err)
+	l, err := layout.Write(filepath.Join(name, "oci"), empty.Index)
+	assert.NoError(t, err)
+	for _, tag := range []string{"abc123", "feature1"} {
+		err = l.AppendImage(img, layout.WithAnnotations(map[string]string{ocispec.AnnotationRefName: fmt.Sprintf("%s/repo/reponame:%s", host, tag)}))
+		assert.NoError(t, err)
+	}
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	cfg := config.InitEmptyConfig()
+	cfg.CI.Gitlab.CIBuildName = "reponame"
+	cfg.Registry.Dockerhub.Namespace = fmt.Sprintf("%s/repo", host)
+	cfg.Build.Go = &config.GoBuild{Output: "oci"}
+
+	exitCode := doPush(&docker.MockDocker{}, cfg, name, Args{Dockerfile: "Dockerfile"})
+
+	assert.Equal(t, 0, exitCode)
+	expected, _ := img.Digest()
+	ref, _ := refname.ParseReference(fmt.Sprintf("%s/repo/reponame:feature1", host))
+	desc, err := remote.Head(ref)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, desc.Digest)
+	logMock.Check(t, []string{
+		fmt.Sprintf("info: Pushing tag '<green>%s/repo/reponame:abc123</green>'\n", host),
+		fmt.Sprintf("info: Pushing tag '<green>%s/repo/reponame:feature1</green>'\n", host),
+		fmt.Sprintf("info: Pushed '<green>%s/repo/reponame:abc123</green>' with digest <green>%s</green>\n", host, expected),
+		fmt.Sprintf("info: Pushed '<green>%s/repo/reponame:feature1</green>' with digest <green>%s</green>\n", host, expected),
+	})
+}
+
+func TestPush_GoLayoutAdditionalRegistries(t *testing.T) {
+	server := httptest.NewServer(ggcr.New(ggcr.Logger(stdlog.New(io.Discard, "", 0))))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	defer func() { _ = os.RemoveAll(name) }()
+
+	img, err := random.Image(1024, 1)
+	assert.NoError(t, err)
+	l, err := layout.Write(filepath.Join(name, "oci"), empty.Index)
+	assert.NoError(t, err)
+	err = l.AppendImage(img, layout.WithAnnotations(map[string]string{ocispec.AnnotationRefName: fmt.Sprintf("%s/repo/reponame:abc123", host)}))
+	assert.NoError(t, err)
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	cfg := config.InitEmptyConfig()
+	cfg.CI.Gitlab.CIBuildName = "reponame"
+	cfg.Registry.Dockerhub.Namespace = fmt.Sprintf("%s/repo", host)
+	cfg.Registries = map[string]*config.RegistryConfig{
+		"mirror": {Dockerhub: &registry.Dockerhub{Namespace: fmt.Sprintf("%s/mirror", host)}},
+	}
+	cfg.Push.Registries = []string{"mirror"}
+	cfg.Build.Go = &config.GoBuild{Output: "oci"}
+
+	exitCode := doPush(&docker.MockDocker{}, cfg, name, Args{Dockerfile: "Dockerfile"})
+
+	assert.Equal(t, 0, exitCode)
+	expected, _ := img.Digest()
+	ref, _ := refname.ParseReference(fmt.Sprintf("%s/mirror/reponame:abc123", host))
+	desc, err := remote.Head(ref)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, desc.Digest)
+	logMock.Check(t, []string{
+		fmt.Sprintf("info: Pushing tag '<green>%s/repo/reponame:abc123</green>'\n", host),
+		fmt.Sprintf("info: Pushing tag '<green>%s/mirror/reponame:abc123</green>'\n", host),
+		fmt.Sprintf("info: Pushed '<green>%s/repo/reponame:abc123</green>' with digest <green>%s</green>\n", host, expected),
+		fmt.Sprintf("info: Pushed '<green>%s/mirror/reponame:abc123</green>' with digest <green>%s</green>\n", host, expected),
+	})
+}
+
+func TestPush_GoLayoutUnchanged(t *testing.T) {
+	server := httptest.NewServer(ggcr.New(ggcr.Logger(stdlog.New(io.Discard, "", 0))))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	defer func() { _ = os.RemoveAll(name) }()
+
+	img, err := random.Image(1024, 1)
+	assert.NoError(t, err)
+	l, err := layout.Write(filepath.Join(name, "oci"), empty.Index)
+	assert.NoError(t, err)
+	err = l.AppendImage(img, layout.WithAnnotations(map[string]string{ocispec.AnnotationRefName: fmt.Sprintf("%s/repo/reponame:abc123", host)}))
+	assert.NoError(t, err)
+	ref, _ := refname.ParseReference(fmt.Sprintf("%s/repo/reponame:abc123", host))
+	assert.NoError(t, remote.Write(ref, img))
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	cfg := config.InitEmptyConfig()
+	cfg.CI.Gitlab.CIBuildName = "reponame"
+	cfg.Registry.Dockerhub.Namespace = fmt.Sprintf("%s/repo", host)
+	cfg.Build.Go = &config.GoBuild{Output: "oci"}
+
+	exitCode := doPush(&docker.MockDocker{}, cfg, name, Args{Dockerfile: "Dockerfile"})
+
+	assert.Equal(t, 0, exitCode)
+	expected, _ := img.Digest()
+	logMock.Check(t, []string{
+		fmt.Sprintf("info: Skipped '<green>%s/repo/reponame:abc123</green>', already at digest <green>%s</green>\n", host, expected),
+	})
+}
+
+func TestPush_GoLayoutMissing(t *testing.T) {
+	defer func() { _ = os.RemoveAll(name) }()
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	cfg := config.InitEmptyConfig()
+	cfg.Build.Go = &config.GoBuild{}
+
+	exitCode := doPush(&docker.MockDocker{}, cfg, name, Args{Dockerfile: "Dockerfile"})
+
+	assert.Equal(t, -5, exitCode)
+	logMock.Check(t, []string{
+		fmt.Sprintf("error: <red>stat %s: no such file or directory</red>", filepath.Join(name, config.DefaultGoOutput, "index.json")),
+	})
+}
+
 func TestPush_Output(t *testing.T) {
 	defer func() { _ = os.RemoveAll(name) }()
 	_ = write(name, "Dockerfile", "FROM scratch")
@@ -297,14 +641,72 @@ func TestPush_Output(t *testing.T) {
 	cfg.CI.Gitlab.CIBranchName = "master"
 	cfg.Registry.Dockerhub.Namespace = "repo"
 
-	exitCode := doPush(client, cfg, name, "Dockerfile")
+	exitCode := doPush(client, cfg, name, Args{Dockerfile: "Dockerfile"})
 
 	assert.Equal(t, 0, exitCode)
 	assert.Equal(t, []string{"repo/reponame:abc123", "repo/reponame:master", "repo/reponame:latest"}, client.Images)
 	logMock.Check(t, []string{"debug: Logged in\n",
 		"info: Pushing tag '<green>repo/reponame:abc123</green>'\n",
 		"info: Pushing tag '<green>repo/reponame:master</green>'\n",
-		"info: Pushing tag '<green>repo/reponame:latest</green>'\n"})
+		"info: Pushing tag '<green>repo/reponame:latest</green>'\n",
+		"info: Pushed '<green>repo/reponame:abc123</green>' with digest <green>sha256:af534ee896ce2ac80f3413318329e45e3b3e74b89eb337b9364b8ac1e83498b7</green>\n",
+		"info: Pushed '<green>repo/reponame:master</green>' with digest <green>sha256:af534ee896ce2ac80f3413318329e45e3b3e74b89eb337b9364b8ac1e83498b7</green>\n",
+		"info: Pushed '<green>repo/reponame:latest</green>' with digest <green>sha256:af534ee896ce2ac80f3413318329e45e3b3e74b89eb337b9364b8ac1e83498b7</green>\n",
+	})
+}
+
+func TestPush_ConcurrentWithDigestFile(t *testing.T) {
+	defer func() { _ = os.RemoveAll(name) }()
+	_ = write(name, "Dockerfile", "FROM scratch")
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	pushOut := `{"progressDetail":{},"aux":{"Tag":"abc123","Digest":"sha256:af534ee896ce2ac80f3413318329e45e3b3e74b89eb337b9364b8ac1e83498b7","Size":2828}}`
+	client := &docker.MockDocker{PushOutput: &pushOut}
+	cfg := config.InitEmptyConfig()
+	cfg.CI.Gitlab.CIBuildName = "reponame"
+	cfg.CI.Gitlab.CICommit = "abc123"
+	cfg.CI.Gitlab.CIBranchName = "master"
+	cfg.Registry.Dockerhub.Namespace = "repo"
+	digestFile := filepath.Join(name, "reports", "digests.json")
+
+	exitCode := doPush(client, cfg, name, Args{Dockerfile: "Dockerfile", Concurrency: 3, DigestFile: digestFile})
+
+	assert.Equal(t, 0, exitCode)
+	assert.ElementsMatch(t, []string{"repo/reponame:abc123", "repo/reponame:master", "repo/reponame:latest"}, client.Images)
+	content, err := os.ReadFile(digestFile)
+	assert.NoError(t, err)
+	assert.Equal(t, `{
+  "repo/reponame:abc123": "sha256:af534ee896ce2ac80f3413318329e45e3b3e74b89eb337b9364b8ac1e83498b7",
+  "repo/reponame:latest": "sha256:af534ee896ce2ac80f3413318329e45e3b3e74b89eb337b9364b8ac1e83498b7",
+  "repo/reponame:master": "sha256:af534ee896ce2ac80f3413318329e45e3b3e74b89eb337b9364b8ac1e83498b7"
+}
+`, string(content))
+}
+
+func TestPush_DigestFileError(t *testing.T) {
+	defer func() { _ = os.RemoveAll(name) }()
+	_ = write(name, "Dockerfile", "FROM scratch")
+	_ = write(name, "reports", "not a directory")
+
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.ErrorLevel)
+	pushOut := `{"status":"Push successful"}`
+	client := &docker.MockDocker{PushOutput: &pushOut}
+	cfg := config.InitEmptyConfig()
+	cfg.CI.Gitlab.CIBuildName = "reponame"
+	cfg.CI.Gitlab.CICommit = "abc123"
+	cfg.CI.Gitlab.CIBranchName = "feature1"
+	cfg.Registry.Dockerhub.Namespace = "repo"
+
+	exitCode := doPush(client, cfg, name, Args{Dockerfile: "Dockerfile", DigestFile: filepath.Join(name, "reports", "digests.json")})
+
+	assert.Equal(t, -8, exitCode)
+	logMock.Check(t, []string{
+		fmt.Sprintf("error: <red>mkdir %s: not a directory</red>", filepath.Join(name, "reports")),
+	})
 }
 
 func TestPush_BrokenOutput(t *testing.T) {
@@ -321,7 +723,7 @@ func TestPush_BrokenOutput(t *testing.T) {
 	cfg.CI.Gitlab.CICommit = "abc123"
 	cfg.CI.Gitlab.CIBranchName = "master"
 	cfg.Registry.Dockerhub.Namespace = "repo"
-	exitCode := doPush(client, cfg, name, "Dockerfile")
+	exitCode := doPush(client, cfg, name, Args{Dockerfile: "Dockerfile"})
 
 	assert.Equal(t, -7, exitCode)
 	logMock.Check(t, []string{
@@ -345,7 +747,7 @@ func TestPush_ErrorDetail(t *testing.T) {
 	cfg.CI.Gitlab.CICommit = "abc123"
 	cfg.CI.Gitlab.CIBranchName = "master"
 	cfg.Registry.Dockerhub.Namespace = "repo"
-	exitCode := doPush(client, cfg, name, "Dockerfile")
+	exitCode := doPush(client, cfg, name, Args{Dockerfile: "Dockerfile"})
 
 	assert.Equal(t, -7, exitCode)
 	logMock.Check(t, []string{
@@ -369,7 +771,7 @@ func TestPush_Create_Error(t *testing.T) {
 	cfg.CI.Gitlab.CICommit = "abc123"
 	cfg.CI.Gitlab.CIBranchName = "master"
 	cfg.Registry.Dockerhub.Namespace = "repo"
-	exitCode := doPush(client, cfg, name, "Dockerfile")
+	exitCode := doPush(client, cfg, name, Args{Dockerfile: "Dockerfile"})
 
 	assert.Equal(t, -4, exitCode)
 	logMock.Check(t, []string{
@@ -391,7 +793,7 @@ func TestPush_UnreadableDockerfile(t *testing.T) {
 	cfg.CI.Gitlab.CICommit = "abc123"
 	cfg.CI.Gitlab.CIBranchName = "master"
 	cfg.Registry.Dockerhub.Namespace = "repo"
-	exitCode := doPush(client, cfg, name, "Dockerfile")
+	exitCode := doPush(client, cfg, name, Args{Dockerfile: "Dockerfile"})
 
 	assert.Equal(t, -5, exitCode)
 	logMock.Check(t, []string{
@@ -430,7 +832,7 @@ func (m mockRegistry) Create(repository string) error {
 	return errors.New("create error")
 }
 
-func (m mockRegistry) PushImage(client docker.Client, auth, image string) error {
+func (m mockRegistry) PushImage(client docker.Client, auth, image string) (string, error) {
 	panic("implement me")
 }
 
@@ -0,0 +1,130 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// retryDelay is the delay before the first retry, doubled for each following retry
+var retryDelay = 2 * time.Second
+
+var transientError = regexp.MustCompile(`(?i)blob upload unknown|connection reset|connection refused|unexpected EOF|i/o timeout|TLS handshake timeout|\b50[0234]\b|internal server error|bad gateway|service unavailable|gateway timeout`)
+
+// pushTags pushes the tags with at most Concurrency pushes running at a time, retrying transient failures
+// with exponential backoff. No new pushes are started after a failure. Tags for which unchanged returns
+// a digest already point at the image in the registry and are skipped, unless Force is set.
+// The digest of each tag is returned, together with the tags which were skipped.
+func pushTags(tags []string, pushArgs Args, unchanged func(tag string) string, push func(tag string) (string, error)) (map[string]string, map[string]bool, error) {
+	var mu sync.Mutex
+	digests := make(map[string]string, len(tags))
+	skipped := make(map[string]bool)
+	eg, ctx := errgroup.WithContext(context.Background())
+	eg.SetLimit(max(pushArgs.Concurrency, 1))
+	for _, tag := range tags {
+		eg.Go(func() error {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if !pushArgs.Force {
+				if digest := unchanged(tag); digest != "" {
+					mu.Lock()
+					defer mu.Unlock()
+					digests[tag] = digest
+					skipped[tag] = true
+					return nil
+				}
+			}
+			log.Info(fmt.Sprintf("Pushing tag '<green>%s</green>'\n", tag))
+			digest, err := withRetry(ctx, tag, pushArgs.Retries, push)
+			if err != nil {
+				log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			digests[tag] = digest
+			return nil
+		})
+	}
+	return digests, skipped, eg.Wait()
+}
+
+func withRetry(ctx context.Context, tag string, retries int, push func(tag string) (string, error)) (string, error) {
+	for attempt := 0; ; attempt++ {
+		digest, err := push(tag)
+		if err == nil || attempt >= retries || !isTransient(err) {
+			return digest, err
+		}
+		delay := retryDelay << attempt
+		log.Warnf("<yellow>Pushing tag '%s' failed, retrying in %s: %s</yellow>\n", tag, delay, err)
+		select {
+		case <-ctx.Done():
+			return "", err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// isTransient returns true for errors worth retrying, like server errors and broken connections
+func isTransient(err error) bool {
+	return transientError.MatchString(err.Error())
+}
+
+// reportDigests logs the digest of each pushed or skipped tag and writes them to file, if given
+func reportDigests(tags []string, digests map[string]string, skipped map[string]bool, file string) int {
+	for _, tag := range tags {
+		if digest := digests[tag]; digest == "" {
+			continue
+		} else if skipped[tag] {
+			log.Infof("Skipped '<green>%s</green>', already at digest <green>%s</green>\n", tag, digest)
+		} else {
+			log.Infof("Pushed '<green>%s</green>' with digest <green>%s</green>\n", tag, digest)
+		}
+	}
+	if file == "" {
+		return 0
+	}
+	content, err := json.MarshalIndent(digests, "", "  ")
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(file), 0755); err == nil {
+			err = os.WriteFile(file, append(content, '\n'), 0644)
+		}
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+		return -8
+	}
+	log.Debugf("Wrote digests to <green>%s</green>\n", file)
+	return 0
+}
@@ -0,0 +1,211 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package push
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func withoutRetryDelay(t *testing.T) {
+	delay := retryDelay
+	retryDelay = time.Millisecond
+	t.Cleanup(func() { retryDelay = delay })
+}
+
+// changed is used when all tags must be pushed
+func changed(string) string {
+	return ""
+}
+
+func TestPushTags_RetriesTransientErrors(t *testing.T) {
+	withoutRetryDelay(t)
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	attempts := 0
+
+	digests, _, err := pushTags([]string{"repo/image:abc123"}, Args{Retries: 3}, changed, func(tag string) (string, error) {
+		attempts++
+		if attempts < 3 {
+			return "", errors.New("received unexpected HTTP status: 503 Service Unavailable")
+		}
+		return "sha256:abc", nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, map[string]string{"repo/image:abc123": "sha256:abc"}, digests)
+	logMock.Check(t, []string{
+		"info: Pushing tag '<green>repo/image:abc123</green>'\n",
+		"warn: <yellow>Pushing tag 'repo/image:abc123' failed, retrying in 1ms: received unexpected HTTP status: 503 Service Unavailable</yellow>\n",
+		"warn: <yellow>Pushing tag 'repo/image:abc123' failed, retrying in 2ms: received unexpected HTTP status: 503 Service Unavailable</yellow>\n",
+	})
+}
+
+func TestPushTags_GivesUpAfterRetries(t *testing.T) {
+	withoutRetryDelay(t)
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.ErrorLevel)
+	attempts := 0
+
+	_, _, err := pushTags([]string{"repo/image:abc123"}, Args{Retries: 2}, changed, func(tag string) (string, error) {
+		attempts++
+		return "", errors.New("blob upload unknown")
+	})
+
+	assert.EqualError(t, err, "blob upload unknown")
+	assert.Equal(t, 3, attempts)
+	logMock.Check(t, []string{"error: <red>blob upload unknown</red>"})
+}
+
+func TestPushTags_NoRetryForPermanentErrors(t *testing.T) {
+	withoutRetryDelay(t)
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	attempts := 0
+
+	_, _, err := pushTags([]string{"repo/image:abc123", "repo/image:main"}, Args{Retries: 3}, changed, func(tag string) (string, error) {
+		attempts++
+		return "", errors.New("denied: requested access to the resource is denied")
+	})
+
+	assert.EqualError(t, err, "denied: requested access to the resource is denied")
+	assert.Equal(t, 1, attempts)
+	logMock.Check(t, []string{
+		"info: Pushing tag '<green>repo/image:abc123</green>'\n",
+		"error: <red>denied: requested access to the resource is denied</red>",
+	})
+}
+
+func TestPushTags_BoundedConcurrency(t *testing.T) {
+	log.SetHandler(mocks.New())
+	var running, highest int32
+	tags := []string{"a", "b", "c", "d", "e", "f"}
+
+	digests, _, err := pushTags(tags, Args{Concurrency: 2}, changed, func(tag string) (string, error) {
+		current := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			seen := atomic.LoadInt32(&highest)
+			if current <= seen || atomic.CompareAndSwapInt32(&highest, seen, current) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		return "sha256:" + tag, nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), highest)
+	assert.Equal(t, 6, len(digests))
+	assert.Equal(t, "sha256:c", digests["c"])
+}
+
+func TestPushTags_SkipsUnchanged(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	unchanged := func(tag string) string {
+		if tag == "repo/image:main" {
+			return "sha256:abc"
+		}
+		return ""
+	}
+	var pushed []string
+
+	digests, skipped, err := pushTags([]string{"repo/image:abc123", "repo/image:main"}, Args{}, unchanged, func(tag string) (string, error) {
+		pushed = append(pushed, tag)
+		return "sha256:def", nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"repo/image:abc123"}, pushed)
+	assert.Equal(t, map[string]string{"repo/image:abc123": "sha256:def", "repo/image:main": "sha256:abc"}, digests)
+	assert.Equal(t, map[string]bool{"repo/image:main": true}, skipped)
+	logMock.Check(t, []string{
+		"info: Pushing tag '<green>repo/image:abc123</green>'\n",
+	})
+}
+
+func TestPushTags_ForcePushesUnchanged(t *testing.T) {
+	log.SetHandler(mocks.New())
+	unchanged := func(tag string) string {
+		return "sha256:abc"
+	}
+	var pushed []string
+
+	_, skipped, err := pushTags([]string{"repo/image:abc123", "repo/image:main"}, Args{Force: true}, unchanged, func(tag string) (string, error) {
+		pushed = append(pushed, tag)
+		return "sha256:def", nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"repo/image:abc123", "repo/image:main"}, pushed)
+	assert.Empty(t, skipped)
+}
+
+func TestReportDigests_Skipped(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+
+	exitCode := reportDigests([]string{"repo/image:abc123", "repo/image:main"},
+		map[string]string{"repo/image:abc123": "sha256:def", "repo/image:main": "sha256:abc"},
+		map[string]bool{"repo/image:main": true}, "")
+
+	assert.Equal(t, 0, exitCode)
+	logMock.Check(t, []string{
+		"info: Pushed '<green>repo/image:abc123</green>' with digest <green>sha256:def</green>\n",
+		"info: Skipped '<green>repo/image:main</green>', already at digest <green>sha256:abc</green>\n",
+	})
+}
+
+func TestIsTransient(t *testing.T) {
+	for _, msg := range []string{
+		"blob upload unknown to registry",
+		"read tcp 10.0.0.1:443: read: connection reset by peer",
+		"received unexpected HTTP status: 500 Internal Server Error",
+		"received unexpected HTTP status: 502 Bad Gateway",
+		"unexpected EOF",
+		"net/http: TLS handshake timeout",
+	} {
+		assert.True(t, isTransient(errors.New(msg)), msg)
+	}
+	for _, msg := range []string{
+		"denied: requested access to the resource is denied",
+		"manifest invalid",
+		"received unexpected HTTP status: 404 Not Found",
+	} {
+		assert.False(t, isTransient(errors.New(msg)), msg)
+	}
+}
@@ -0,0 +1,81 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package push
+
+import (
+	"slices"
+
+	"github.com/docker/docker/api/types"
+	refname "github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// localDigests returns the digests identifying a local image, its ID and the digests it
+// was pushed with to the repository of tag
+func localDigests(tag string, inspect types.ImageInspect) []string {
+	digests := []string{inspect.ID}
+	ref, err := refname.ParseReference(tag)
+	if err != nil {
+		return digests
+	}
+	for _, repoDigest := range inspect.RepoDigests {
+		digestRef, err := refname.NewDigest(repoDigest)
+		if err == nil && digestRef.Context().Name() == ref.Context().Name() {
+			digests = append(digests, digestRef.DigestStr())
+		}
+	}
+	return digests
+}
+
+// unchangedDigest returns the digest of tag in the registry if it matches one of the local digests,
+// in which case pushing the tag can be skipped. An empty string is returned if the tag must be pushed.
+func unchangedDigest(tag string, local []string, nameOptions []refname.Option, options []remote.Option) string {
+	if len(local) == 0 {
+		return ""
+	}
+	ref, err := refname.ParseReference(tag, nameOptions...)
+	if err != nil {
+		return ""
+	}
+	desc, err := remote.Head(ref, options...)
+	if err != nil {
+		return ""
+	}
+	digest := desc.Digest.String()
+	if slices.Contains(local, digest) {
+		return digest
+	}
+	// images in the classic Docker image store are identified by the digest of their config
+	if !desc.MediaType.IsImage() {
+		return ""
+	}
+	img, err := remote.Image(ref, options...)
+	if err != nil {
+		return ""
+	}
+	config, err := img.ConfigName()
+	if err != nil || !slices.Contains(local, config.String()) {
+		return ""
+	}
+	return digest
+}
@@ -0,0 +1,196 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/docker/docker/api/types/registry"
+
+	"github.com/buildtool/build-tools/pkg/docker"
+)
+
+// acrTokenUsername is the username used when logging in with a refresh token
+const acrTokenUsername = "00000000-0000-0000-0000-000000000000"
+
+// azureAuthority is where service principals get Azure AD access tokens
+var azureAuthority = "https://login.microsoftonline.com"
+
+type ACR struct {
+	dockerRegistry `yaml:"-"`
+	Url            string `yaml:"url" env:"ACR_URL"`
+	// Username and Password of the admin user or a repository scoped token
+	Username string `yaml:"username,omitempty" env:"ACR_USERNAME"`
+	Password string `yaml:"password,omitempty" env:"ACR_PASSWORD"`
+	// TenantId, ClientId and ClientSecret of a service principal
+	TenantId     string `yaml:"tenantId,omitempty" env:"AZURE_TENANT_ID"`
+	ClientId     string `yaml:"clientId,omitempty" env:"AZURE_CLIENT_ID"`
+	ClientSecret string `yaml:"clientSecret,omitempty" env:"AZURE_CLIENT_SECRET"`
+	// AccessToken is an Azure AD access token, for example from az account get-access-token
+	AccessToken       string `yaml:"accessToken,omitempty" env:"AZURE_ACCESS_TOKEN"`
+	CredentialProcess string `yaml:"credentialProcess,omitempty" env:"ACR_CREDENTIAL_PROCESS"`
+	refreshToken      string
+	client            *http.Client
+}
+
+var _ Registry = &ACR{}
+
+func (r *ACR) Name() string {
+	return "ACR"
+}
+
+func (r *ACR) Configured() bool {
+	return len(r.Url) > 0
+}
+
+func (r *ACR) Login(client docker.Client) error {
+	if err := r.fetchCredentials(); err != nil {
+		return err
+	}
+
+	if ok, err := client.RegistryLogin(context.Background(), r.GetAuthConfig()); err == nil {
+		log.Debugf("%s\n", ok.Status)
+		return nil
+	} else {
+		return err
+	}
+}
+
+// fetchCredentials exchanges an Azure AD access token for a refresh token for the registry,
+// unless a credential process or a username and password are configured. The access token of a service principal is fetched first if needed.
+// Credentials stored by docker login or a credential helper are used if nothing is configured.
+func (r *ACR) fetchCredentials() error {
+	if r.CredentialProcess != "" {
+		_, err := r.credentials(r.resolve)
+		return err
+	}
+	if r.Username != "" && r.Password != "" {
+		return nil
+	}
+	accessToken := r.AccessToken
+	if accessToken == "" {
+		if r.TenantId == "" || r.ClientId == "" || r.ClientSecret == "" {
+			if docker.HasCredentials(r.GetAuthConfig()) {
+				return nil
+			}
+			return errors.New("no credentials for ACR, configure username and password, a service principal or an access token, or use docker login")
+		}
+		token, err := r.servicePrincipalToken()
+		if err != nil {
+			return err
+		}
+		accessToken = token
+	}
+	refreshToken, err := r.exchange(accessToken)
+	if err != nil {
+		return err
+	}
+	r.refreshToken = refreshToken
+	return nil
+}
+
+// servicePrincipalToken fetches an Azure AD access token using the client credentials of the service principal
+func (r *ACR) servicePrincipalToken() (string, error) {
+	var response struct {
+		AccessToken string `json:"access_token"`
+	}
+	err := r.post(fmt.Sprintf("%s/%s/oauth2/v2.0/token", azureAuthority, r.TenantId), url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {r.ClientId},
+		"client_secret": {r.ClientSecret},
+		"scope":         {"https://management.azure.com/.default"},
+	}, &response)
+	if err != nil {
+		return "", fmt.Errorf("unable to get access token for service principal: %w", err)
+	}
+	return response.AccessToken, nil
+}
+
+// exchange exchanges an Azure AD access token for a refresh token for the registry
+func (r *ACR) exchange(accessToken string) (string, error) {
+	var response struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	values := url.Values{
+		"grant_type":   {"access_token"},
+		"service":      {r.Url},
+		"access_token": {accessToken},
+	}
+	if r.TenantId != "" {
+		values.Set("tenant", r.TenantId)
+	}
+	if err := r.post(fmt.Sprintf("https://%s/oauth2/exchange", r.Url), values, &response); err != nil {
+		return "", fmt.Errorf("unable to exchange access token for ACR refresh token: %w", err)
+	}
+	return response.RefreshToken, nil
+}
+
+func (r *ACR) post(endpoint string, values url.Values, response interface{}) error {
+	client := r.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Post(endpoint, "application/x-www-form-urlencoded", strings.NewReader(values.Encode()))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+func (r *ACR) GetAuthConfig() registry.AuthConfig {
+	if r.refreshToken != "" {
+		return registry.AuthConfig{Username: acrTokenUsername, Password: r.refreshToken, ServerAddress: r.Url}
+	}
+	return r.cachedCredentials(r.resolve)
+}
+
+func (r *ACR) resolve() (registry.AuthConfig, *time.Time, error) {
+	return resolveCredentials(registry.AuthConfig{Username: r.Username, Password: r.Password, ServerAddress: r.Url}, r.CredentialProcess, r.Url)
+}
+
+func (r *ACR) GetAuthInfo() string {
+	authBytes, _ := json.Marshal(r.GetAuthConfig())
+	return base64.URLEncoding.EncodeToString(authBytes)
+}
+
+func (r *ACR) RegistryUrl() string {
+	return r.Url
+}
+
+// Create does nothing, repositories are created by ACR when pushed to
+func (r *ACR) Create(repository string) error {
+	return nil
+}
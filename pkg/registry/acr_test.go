@@ -0,0 +1,159 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+
+	"github.com/buildtool/build-tools/pkg/docker"
+)
+
+// acrServer serves the Azure AD token and ACR exchange endpoints, recording the posted forms
+func acrServer(t *testing.T, forms map[string]map[string][]string) *httptest.Server {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		forms[r.URL.Path] = r.PostForm
+		switch r.URL.Path {
+		case "/tenant/oauth2/v2.0/token":
+			_, _ = fmt.Fprint(w, `{"token_type":"Bearer","access_token":"aad-token"}`)
+		case "/oauth2/exchange":
+			if r.PostForm.Get("access_token") == "invalid" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = fmt.Fprint(w, `{"refresh_token":"refresh-token"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	authority := azureAuthority
+	azureAuthority = server.URL
+	t.Cleanup(func() { azureAuthority = authority })
+	return server
+}
+
+func TestAcr_LoginUsernamePassword(t *testing.T) {
+	client := &docker.MockDocker{}
+	registry := &ACR{Url: "example.azurecr.io", Username: "admin", Password: "secret"}
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	err := registry.Login(client)
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", client.Username)
+	assert.Equal(t, "secret", client.Password)
+	assert.Equal(t, "example.azurecr.io", client.ServerAddress)
+	logMock.Check(t, []string{"debug: Logged in\n"})
+}
+
+func TestAcr_LoginServicePrincipal(t *testing.T) {
+	forms := map[string]map[string][]string{}
+	server := acrServer(t, forms)
+	host := strings.TrimPrefix(server.URL, "https://")
+	client := &docker.MockDocker{}
+	registry := &ACR{Url: host, TenantId: "tenant", ClientId: "client", ClientSecret: "secret", client: server.Client()}
+	log.SetHandler(mocks.New())
+	err := registry.Login(client)
+	assert.NoError(t, err)
+	assert.Equal(t, "00000000-0000-0000-0000-000000000000", client.Username)
+	assert.Equal(t, "refresh-token", client.Password)
+	assert.Equal(t, host, client.ServerAddress)
+	assert.Equal(t, map[string][]string{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {"client"},
+		"client_secret": {"secret"},
+		"scope":         {"https://management.azure.com/.default"},
+	}, forms["/tenant/oauth2/v2.0/token"])
+	assert.Equal(t, map[string][]string{
+		"grant_type":   {"access_token"},
+		"service":      {host},
+		"tenant":       {"tenant"},
+		"access_token": {"aad-token"},
+	}, forms["/oauth2/exchange"])
+}
+
+func TestAcr_LoginAccessToken(t *testing.T) {
+	forms := map[string]map[string][]string{}
+	server := acrServer(t, forms)
+	host := strings.TrimPrefix(server.URL, "https://")
+	registry := &ACR{Url: host, AccessToken: "token", client: server.Client()}
+	err := registry.fetchCredentials()
+	assert.NoError(t, err)
+	assert.Equal(t, "refresh-token", registry.GetAuthConfig().Password)
+	assert.NotContains(t, forms, "/tenant/oauth2/v2.0/token")
+	assert.Equal(t, []string{"token"}, forms["/oauth2/exchange"]["access_token"])
+}
+
+func TestAcr_LoginExchangeFailed(t *testing.T) {
+	server := acrServer(t, map[string]map[string][]string{})
+	host := strings.TrimPrefix(server.URL, "https://")
+	client := &docker.MockDocker{}
+	registry := &ACR{Url: host, AccessToken: "invalid", client: server.Client()}
+	err := registry.Login(client)
+	assert.EqualError(t, err, "unable to exchange access token for ACR refresh token: unexpected status 401 Unauthorized")
+	assert.Equal(t, "", client.Username)
+}
+
+func TestAcr_LoginServicePrincipalFailed(t *testing.T) {
+	server := acrServer(t, map[string]map[string][]string{})
+	host := strings.TrimPrefix(server.URL, "https://")
+	registry := &ACR{Url: host, TenantId: "other", ClientId: "client", ClientSecret: "secret", client: server.Client()}
+	err := registry.fetchCredentials()
+	assert.EqualError(t, err, "unable to get access token for service principal: unexpected status 404 Not Found")
+}
+
+func TestAcr_LoginNoCredentials(t *testing.T) {
+	registry := &ACR{Url: "example.azurecr.io"}
+	err := registry.Login(&docker.MockDocker{})
+	assert.EqualError(t, err, "no credentials for ACR, configure username and password, a service principal or an access token, or use docker login")
+}
+
+func TestAcr_LoginFailed(t *testing.T) {
+	client := &docker.MockDocker{LoginError: fmt.Errorf("invalid username/password")}
+	registry := &ACR{Url: "example.azurecr.io", Username: "admin", Password: "secret"}
+	err := registry.Login(client)
+	assert.EqualError(t, err, "invalid username/password")
+}
+
+func TestAcr_GetAuthInfo(t *testing.T) {
+	registry := &ACR{Url: "example.azurecr.io", Username: "admin", Password: "secret"}
+	auth := registry.GetAuthInfo()
+	assert.Equal(t, "eyJ1c2VybmFtZSI6ImFkbWluIiwicGFzc3dvcmQiOiJzZWNyZXQiLCJzZXJ2ZXJhZGRyZXNzIjoiZXhhbXBsZS5henVyZWNyLmlvIn0=", auth)
+}
+
+func TestAcr_Create(t *testing.T) {
+	reg := &ACR{Url: "example.azurecr.io"}
+	assert.NoError(t, reg.Create("repo"))
+	assert.Equal(t, "example.azurecr.io", reg.RegistryUrl())
+	assert.Equal(t, registry.AuthConfig{ServerAddress: "example.azurecr.io"}, reg.GetAuthConfig())
+}
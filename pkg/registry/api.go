@@ -0,0 +1,88 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package registry
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// apiClient makes JSON requests to the REST API of a registry
+type apiClient struct {
+	client  *http.Client
+	baseUrl string
+	// auth is the Authorization header of the requests, if any
+	auth string
+	// header are additional headers of the requests
+	header http.Header
+}
+
+// bearer returns an Authorization header for the token
+func bearer(token string) string {
+	return fmt.Sprintf("Bearer %s", token)
+}
+
+// basic returns an Authorization header for the username and password
+func basic(username, password string) string {
+	return fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte(username+":"+password)))
+}
+
+// request makes a request to the path of the API, with body encoded as JSON. The response is
+// decoded into response when the request succeeds with 200 OK, and the status code is returned.
+func (a apiClient) request(method, path string, body, response interface{}) (int, error) {
+	var content bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&content).Encode(body); err != nil {
+			return 0, err
+		}
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("%s%s", a.baseUrl, path), &content)
+	if err != nil {
+		return 0, err
+	}
+	for key, values := range a.header {
+		req.Header[key] = values
+	}
+	if a.auth != "" {
+		req.Header.Set("Authorization", a.auth)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := a.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if response != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+			return 0, err
+		}
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,124 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// apiServer is a fake registry API, recording the requests made
+type apiServer struct {
+	*httptest.Server
+	mu sync.Mutex
+	// exists is whether the resource requested exists, it's set when created
+	exists bool
+	// createStatus is returned when creating, if set
+	createStatus int
+	requests     []string
+	// bodies are the JSON bodies of the requests, by method and path
+	bodies map[string]map[string]interface{}
+	// routes answer requests for their paths without authentication, like token endpoints
+	routes map[string]http.HandlerFunc
+	// authorized reports whether the request has the expected credentials
+	authorized func(r *http.Request) bool
+	// respond answers authorized requests for existing resources and requests creating them
+	respond http.HandlerFunc
+}
+
+// newApiServer returns an unstarted server, which is started after routes, authorized and respond are set
+func newApiServer(t *testing.T, exists bool) *apiServer {
+	s := &apiServer{exists: exists, bodies: map[string]map[string]interface{}{}, routes: map[string]http.HandlerFunc{}}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests = append(s.requests, fmt.Sprintf("%s %s", r.Method, r.URL.RequestURI()))
+		if r.ContentLength > 0 && r.Header.Get("Content-Type") == "application/json" {
+			body := map[string]interface{}{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			s.bodies[fmt.Sprintf("%s %s", r.Method, r.URL.Path)] = body
+		}
+		if route, ok := s.routes[r.URL.Path]; ok {
+			route(w, r)
+			return
+		}
+		if !s.authorized(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPost && s.createStatus != 0:
+			w.WriteHeader(s.createStatus)
+		case r.Method == http.MethodPost:
+			s.exists = true
+			s.respond(w, r)
+		case !s.exists:
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			s.respond(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestApiClient_Request(t *testing.T) {
+	server := newApiServer(t, true)
+	server.authorized = func(r *http.Request) bool {
+		user, pass, _ := r.BasicAuth()
+		return user == "user" && pass == "pass" && r.Header.Get("X-Custom") == "value" && r.Header.Get("Content-Type") == "application/json"
+	}
+	server.respond = func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"name":"repo"}`)
+	}
+	server.Start()
+
+	client := apiClient{client: server.Client(), baseUrl: server.URL + "/api", auth: basic("user", "pass"), header: http.Header{"X-Custom": {"value"}}}
+	var response struct {
+		Name string `json:"name"`
+	}
+	status, err := client.request(http.MethodPatch, "/repo", map[string]string{"description": "The repo"}, &response)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "repo", response.Name)
+	assert.Equal(t, []string{"PATCH /api/repo"}, server.requests)
+	assert.Equal(t, map[string]interface{}{"description": "The repo"}, server.bodies["PATCH /api/repo"])
+}
+
+func TestApiClient_Unauthorized(t *testing.T) {
+	server := newApiServer(t, true)
+	server.authorized = func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == bearer("token")
+	}
+	server.Start()
+
+	status, err := apiClient{client: server.Client(), baseUrl: server.URL}.request(http.MethodGet, "/repo", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
@@ -0,0 +1,243 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/docker/docker/api/types/registry"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	"github.com/buildtool/build-tools/pkg/docker"
+)
+
+// artifactRegistryApi is the base URL of the Artifact Registry API
+var artifactRegistryApi = "https://artifactregistry.googleapis.com/v1"
+
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// defaultTokenSource finds the application default credentials, replaced in tests
+var defaultTokenSource = google.DefaultTokenSource
+
+// artifactRegistryPollInterval is how long to wait between checking if a repository has been created
+var artifactRegistryPollInterval = time.Second
+
+type ArtifactRegistry struct {
+	dockerRegistry `yaml:"-"`
+	// Url of the repository, like europe-north1-docker.pkg.dev/my-project/my-repository
+	Url            string `yaml:"url" env:"GAR_URL"`
+	KeyFileContent string `yaml:"keyfileContent,omitempty" env:"GAR_KEYFILE_CONTENT"`
+	// AccessToken is an OAuth2 access token, for example from gcloud auth print-access-token
+	AccessToken       string `yaml:"accessToken,omitempty" env:"GAR_ACCESS_TOKEN"`
+	CredentialProcess string `yaml:"credentialProcess,omitempty" env:"GAR_CREDENTIAL_PROCESS"`
+	// Description of repositories created by Create
+	Description string `yaml:"description,omitempty"`
+	client      *http.Client
+}
+
+var _ Registry = &ArtifactRegistry{}
+
+func (r *ArtifactRegistry) Name() string {
+	return "Artifact Registry"
+}
+
+func (r *ArtifactRegistry) Configured() bool {
+	return len(r.Url) > 0
+}
+
+func (r *ArtifactRegistry) Login(client docker.Client) error {
+	auth, err := r.credentials(r.resolve)
+	if err != nil {
+		return err
+	}
+	if ok, err := client.RegistryLogin(context.Background(), auth); err == nil {
+		log.Debugf("%s\n", ok.Status)
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (r *ArtifactRegistry) GetAuthConfig() registry.AuthConfig {
+	return r.cachedCredentials(r.resolve)
+}
+
+func (r *ArtifactRegistry) resolve() (registry.AuthConfig, *time.Time, error) {
+	host, _, _ := strings.Cut(r.Url, "/")
+	if r.CredentialProcess != "" {
+		return withCredentialProcess(registry.AuthConfig{ServerAddress: host}, r.CredentialProcess)
+	}
+	if r.AccessToken != "" {
+		return registry.AuthConfig{Username: "oauth2accesstoken", Password: r.AccessToken, ServerAddress: host}, nil, nil
+	}
+	if r.KeyFileContent == "" {
+		return withDockerCredentials(registry.AuthConfig{ServerAddress: host}, host), nil, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(r.KeyFileContent)
+	if err != nil {
+		return registry.AuthConfig{ServerAddress: host}, nil, fmt.Errorf("unable to decode key file content: %w", err)
+	}
+	return registry.AuthConfig{Username: "_json_key", Password: string(decoded), ServerAddress: host}, nil, nil
+}
+
+func (r *ArtifactRegistry) GetAuthInfo() string {
+	authBytes, _ := json.Marshal(r.GetAuthConfig())
+	return base64.URLEncoding.EncodeToString(authBytes)
+}
+
+func (r *ArtifactRegistry) RegistryUrl() string {
+	return r.Url
+}
+
+// Create creates the Docker repository of the url if missing, images in the repository are created when pushed to
+func (r *ArtifactRegistry) Create(repository string) error {
+	location, project, name, err := r.parseUrl()
+	if err != nil {
+		return err
+	}
+	token, err := r.token()
+	if err != nil {
+		return err
+	}
+	parent := fmt.Sprintf("projects/%s/locations/%s", project, location)
+	status, err := r.api(token).request(http.MethodGet, fmt.Sprintf("/%s/repositories/%s", parent, name), nil, nil)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusOK {
+		return nil
+	}
+	if status != http.StatusNotFound {
+		return fmt.Errorf("unable to check if repository %s exists: unexpected status %d", name, status)
+	}
+
+	log.Debugf("Creating repository '<green>%s</green>' in Artifact Registry\n", name)
+	var operation artifactRegistryOperation
+	body := map[string]string{"format": "DOCKER", "description": r.Description}
+	if status, err := r.api(token).request(http.MethodPost, fmt.Sprintf("/%s/repositories?repositoryId=%s", parent, url.QueryEscape(name)), body, &operation); err != nil {
+		return err
+	} else if status == http.StatusConflict {
+		return nil
+	} else if status != http.StatusOK {
+		return fmt.Errorf("unable to create repository %s: unexpected status %d", name, status)
+	}
+	for !operation.Done {
+		time.Sleep(artifactRegistryPollInterval)
+		if status, err := r.api(token).request(http.MethodGet, fmt.Sprintf("/%s", operation.Name), nil, &operation); err != nil {
+			return err
+		} else if status != http.StatusOK {
+			return fmt.Errorf("unable to get status of creating repository %s: unexpected status %d", name, status)
+		}
+	}
+	if operation.Error != nil {
+		return fmt.Errorf("unable to create repository %s: %s", name, operation.Error.Message)
+	}
+	return nil
+}
+
+// artifactRegistryOperation is the long-running operation returned when creating a repository
+type artifactRegistryOperation struct {
+	Name  string `json:"name"`
+	Done  bool   `json:"done"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// parseUrl extracts the location, project and repository from an url like <location>-docker.pkg.dev/<project>/<repository>
+func (r *ArtifactRegistry) parseUrl() (string, string, string, error) {
+	parts := strings.Split(r.Url, "/")
+	if len(parts) != 3 || !strings.HasSuffix(parts[0], "-docker.pkg.dev") {
+		return "", "", "", fmt.Errorf("invalid Artifact Registry url %s, expected <location>-docker.pkg.dev/<project>/<repository>", r.Url)
+	}
+	return strings.TrimSuffix(parts[0], "-docker.pkg.dev"), parts[1], parts[2], nil
+}
+
+// token returns an access token for the Artifact Registry API. Service account keys, configured, from a credential process
+// or stored by docker login -u _json_key, are exchanged for an access token. Other credentials, like those of the gcloud
+// credential helper, are access tokens. Without any credentials, the application default credentials are used
+func (r *ArtifactRegistry) token() (string, error) {
+	auth, err := r.credentials(r.resolve)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case auth.Username == "_json_key":
+		return r.keyToken([]byte(auth.Password))
+	case auth.Username == "_json_key_base64":
+		decoded, err := base64.StdEncoding.DecodeString(auth.Password)
+		if err != nil {
+			return "", fmt.Errorf("unable to decode key file content: %w", err)
+		}
+		return r.keyToken(decoded)
+	case auth.Password != "":
+		return auth.Password, nil
+	}
+	source, err := defaultTokenSource(r.context(), cloudPlatformScope)
+	if err != nil {
+		return "", fmt.Errorf("no credentials for Artifact Registry, configure a key file or an access token, or use the gcloud credential helper or application default credentials: %w", err)
+	}
+	return accessToken(source, "application default credentials")
+}
+
+// keyToken fetches an access token for the service account of the key file
+func (r *ArtifactRegistry) keyToken(key []byte) (string, error) {
+	config, err := google.JWTConfigFromJSON(key, cloudPlatformScope)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse key file: %w", err)
+	}
+	return accessToken(config.TokenSource(r.context()), "service account")
+}
+
+func (r *ArtifactRegistry) context() context.Context {
+	ctx := context.Background()
+	if r.client != nil {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, r.client)
+	}
+	return ctx
+}
+
+func accessToken(source oauth2.TokenSource, from string) (string, error) {
+	token, err := source.Token()
+	if err != nil {
+		return "", fmt.Errorf("unable to get access token for %s: %w", from, err)
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("unable to get access token for %s: empty token", from)
+	}
+	return token.AccessToken, nil
+}
+
+// api returns a client for the Artifact Registry API, authenticated with the access token
+func (r *ArtifactRegistry) api(token string) apiClient {
+	return apiClient{client: r.client, baseUrl: artifactRegistryApi, auth: bearer(token)}
+}
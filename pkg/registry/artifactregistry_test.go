@@ -0,0 +1,223 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package registry
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+
+	"github.com/buildtool/build-tools/pkg/docker"
+)
+
+// artifactRegistryServer is a fake Artifact Registry API with a Google token endpoint
+type artifactRegistryServer struct {
+	*apiServer
+	// pending is the number of times the create operation is reported as not done
+	pending        int
+	operationError string
+}
+
+func newArtifactRegistryServer(t *testing.T, exists bool) *artifactRegistryServer {
+	s := &artifactRegistryServer{apiServer: newApiServer(t, exists)}
+	s.routes["/token"] = func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprint(w, `{"access_token":"sa-token","token_type":"Bearer","expires_in":3600}`)
+	}
+	s.authorized = func(r *http.Request) bool {
+		auth := r.Header.Get("Authorization")
+		return auth == "Bearer sa-token" || auth == "Bearer access-token"
+	}
+	s.respond = func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost || r.URL.Path == "/v1/projects/project/locations/europe-north1/operations/create" {
+			s.operation(w)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"name":"projects/project/locations/europe-north1/repositories/repository"}`)
+	}
+	s.Start()
+	previousApi, previousInterval := artifactRegistryApi, artifactRegistryPollInterval
+	artifactRegistryApi, artifactRegistryPollInterval = s.URL+"/v1", time.Millisecond
+	t.Cleanup(func() { artifactRegistryApi, artifactRegistryPollInterval = previousApi, previousInterval })
+	return s
+}
+
+func (s *artifactRegistryServer) operation(w http.ResponseWriter) {
+	operation := map[string]interface{}{"name": "projects/project/locations/europe-north1/operations/create", "done": s.pending == 0}
+	if s.pending > 0 {
+		s.pending--
+	} else if s.operationError != "" {
+		operation["error"] = map[string]interface{}{"message": s.operationError}
+	}
+	_ = json.NewEncoder(w).Encode(operation)
+}
+
+// keyFile returns a base64 encoded service account key file using the token endpoint of the server
+func (s *artifactRegistryServer) keyFile(t *testing.T) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	content, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "builder@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+		"token_uri":    s.URL + "/token",
+	})
+	assert.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(content)
+}
+
+func (s *artifactRegistryServer) registry(t *testing.T) *ArtifactRegistry {
+	return &ArtifactRegistry{Url: "europe-north1-docker.pkg.dev/project/repository", KeyFileContent: s.keyFile(t), client: s.Client()}
+}
+
+func TestArtifactRegistry_Configured(t *testing.T) {
+	assert.False(t, (&ArtifactRegistry{KeyFileContent: "a2V5ZmlsZSBjb250ZW50Cg=="}).Configured())
+	assert.True(t, (&ArtifactRegistry{Url: "europe-north1-docker.pkg.dev/project/repository"}).Configured())
+	assert.True(t, (&ArtifactRegistry{Url: "europe-north1-docker.pkg.dev/project/repository", KeyFileContent: "a2V5ZmlsZSBjb250ZW50Cg=="}).Configured())
+	assert.True(t, (&ArtifactRegistry{Url: "europe-north1-docker.pkg.dev/project/repository", AccessToken: "token"}).Configured())
+}
+
+func TestArtifactRegistry_LoginSuccess(t *testing.T) {
+	client := &docker.MockDocker{}
+	registry := &ArtifactRegistry{Url: "europe-north1-docker.pkg.dev/project/repository", KeyFileContent: "a2V5ZmlsZSBjb250ZW50Cg=="}
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	err := registry.Login(client)
+	assert.NoError(t, err)
+	assert.Equal(t, "_json_key", client.Username)
+	assert.Equal(t, "keyfile content\n", client.Password)
+	assert.Equal(t, "europe-north1-docker.pkg.dev", client.ServerAddress)
+	assert.Equal(t, "europe-north1-docker.pkg.dev/project/repository", registry.RegistryUrl())
+	logMock.Check(t, []string{"debug: Logged in\n"})
+}
+
+func TestArtifactRegistry_LoginAccessToken(t *testing.T) {
+	client := &docker.MockDocker{}
+	registry := &ArtifactRegistry{Url: "europe-north1-docker.pkg.dev/project/repository", AccessToken: "token"}
+	err := registry.Login(client)
+	assert.NoError(t, err)
+	assert.Equal(t, "oauth2accesstoken", client.Username)
+	assert.Equal(t, "token", client.Password)
+}
+
+func TestArtifactRegistry_LoginFailed(t *testing.T) {
+	client := &docker.MockDocker{LoginError: fmt.Errorf("invalid username/password")}
+	registry := &ArtifactRegistry{Url: "europe-north1-docker.pkg.dev/project/repository", AccessToken: "token"}
+	err := registry.Login(client)
+	assert.EqualError(t, err, "invalid username/password")
+}
+
+func TestArtifactRegistry_ExistingRepository(t *testing.T) {
+	server := newArtifactRegistryServer(t, true)
+	err := server.registry(t).Create("image")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"POST /token", "GET /v1/projects/project/locations/europe-north1/repositories/repository"}, server.requests)
+}
+
+func TestArtifactRegistry_NewRepository(t *testing.T) {
+	server := newArtifactRegistryServer(t, false)
+	server.pending = 2
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	registry := server.registry(t)
+	registry.Description = "Images of the team"
+	err := registry.Create("image")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"POST /token",
+		"GET /v1/projects/project/locations/europe-north1/repositories/repository",
+		"POST /v1/projects/project/locations/europe-north1/repositories?repositoryId=repository",
+		"GET /v1/projects/project/locations/europe-north1/operations/create",
+		"GET /v1/projects/project/locations/europe-north1/operations/create",
+	}, server.requests)
+	assert.Equal(t, map[string]interface{}{"format": "DOCKER", "description": "Images of the team"}, server.bodies["POST /v1/projects/project/locations/europe-north1/repositories"])
+	logMock.Check(t, []string{"debug: Creating repository '<green>repository</green>' in Artifact Registry\n"})
+}
+
+func TestArtifactRegistry_NewRepositoryAccessToken(t *testing.T) {
+	server := newArtifactRegistryServer(t, false)
+	log.SetHandler(mocks.New())
+	registry := &ArtifactRegistry{Url: "europe-north1-docker.pkg.dev/project/repository", AccessToken: "access-token", client: server.Client()}
+	err := registry.Create("image")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"GET /v1/projects/project/locations/europe-north1/repositories/repository",
+		"POST /v1/projects/project/locations/europe-north1/repositories?repositoryId=repository",
+	}, server.requests)
+}
+
+func TestArtifactRegistry_CreatedConcurrently(t *testing.T) {
+	server := newArtifactRegistryServer(t, false)
+	log.SetHandler(mocks.New())
+	server.createStatus = http.StatusConflict
+	err := server.registry(t).Create("image")
+	assert.NoError(t, err)
+}
+
+func TestArtifactRegistry_CreateForbidden(t *testing.T) {
+	server := newArtifactRegistryServer(t, false)
+	log.SetHandler(mocks.New())
+	server.createStatus = http.StatusForbidden
+	err := server.registry(t).Create("image")
+	assert.EqualError(t, err, "unable to create repository repository: unexpected status 403")
+}
+
+func TestArtifactRegistry_CreateFailed(t *testing.T) {
+	server := newArtifactRegistryServer(t, false)
+	log.SetHandler(mocks.New())
+	server.operationError = "quota exceeded"
+	err := server.registry(t).Create("image")
+	assert.EqualError(t, err, "unable to create repository repository: quota exceeded")
+}
+
+func TestArtifactRegistry_Unauthorized(t *testing.T) {
+	server := newArtifactRegistryServer(t, false)
+	registry := &ArtifactRegistry{Url: "europe-north1-docker.pkg.dev/project/repository", AccessToken: "wrong", client: server.Client()}
+	err := registry.Create("image")
+	assert.EqualError(t, err, "unable to check if repository repository exists: unexpected status 401")
+}
+
+func TestArtifactRegistry_InvalidUrl(t *testing.T) {
+	registry := &ArtifactRegistry{Url: "gcr.io/project", AccessToken: "token"}
+	err := registry.Create("image")
+	assert.EqualError(t, err, "invalid Artifact Registry url gcr.io/project, expected <location>-docker.pkg.dev/<project>/<repository>")
+}
+
+func TestArtifactRegistry_InvalidKeyFile(t *testing.T) {
+	registry := &ArtifactRegistry{Url: "europe-north1-docker.pkg.dev/project/repository", KeyFileContent: "a2V5ZmlsZSBjb250ZW50Cg=="}
+	err := registry.Create("image")
+	assert.ErrorContains(t, err, "unable to parse key file: ")
+}
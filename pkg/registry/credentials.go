@@ -0,0 +1,117 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package registry
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/docker/docker/api/types/registry"
+
+	"github.com/buildtool/build-tools/pkg/credentials"
+	"github.com/buildtool/build-tools/pkg/docker"
+)
+
+// withDockerCredentials returns auth if it contains credentials, otherwise the credentials for host
+// stored by docker login or a credential helper, so existing Docker sessions can be used
+func withDockerCredentials(auth registry.AuthConfig, host string) registry.AuthConfig {
+	if docker.HasCredentials(auth) || host == "" {
+		return auth
+	}
+	stored, err := docker.ConfigCredentials(host)
+	if err != nil {
+		log.Debugf("Unable to get Docker credentials for <green>%s</green>: %s\n", host, err)
+		return auth
+	}
+	if !docker.HasCredentials(stored) {
+		return auth
+	}
+	stored.ServerAddress = auth.ServerAddress
+	return stored
+}
+
+// withCredentialProcess returns auth with the username and password printed by command, if configured,
+// instead of the ones in auth, and when they expire
+func withCredentialProcess(auth registry.AuthConfig, command string) (registry.AuthConfig, *time.Time, error) {
+	if command == "" {
+		return auth, nil, nil
+	}
+	creds, err := credentials.Process(command)
+	if err != nil {
+		return registry.AuthConfig{ServerAddress: auth.ServerAddress}, nil, fmt.Errorf("unable to get registry credentials: %w", err)
+	}
+	auth.Username = creds.Username
+	auth.Password = creds.Password
+	auth.RegistryToken = ""
+	return auth, creds.Expiration, nil
+}
+
+// resolveCredentials returns the credentials of the credential process, if configured, otherwise auth, falling back to
+// the credentials stored by docker login or a credential helper for host, and when they expire
+func resolveCredentials(auth registry.AuthConfig, command, host string) (registry.AuthConfig, *time.Time, error) {
+	if command != "" {
+		return withCredentialProcess(auth, command)
+	}
+	return withDockerCredentials(auth, host), nil, nil
+}
+
+// resolvedCredentials are the credentials of a registry and when they expire, if they do
+type resolvedCredentials struct {
+	auth       registry.AuthConfig
+	expiration *time.Time
+	err        error
+	logged     bool
+}
+
+// credentials returns the credentials from resolve, which is only called the first time, typically by Login,
+// and again when the credentials have expired. This avoids reading the Docker config and running credential helpers
+// for every registry API call. A failure to resolve them is returned until they are resolved again
+func (d *dockerRegistry) credentials(resolve func() (registry.AuthConfig, *time.Time, error)) (registry.AuthConfig, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	resolved := d.current(resolve)
+	return resolved.auth, resolved.err
+}
+
+// cachedCredentials is like credentials, for GetAuthConfig which can't return errors. A failure is logged once,
+// and no credentials are returned instead of falling back to other credentials
+func (d *dockerRegistry) cachedCredentials(resolve func() (registry.AuthConfig, *time.Time, error)) registry.AuthConfig {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	resolved := d.current(resolve)
+	if resolved.err != nil && !resolved.logged {
+		resolved.logged = true
+		log.Error(fmt.Sprintf("<red>%s</red>", resolved.err.Error()))
+	}
+	return resolved.auth
+}
+
+// current returns the resolved credentials, resolving them if missing or expired. The caller must hold mu
+func (d *dockerRegistry) current(resolve func() (registry.AuthConfig, *time.Time, error)) *resolvedCredentials {
+	if d.resolved == nil || credentials.Expired(d.resolved.expiration) {
+		auth, expiration, err := resolve()
+		d.resolved = &resolvedCredentials{auth: auth, expiration: expiration, err: err}
+	}
+	return d.resolved
+}
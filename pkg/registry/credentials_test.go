@@ -0,0 +1,310 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	"github.com/buildtool/build-tools/pkg/docker"
+)
+
+// dockerLogin writes a Docker config file with credentials for the hosts, like docker login would
+func dockerLogin(t *testing.T, auths string) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"auths":`+auths+`}`), 0600))
+}
+
+func TestWithDockerCredentials_Configured(t *testing.T) {
+	dockerLogin(t, `{"registry.example.com":{"auth":"c3RvcmVkOnNlY3JldA=="}}`)
+	auth := withDockerCredentials(registry.AuthConfig{Username: "user", Password: "pass", ServerAddress: "registry.example.com"}, "registry.example.com")
+	assert.Equal(t, registry.AuthConfig{Username: "user", Password: "pass", ServerAddress: "registry.example.com"}, auth)
+}
+
+func TestWithDockerCredentials_Stored(t *testing.T) {
+	dockerLogin(t, `{"registry.example.com":{"auth":"c3RvcmVkOnNlY3JldA=="}}`)
+	auth := withDockerCredentials(registry.AuthConfig{ServerAddress: "registry.example.com"}, "registry.example.com")
+	assert.Equal(t, "stored", auth.Username)
+	assert.Equal(t, "secret", auth.Password)
+	assert.Equal(t, "registry.example.com", auth.ServerAddress)
+}
+
+func TestWithDockerCredentials_NoneStored(t *testing.T) {
+	dockerLogin(t, `{}`)
+	auth := withDockerCredentials(registry.AuthConfig{ServerAddress: "registry.example.com"}, "registry.example.com")
+	assert.Equal(t, registry.AuthConfig{ServerAddress: "registry.example.com"}, auth)
+}
+
+func TestWithDockerCredentials_InvalidConfig(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"auths":`), 0600))
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	auth := withDockerCredentials(registry.AuthConfig{ServerAddress: "registry.example.com"}, "registry.example.com")
+	assert.Equal(t, registry.AuthConfig{ServerAddress: "registry.example.com"}, auth)
+	assert.Len(t, logMock.Logged, 1)
+}
+
+func TestDockerhub_StoredCredentials(t *testing.T) {
+	dockerLogin(t, `{"https://index.docker.io/v1/":{"auth":"c3RvcmVkOnNlY3JldA=="}}`)
+	client := &docker.MockDocker{}
+	registry := &Dockerhub{Namespace: "namespace"}
+	log.SetHandler(mocks.New())
+	err := registry.Login(client)
+	assert.NoError(t, err)
+	assert.Equal(t, "stored", client.Username)
+	assert.Equal(t, "secret", client.Password)
+}
+
+func TestGeneric_StoredCredentials(t *testing.T) {
+	dockerLogin(t, `{"localhost:5000":{"auth":"c3RvcmVkOnNlY3JldA=="}}`)
+	client := &docker.MockDocker{}
+	registry := &Generic{Url: "localhost:5000/team"}
+	log.SetHandler(mocks.New())
+	err := registry.Login(client)
+	assert.NoError(t, err)
+	assert.Equal(t, "stored", client.Username)
+	assert.Equal(t, "localhost:5000", client.ServerAddress)
+}
+
+func TestAcr_StoredCredentials(t *testing.T) {
+	dockerLogin(t, `{"myregistry.azurecr.io":{"auth":"c3RvcmVkOnNlY3JldA=="}}`)
+	client := &docker.MockDocker{}
+	registry := &ACR{Url: "myregistry.azurecr.io"}
+	log.SetHandler(mocks.New())
+	err := registry.Login(client)
+	assert.NoError(t, err)
+	assert.Equal(t, "stored", client.Username)
+	assert.Equal(t, "secret", client.Password)
+}
+
+func TestArtifactRegistry_StoredCredentials(t *testing.T) {
+	dockerLogin(t, `{"europe-north1-docker.pkg.dev":{"username":"oauth2accesstoken","password":"access-token"}}`)
+	server := newArtifactRegistryServer(t, true)
+	registry := &ArtifactRegistry{Url: "europe-north1-docker.pkg.dev/project/repository", client: server.Client()}
+	assert.Equal(t, "access-token", registry.GetAuthConfig().Password)
+	assert.NoError(t, registry.Create("image"))
+}
+
+func TestArtifactRegistry_NoCredentials(t *testing.T) {
+	dockerLogin(t, `{}`)
+	noDefaultCredentials(t)
+	registry := &ArtifactRegistry{Url: "europe-north1-docker.pkg.dev/project/repository"}
+	err := registry.Create("image")
+	assert.EqualError(t, err, "no credentials for Artifact Registry, configure a key file or an access token, or use the gcloud credential helper or application default credentials: no default credentials")
+}
+
+func TestArtifactRegistry_GcloudHelperCredentials(t *testing.T) {
+	dockerLogin(t, `{"europe-north1-docker.pkg.dev":{"username":"_dcgcloud_token","password":"access-token"}}`)
+	server := newArtifactRegistryServer(t, true)
+	registry := &ArtifactRegistry{Url: "europe-north1-docker.pkg.dev/project/repository", client: server.Client()}
+	assert.NoError(t, registry.Create("image"))
+}
+
+func TestArtifactRegistry_StoredKeyFile(t *testing.T) {
+	server := newArtifactRegistryServer(t, true)
+	key, err := base64.StdEncoding.DecodeString(server.keyFile(t))
+	assert.NoError(t, err)
+	auth := base64.StdEncoding.EncodeToString(append([]byte("_json_key:"), key...))
+	dockerLogin(t, fmt.Sprintf(`{"europe-north1-docker.pkg.dev":{"auth":%q}}`, auth))
+	registry := &ArtifactRegistry{Url: "europe-north1-docker.pkg.dev/project/repository", client: server.Client()}
+	assert.Equal(t, "_json_key", registry.GetAuthConfig().Username)
+	assert.NoError(t, registry.Create("image"))
+}
+
+func TestArtifactRegistry_DefaultCredentials(t *testing.T) {
+	dockerLogin(t, `{}`)
+	defaultTokenSource = func(context.Context, ...string) (oauth2.TokenSource, error) {
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "access-token"}), nil
+	}
+	t.Cleanup(func() { defaultTokenSource = google.DefaultTokenSource })
+	server := newArtifactRegistryServer(t, true)
+	registry := &ArtifactRegistry{Url: "europe-north1-docker.pkg.dev/project/repository", client: server.Client()}
+	assert.NoError(t, registry.Create("image"))
+}
+
+// noDefaultCredentials makes the lookup of application default credentials fail
+func noDefaultCredentials(t *testing.T) {
+	defaultTokenSource = func(context.Context, ...string) (oauth2.TokenSource, error) {
+		return nil, errors.New("no default credentials")
+	}
+	t.Cleanup(func() { defaultTokenSource = google.DefaultTokenSource })
+}
+
+func TestWithCredentialProcess(t *testing.T) {
+	auth, expiration, err := withCredentialProcess(registry.AuthConfig{Username: "user", Password: "pass", RegistryToken: "token", ServerAddress: "registry.example.com"},
+		`echo '{"Version":1,"Username":"process","Password":"process-secret","Expiration":"2100-01-01T00:00:00Z"}'`)
+	assert.NoError(t, err)
+	assert.Equal(t, registry.AuthConfig{Username: "process", Password: "process-secret", ServerAddress: "registry.example.com"}, auth)
+	assert.Equal(t, time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC), *expiration)
+}
+
+func TestWithCredentialProcess_NotConfigured(t *testing.T) {
+	auth, expiration, err := withCredentialProcess(registry.AuthConfig{Username: "user", Password: "pass"}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, registry.AuthConfig{Username: "user", Password: "pass"}, auth)
+	assert.Nil(t, expiration)
+}
+
+func TestWithCredentialProcess_Failed(t *testing.T) {
+	log.SetHandler(mocks.New())
+	auth, _, err := withCredentialProcess(registry.AuthConfig{Username: "user", Password: "pass", ServerAddress: "registry.example.com"}, `sh -c "exit 1"`)
+	assert.EqualError(t, err, "unable to get registry credentials: credential process 'sh' failed: exit status 1: ")
+	assert.Equal(t, registry.AuthConfig{ServerAddress: "registry.example.com"}, auth)
+}
+
+func TestCredentialProcess_FailureReported(t *testing.T) {
+	dockerLogin(t, `{"harbor.example.com":{"auth":"c3RvcmVkOnNlY3JldA=="}}`)
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	registry := &Harbor{Url: "harbor.example.com", Username: "user", Password: "pass", CredentialProcess: `sh -c "echo vault is sealed >&2; exit 2"`}
+
+	assert.Equal(t, "", registry.GetAuthConfig().Username)
+	assert.Equal(t, "", registry.GetAuthConfig().Password)
+	logMock.Check(t, []string{"error: <red>unable to get registry credentials: credential process 'sh' failed: exit status 2: vault is sealed</red>"})
+
+	err := Authenticate(registry)
+	assert.EqualError(t, err, "unable to get registry credentials: credential process 'sh' failed: exit status 2: vault is sealed")
+}
+
+func TestDockerhub_CredentialProcess(t *testing.T) {
+	dockerLogin(t, `{"https://index.docker.io/v1/":{"auth":"c3RvcmVkOnNlY3JldA=="}}`)
+	client := &docker.MockDocker{}
+	registry := &Dockerhub{Namespace: "namespace", Username: "user", Password: "pass", CredentialProcess: `echo '{"Version":1,"Username":"process","Password":"process-secret"}'`}
+	log.SetHandler(mocks.New())
+	err := registry.Login(client)
+	assert.NoError(t, err)
+	assert.Equal(t, "process", client.Username)
+	assert.Equal(t, "process-secret", client.Password)
+}
+
+func TestGithub_CredentialProcess_Failed(t *testing.T) {
+	client := &docker.MockDocker{}
+	registry := &Github{Repository: "org", CredentialProcess: `sh -c "echo vault is sealed >&2; exit 2"`}
+	log.SetHandler(mocks.New())
+	err := registry.Login(client)
+	assert.EqualError(t, err, "unable to get registry credentials: credential process 'sh' failed: exit status 2: vault is sealed")
+	assert.Empty(t, client.Username)
+}
+
+func TestGCR_CredentialProcess(t *testing.T) {
+	client := &docker.MockDocker{}
+	registry := &GCR{Url: "eu.gcr.io/project", CredentialProcess: `echo '{"Version":1,"Username":"oauth2accesstoken","Password":"gcr-token"}'`}
+	log.SetHandler(mocks.New())
+	assert.True(t, registry.Configured())
+	err := registry.Login(client)
+	assert.NoError(t, err)
+	assert.Equal(t, "oauth2accesstoken", client.Username)
+	assert.Equal(t, "gcr-token", client.Password)
+	assert.Equal(t, "eu.gcr.io/project", client.ServerAddress)
+}
+
+func TestAcr_CredentialProcess(t *testing.T) {
+	dockerLogin(t, `{}`)
+	client := &docker.MockDocker{}
+	registry := &ACR{Url: "myregistry.azurecr.io", CredentialProcess: `echo '{"Version":1,"Username":"scoped-token","Password":"acr-secret"}'`}
+	log.SetHandler(mocks.New())
+	err := registry.Login(client)
+	assert.NoError(t, err)
+	assert.Equal(t, "scoped-token", client.Username)
+	assert.Equal(t, "acr-secret", client.Password)
+}
+
+func TestArtifactRegistry_CredentialProcess(t *testing.T) {
+	dockerLogin(t, `{}`)
+	server := newArtifactRegistryServer(t, true)
+	registry := &ArtifactRegistry{Url: "europe-north1-docker.pkg.dev/project/repository", AccessToken: "static", client: server.Client(),
+		CredentialProcess: `echo '{"Version":1,"Username":"oauth2accesstoken","Password":"access-token"}'`}
+	assert.Equal(t, "access-token", registry.GetAuthConfig().Password)
+	assert.NoError(t, registry.Create("image"))
+}
+
+func TestArtifactRegistry_CredentialProcess_InvalidKey(t *testing.T) {
+	registry := &ArtifactRegistry{Url: "europe-north1-docker.pkg.dev/project/repository",
+		CredentialProcess: `echo '{"Version":1,"Username":"_json_key","Password":"{}"}'`}
+	err := registry.Create("image")
+	assert.ErrorContains(t, err, "unable to parse key file: ")
+}
+
+func TestCachedCredentials(t *testing.T) {
+	dockerLogin(t, `{"quay.io":{"auth":"c3RvcmVkOnNlY3JldA=="}}`)
+	registry := &Quay{Repository: "org"}
+	assert.Equal(t, "stored", registry.GetAuthConfig().Username)
+
+	dockerLogin(t, `{}`)
+	assert.Equal(t, "stored", registry.GetAuthConfig().Username)
+	assert.NotEmpty(t, registry.GetAuthInfo())
+}
+
+func TestCachedCredentials_Expired(t *testing.T) {
+	calls := 0
+	validFor := 30 * time.Second
+	d := &dockerRegistry{}
+	resolve := func() (registry.AuthConfig, *time.Time, error) {
+		calls++
+		expiration := time.Now().Add(validFor)
+		return registry.AuthConfig{Password: fmt.Sprintf("secret-%d", calls)}, &expiration, nil
+	}
+	assert.Equal(t, "secret-1", d.cachedCredentials(resolve).Password)
+	validFor = time.Hour
+	assert.Equal(t, "secret-2", d.cachedCredentials(resolve).Password)
+	assert.Equal(t, "secret-2", d.cachedCredentials(resolve).Password)
+}
+
+func TestCachedCredentials_Concurrent(t *testing.T) {
+	var calls atomic.Int32
+	d := &dockerRegistry{}
+	resolve := func() (registry.AuthConfig, *time.Time, error) {
+		calls.Add(1)
+		return registry.AuthConfig{Password: "secret"}, nil, nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, "secret", d.cachedCredentials(resolve).Password)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), calls.Load())
+}
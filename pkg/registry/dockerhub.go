@@ -26,6 +26,12 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/docker/docker/api/types/registry"
@@ -33,25 +39,40 @@ import (
 	"github.com/buildtool/build-tools/pkg/docker"
 )
 
+// dockerhubApi is the base URL of the Docker Hub API
+var dockerhubApi = "https://hub.docker.com/v2"
+
 type Dockerhub struct {
 	dockerRegistry
-	Namespace string `yaml:"namespace" env:"DOCKERHUB_NAMESPACE"`
-	Username  string `yaml:"username" env:"DOCKERHUB_USERNAME"`
-	Password  string `yaml:"password" env:"DOCKERHUB_PASSWORD"`
+	Namespace         string `yaml:"namespace" env:"DOCKERHUB_NAMESPACE"`
+	Username          string `yaml:"username" env:"DOCKERHUB_USERNAME"`
+	Password          string `yaml:"password" env:"DOCKERHUB_PASSWORD"`
+	CredentialProcess string `yaml:"credentialProcess,omitempty" env:"DOCKERHUB_CREDENTIAL_PROCESS"`
+	// Visibility of created repositories, private or public
+	Visibility string `yaml:"visibility,omitempty"`
+	// Description is the short description of the repository
+	Description string `yaml:"description,omitempty"`
+	// Readme is the path of a markdown file used as the full description of the repository
+	Readme string `yaml:"readme,omitempty"`
+	client *http.Client
 }
 
 var _ Registry = &Dockerhub{}
 
-func (r Dockerhub) Name() string {
+func (r *Dockerhub) Name() string {
 	return "Dockerhub"
 }
 
-func (r Dockerhub) Configured() bool {
+func (r *Dockerhub) Configured() bool {
 	return len(r.Namespace) > 0
 }
 
-func (r Dockerhub) Login(client docker.Client) error {
-	if ok, err := client.RegistryLogin(context.Background(), r.GetAuthConfig()); err == nil {
+func (r *Dockerhub) Login(client docker.Client) error {
+	auth, err := r.credentials(r.resolve)
+	if err != nil {
+		return err
+	}
+	if ok, err := client.RegistryLogin(context.Background(), auth); err == nil {
 		log.Debugf("%s\n", ok.Status)
 		return nil
 	} else {
@@ -60,19 +81,141 @@ func (r Dockerhub) Login(client docker.Client) error {
 	}
 }
 
-func (r Dockerhub) GetAuthConfig() registry.AuthConfig {
-	return registry.AuthConfig{Username: r.Username, Password: r.Password}
+func (r *Dockerhub) GetAuthConfig() registry.AuthConfig {
+	return r.cachedCredentials(r.resolve)
 }
 
-func (r Dockerhub) GetAuthInfo() string {
+func (r *Dockerhub) resolve() (registry.AuthConfig, *time.Time, error) {
+	return resolveCredentials(registry.AuthConfig{Username: r.Username, Password: r.Password}, r.CredentialProcess, "docker.io")
+}
+
+func (r *Dockerhub) GetAuthInfo() string {
 	authBytes, _ := json.Marshal(r.GetAuthConfig())
 	return base64.URLEncoding.EncodeToString(authBytes)
 }
 
-func (r Dockerhub) RegistryUrl() string {
+var _ PathResolver = &Dockerhub{}
+
+// ResolvePaths resolves the path of the readme against dir
+func (r *Dockerhub) ResolvePaths(dir string) {
+	r.Readme = resolvePath(dir, r.Readme)
+}
+
+func (r *Dockerhub) RegistryUrl() string {
 	return r.Namespace
 }
 
+// Create creates the repository using the Docker Hub API if missing, and updates the descriptions
+// of existing repositories. Nothing is done unless visibility, description or readme is configured,
+// leaving repositories to be created by Docker Hub when pushed to.
 func (r *Dockerhub) Create(repository string) error {
+	if r.Visibility == "" && r.Description == "" && r.Readme == "" {
+		return nil
+	}
+	if r.Visibility != "" && r.Visibility != "private" && r.Visibility != "public" {
+		return fmt.Errorf("invalid visibility %s, must be private or public", r.Visibility)
+	}
+	readme := ""
+	if r.Readme != "" {
+		content, err := os.ReadFile(r.Readme)
+		if err != nil {
+			return fmt.Errorf("unable to read readme: %w", err)
+		}
+		readme = string(content)
+	}
+	token, err := r.token()
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("/repositories/%s/%s/", url.PathEscape(r.Namespace), url.PathEscape(repository))
+	var existing dockerhubRepository
+	status, err := r.api(token).request(http.MethodGet, path, nil, &existing)
+	if err != nil {
+		return err
+	}
+	switch status {
+	case http.StatusOK:
+		return r.updateDescriptions(token, path, repository, readme, existing)
+	case http.StatusNotFound:
+	default:
+		return fmt.Errorf("unable to check if repository %s exists: unexpected status %d", repository, status)
+	}
+
+	visibility := r.Visibility
+	if visibility == "" {
+		visibility = "private"
+	}
+	log.Debugf("Creating %s repository '<green>%s/%s</green>' in Docker Hub\n", visibility, r.Namespace, repository)
+	status, err = r.api(token).request(http.MethodPost, "/repositories/", map[string]interface{}{
+		"namespace":        r.Namespace,
+		"name":             repository,
+		"is_private":       visibility == "private",
+		"description":      r.Description,
+		"full_description": readme,
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusCreated {
+		return fmt.Errorf("unable to create repository %s: unexpected status %d", repository, status)
+	}
 	return nil
 }
+
+// dockerhubRepository is the part of a repository in the Docker Hub API kept in sync with the configuration
+type dockerhubRepository struct {
+	Description     string `json:"description"`
+	FullDescription string `json:"full_description"`
+}
+
+// updateDescriptions keeps the descriptions of an existing repository in sync with the configuration,
+// the visibility of existing repositories is left unchanged
+func (r *Dockerhub) updateDescriptions(token, path, repository, readme string, existing dockerhubRepository) error {
+	update := map[string]interface{}{}
+	if r.Description != "" && r.Description != existing.Description {
+		update["description"] = r.Description
+	}
+	if r.Readme != "" && readme != existing.FullDescription {
+		update["full_description"] = readme
+	}
+	if len(update) == 0 {
+		return nil
+	}
+	log.Debugf("Updating description of repository '<green>%s/%s</green>' in Docker Hub\n", r.Namespace, repository)
+	status, err := r.api(token).request(http.MethodPatch, path, update, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("unable to update repository %s: unexpected status %d", repository, status)
+	}
+	return nil
+}
+
+// token logs in to the Docker Hub API, returning a token for the other API calls
+func (r *Dockerhub) token() (string, error) {
+	auth := r.GetAuthConfig()
+	if auth.Username == "" || auth.Password == "" {
+		return "", errors.New("username and password are required to create repositories in Docker Hub")
+	}
+	var response struct {
+		Token string `json:"token"`
+	}
+	status, err := r.api("").request(http.MethodPost, "/users/login", map[string]string{"username": auth.Username, "password": auth.Password}, &response)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("unable to login to Docker Hub API: unexpected status %d", status)
+	}
+	return response.Token, nil
+}
+
+// api returns a client for the Docker Hub API, authenticated with the token if any
+func (r *Dockerhub) api(token string) apiClient {
+	client := apiClient{client: r.client, baseUrl: dockerhubApi}
+	if token != "" {
+		client.auth = bearer(token)
+	}
+	return client
+}
@@ -24,6 +24,9 @@ package registry
 
 import (
 	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/apex/log"
@@ -69,3 +72,161 @@ func TestDockerhub_Create(t *testing.T) {
 	err := registry.Create("repo")
 	assert.Nil(t, err)
 }
+
+func newDockerhubServer(t *testing.T, exists bool) *apiServer {
+	s := newApiServer(t, exists)
+	s.routes["/v2/users/login"] = func(w http.ResponseWriter, r *http.Request) {
+		if s.bodies["POST /v2/users/login"]["password"] != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"token":"jwt"}`)
+	}
+	s.authorized = func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Bearer jwt"
+	}
+	s.respond = func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"namespace":"namespace","name":"repo"}`)
+	}
+	s.Start()
+	previous := dockerhubApi
+	dockerhubApi = s.URL + "/v2"
+	t.Cleanup(func() { dockerhubApi = previous })
+	return s
+}
+
+func (s *apiServer) dockerhub() *Dockerhub {
+	return &Dockerhub{Namespace: "namespace", Username: "user", Password: "pass", client: s.Client()}
+}
+
+func TestDockerhub_ResolvePaths(t *testing.T) {
+	registry := &Dockerhub{Readme: "docs/README.md"}
+	registry.ResolvePaths("/project")
+	assert.Equal(t, filepath.Join("/project", "docs", "README.md"), registry.Readme)
+
+	registry = &Dockerhub{Readme: "/etc/README.md"}
+	registry.ResolvePaths("/project")
+	assert.Equal(t, "/etc/README.md", registry.Readme)
+}
+
+func TestDockerhub_CreateNotConfigured(t *testing.T) {
+	server := newDockerhubServer(t, false)
+	err := server.dockerhub().Create("repo")
+	assert.NoError(t, err)
+	assert.Empty(t, server.requests)
+}
+
+func TestDockerhub_CreateNew(t *testing.T) {
+	server := newDockerhubServer(t, false)
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	readme := filepath.Join(t.TempDir(), "README.md")
+	assert.NoError(t, os.WriteFile(readme, []byte("# Repo\n"), 0644))
+	registry := server.dockerhub()
+	registry.Description = "The repo"
+	registry.Readme = readme
+	err := registry.Create("repo")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"POST /v2/users/login", "GET /v2/repositories/namespace/repo/", "POST /v2/repositories/"}, server.requests)
+	assert.Equal(t, map[string]interface{}{
+		"namespace":        "namespace",
+		"name":             "repo",
+		"is_private":       true,
+		"description":      "The repo",
+		"full_description": "# Repo\n",
+	}, server.bodies["POST /v2/repositories/"])
+	logMock.Check(t, []string{"debug: Creating private repository '<green>namespace/repo</green>' in Docker Hub\n"})
+}
+
+func TestDockerhub_CreatePublic(t *testing.T) {
+	server := newDockerhubServer(t, false)
+	log.SetHandler(mocks.New())
+	registry := server.dockerhub()
+	registry.Visibility = "public"
+	err := registry.Create("repo")
+	assert.NoError(t, err)
+	assert.Equal(t, false, server.bodies["POST /v2/repositories/"]["is_private"])
+}
+
+func TestDockerhub_CreateExisting(t *testing.T) {
+	server := newDockerhubServer(t, true)
+	registry := server.dockerhub()
+	registry.Visibility = "private"
+	err := registry.Create("repo")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"POST /v2/users/login", "GET /v2/repositories/namespace/repo/"}, server.requests)
+}
+
+func TestDockerhub_CreateExistingSyncsReadme(t *testing.T) {
+	server := newDockerhubServer(t, true)
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	readme := filepath.Join(t.TempDir(), "README.md")
+	assert.NoError(t, os.WriteFile(readme, []byte("# Updated\n"), 0644))
+	registry := server.dockerhub()
+	registry.Readme = readme
+	err := registry.Create("repo")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"POST /v2/users/login", "GET /v2/repositories/namespace/repo/", "PATCH /v2/repositories/namespace/repo/"}, server.requests)
+	assert.Equal(t, map[string]interface{}{"full_description": "# Updated\n"}, server.bodies["PATCH /v2/repositories/namespace/repo/"])
+	logMock.Check(t, []string{"debug: Updating description of repository '<green>namespace/repo</green>' in Docker Hub\n"})
+}
+
+func TestDockerhub_CreateExistingUnchanged(t *testing.T) {
+	server := newDockerhubServer(t, true)
+	server.respond = func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"namespace":"namespace","name":"repo","description":"The repo","full_description":"# Repo\n"}`)
+	}
+	readme := filepath.Join(t.TempDir(), "README.md")
+	assert.NoError(t, os.WriteFile(readme, []byte("# Repo\n"), 0644))
+	registry := server.dockerhub()
+	registry.Description = "The repo"
+	registry.Readme = readme
+	err := registry.Create("repo")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"POST /v2/users/login", "GET /v2/repositories/namespace/repo/"}, server.requests)
+}
+
+func TestDockerhub_CreateMissingReadme(t *testing.T) {
+	registry := &Dockerhub{Namespace: "namespace", Readme: "missing.md"}
+	err := registry.Create("repo")
+	assert.EqualError(t, err, "unable to read readme: open missing.md: no such file or directory")
+}
+
+func TestDockerhub_CreateInvalidVisibility(t *testing.T) {
+	registry := &Dockerhub{Namespace: "namespace", Visibility: "internal"}
+	err := registry.Create("repo")
+	assert.EqualError(t, err, "invalid visibility internal, must be private or public")
+}
+
+func TestDockerhub_CreateWithoutCredentials(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	registry := &Dockerhub{Namespace: "namespace", Visibility: "private"}
+	err := registry.Create("repo")
+	assert.EqualError(t, err, "username and password are required to create repositories in Docker Hub")
+}
+
+func TestDockerhub_CreateLoginFailed(t *testing.T) {
+	server := newDockerhubServer(t, false)
+	registry := server.dockerhub()
+	registry.Password = "wrong"
+	registry.Visibility = "private"
+	err := registry.Create("repo")
+	assert.EqualError(t, err, "unable to login to Docker Hub API: unexpected status 401")
+}
+
+func TestDockerhub_CreateForbidden(t *testing.T) {
+	server := newDockerhubServer(t, false)
+	log.SetHandler(mocks.New())
+	server.createStatus = http.StatusForbidden
+	registry := server.dockerhub()
+	registry.Visibility = "private"
+	err := registry.Create("repo")
+	assert.EqualError(t, err, "unable to create repository repo: unexpected status 403")
+}
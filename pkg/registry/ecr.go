@@ -28,12 +28,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"os"
 	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/apex/log"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/ecr"
 	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
@@ -47,6 +51,10 @@ type ECRClient interface {
 	DescribeRepositories(ctx context.Context, params *ecr.DescribeRepositoriesInput, optFns ...func(*ecr.Options)) (*ecr.DescribeRepositoriesOutput, error)
 	CreateRepository(ctx context.Context, params *ecr.CreateRepositoryInput, optFns ...func(*ecr.Options)) (*ecr.CreateRepositoryOutput, error)
 	PutLifecyclePolicy(ctx context.Context, params *ecr.PutLifecyclePolicyInput, optFns ...func(*ecr.Options)) (*ecr.PutLifecyclePolicyOutput, error)
+	SetRepositoryPolicy(ctx context.Context, params *ecr.SetRepositoryPolicyInput, optFns ...func(*ecr.Options)) (*ecr.SetRepositoryPolicyOutput, error)
+	PutImageTagMutability(ctx context.Context, params *ecr.PutImageTagMutabilityInput, optFns ...func(*ecr.Options)) (*ecr.PutImageTagMutabilityOutput, error)
+	PutImageScanningConfiguration(ctx context.Context, params *ecr.PutImageScanningConfigurationInput, optFns ...func(*ecr.Options)) (*ecr.PutImageScanningConfigurationOutput, error)
+	TagResource(ctx context.Context, params *ecr.TagResourceInput, optFns ...func(*ecr.Options)) (*ecr.TagResourceOutput, error)
 }
 
 type STSClient interface {
@@ -55,8 +63,12 @@ type STSClient interface {
 
 type ECR struct {
 	dockerRegistry `yaml:"-"`
-	Url            string `yaml:"url" env:"ECR_URL"`
-	Region         string `yaml:"region,omitempty" env:"ECR_REGION"`
+	Url            string         `yaml:"url" env:"ECR_URL"`
+	Region         string         `yaml:"region,omitempty" env:"ECR_REGION"`
+	RoleArn        string         `yaml:"roleArn,omitempty" env:"ECR_ROLE_ARN"`
+	ExternalId     string         `yaml:"externalId,omitempty" env:"ECR_EXTERNAL_ID"`
+	SessionName    string         `yaml:"sessionName,omitempty" env:"ECR_SESSION_NAME"`
+	Repository     *ECRRepository `yaml:"repository,omitempty"`
 	username       string
 	password       string
 	ecrSvc         ECRClient
@@ -64,6 +76,23 @@ type ECR struct {
 	registryId     *string
 }
 
+// ECRRepository configures the repositories created in ECR
+type ECRRepository struct {
+	// LifecyclePolicy is a lifecycle policy as JSON or the path to a file containing it
+	LifecyclePolicy string `yaml:"lifecyclePolicy,omitempty"`
+	// Policy is a repository policy as JSON or the path to a file containing it
+	Policy        string            `yaml:"policy,omitempty"`
+	ImmutableTags *bool             `yaml:"immutableTags,omitempty"`
+	ScanOnPush    *bool             `yaml:"scanOnPush,omitempty"`
+	KMSKey        string            `yaml:"kmsKey,omitempty"`
+	Tags          map[string]string `yaml:"tags,omitempty"`
+	// Reconcile applies the configured settings to existing repositories as well, settings which
+	// are not configured are left as they are
+	Reconcile bool `yaml:"reconcile,omitempty"`
+}
+
+const defaultLifecyclePolicy = `{"rules":[{"rulePriority":10,"description":"Only keep 20 images","selection":{"tagStatus":"untagged","countType":"imageCountMoreThan","countNumber":20},"action":{"type":"expire"}}]}`
+
 var _ Registry = &ECR{}
 
 func (r *ECR) Name() string {
@@ -72,7 +101,7 @@ func (r *ECR) Name() string {
 
 func (r *ECR) Configured() bool {
 	if len(r.Url) > 0 {
-		sess, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(r.Region))
+		sess, err := r.awsConfig()
 		if err != nil {
 			return false
 		}
@@ -88,6 +117,25 @@ func (r *ECR) Configured() bool {
 	return false
 }
 
+// awsConfig loads the default AWS configuration, using credentials from assuming RoleArn if set
+func (r *ECR) awsConfig() (aws.Config, error) {
+	sess, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(r.Region))
+	if err != nil || r.RoleArn == "" {
+		return sess, err
+	}
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(sess), r.RoleArn, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = r.SessionName
+		if o.RoleSessionName == "" {
+			o.RoleSessionName = "buildtools"
+		}
+		if r.ExternalId != "" {
+			o.ExternalID = aws.String(r.ExternalId)
+		}
+	})
+	sess.Credentials = aws.NewCredentialsCache(provider)
+	return sess, nil
+}
+
 func (r *ECR) region() *string {
 	if r.Region == "" {
 		regex := regexp.MustCompile(`.*\.dkr\.ecr.(.*)\.amazonaws\.com`)
@@ -107,6 +155,19 @@ func (r *ECR) registry() (*string, error) {
 }
 
 func (r *ECR) Login(client docker.Client) error {
+	if err := r.fetchCredentials(); err != nil {
+		return err
+	}
+
+	if ok, err := client.RegistryLogin(context.Background(), registry.AuthConfig{Username: r.username, Password: r.password, ServerAddress: r.Url}); err == nil {
+		log.Debugf("%s\n", ok.Status)
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (r *ECR) fetchCredentials() error {
 	input := &ecr.GetAuthorizationTokenInput{}
 
 	result, err := r.ecrSvc.GetAuthorizationToken(context.Background(), input)
@@ -121,13 +182,7 @@ func (r *ECR) Login(client docker.Client) error {
 	parts := strings.Split(string(decoded), ":")
 	r.username = parts[0]
 	r.password = parts[1]
-
-	if ok, err := client.RegistryLogin(context.Background(), registry.AuthConfig{Username: r.username, Password: r.password, ServerAddress: r.Url}); err == nil {
-		log.Debugf("%s\n", ok.Status)
-		return nil
-	} else {
-		return err
-	}
+	return nil
 }
 
 func (r *ECR) GetAuthConfig() registry.AuthConfig {
@@ -139,39 +194,149 @@ func (r *ECR) GetAuthInfo() string {
 	return base64.URLEncoding.EncodeToString(authBytes)
 }
 
-func (r ECR) RegistryUrl() string {
+func (r *ECR) RegistryUrl() string {
 	return r.Url
 }
 
-func (r ECR) Create(repository string) error {
+func (r *ECR) Create(repository string) error {
 	identity, err := r.stsSvc.GetCallerIdentity(context.Background(), &sts.GetCallerIdentityInput{})
 	if err != nil {
 		return err
 	}
 	if *identity.Account != *r.registryId {
-		return fmt.Errorf("account mismatch, logged in at '%s' got '%s' from repository url %s", *identity.Account, *r.registryId, r.Url)
+		if r.RoleArn == "" {
+			return fmt.Errorf("account mismatch, logged in at '%s' got '%s' from repository url %s", *identity.Account, *r.registryId, r.Url)
+		}
+		// access from the account of the assumed role is granted by the policies of the repositories
+		log.Debugf("Assumed role in account <green>%s</green>, using registry in account <green>%s</green>\n", *identity.Account, *r.registryId)
+	}
+	settings := r.Repository
+	if settings == nil {
+		settings = &ECRRepository{}
 	}
-	if _, err := r.ecrSvc.DescribeRepositories(context.Background(), &ecr.DescribeRepositoriesInput{
+	lifecyclePolicy, err := policyDocument(settings.LifecyclePolicy)
+	if err != nil {
+		return err
+	}
+	policy, err := policyDocument(settings.Policy)
+	if err != nil {
+		return err
+	}
+	existing, err := r.ecrSvc.DescribeRepositories(context.Background(), &ecr.DescribeRepositoriesInput{
 		RegistryId:      r.registryId,
 		RepositoryNames: []string{repository},
-	}); err != nil {
+	})
+	if err != nil {
 		var aerr *types.RepositoryNotFoundException
 		if !errors.As(err, &aerr) {
 			return err
 		}
 		input := &ecr.CreateRepositoryInput{
-			RepositoryName: aws.String(repository),
+			RegistryId:                 r.registryId,
+			RepositoryName:             aws.String(repository),
+			ImageTagMutability:         settings.tagMutability(),
+			ImageScanningConfiguration: &types.ImageScanningConfiguration{ScanOnPush: aws.ToBool(settings.ScanOnPush)},
+			Tags:                       settings.tags(),
+		}
+		if settings.KMSKey != "" {
+			input.EncryptionConfiguration = &types.EncryptionConfiguration{EncryptionType: types.EncryptionTypeKms, KmsKey: aws.String(settings.KMSKey)}
 		}
 
 		if _, err := r.ecrSvc.CreateRepository(context.Background(), input); err != nil {
 			return err
-		} else {
-			policyText := `{"rules":[{"rulePriority":10,"description":"Only keep 20 images","selection":{"tagStatus":"untagged","countType":"imageCountMoreThan","countNumber":20},"action":{"type":"expire"}}]}`
-			if _, err := r.ecrSvc.PutLifecyclePolicy(context.Background(), &ecr.PutLifecyclePolicyInput{LifecyclePolicyText: &policyText, RepositoryName: &repository}); err != nil {
-				return err
-			}
-			return nil
 		}
+		if lifecyclePolicy == "" {
+			lifecyclePolicy = defaultLifecyclePolicy
+		}
+		if _, err := r.ecrSvc.PutLifecyclePolicy(context.Background(), &ecr.PutLifecyclePolicyInput{RegistryId: r.registryId, LifecyclePolicyText: &lifecyclePolicy, RepositoryName: &repository}); err != nil {
+			return err
+		}
+		return r.setPolicy(repository, policy)
 	}
-	return nil
+	if !settings.Reconcile {
+		return nil
+	}
+	log.Debugf("Reconciling settings of repository <green>%s</green>\n", repository)
+	if settings.ImmutableTags != nil {
+		if _, err := r.ecrSvc.PutImageTagMutability(context.Background(), &ecr.PutImageTagMutabilityInput{
+			RegistryId:         r.registryId,
+			RepositoryName:     &repository,
+			ImageTagMutability: settings.tagMutability(),
+		}); err != nil {
+			return err
+		}
+	}
+	if settings.ScanOnPush != nil {
+		if _, err := r.ecrSvc.PutImageScanningConfiguration(context.Background(), &ecr.PutImageScanningConfigurationInput{
+			RegistryId:                 r.registryId,
+			RepositoryName:             &repository,
+			ImageScanningConfiguration: &types.ImageScanningConfiguration{ScanOnPush: *settings.ScanOnPush},
+		}); err != nil {
+			return err
+		}
+	}
+	if lifecyclePolicy != "" {
+		if _, err := r.ecrSvc.PutLifecyclePolicy(context.Background(), &ecr.PutLifecyclePolicyInput{RegistryId: r.registryId, LifecyclePolicyText: &lifecyclePolicy, RepositoryName: &repository}); err != nil {
+			return err
+		}
+	}
+	if tags := settings.tags(); len(tags) > 0 && len(existing.Repositories) > 0 {
+		if _, err := r.ecrSvc.TagResource(context.Background(), &ecr.TagResourceInput{ResourceArn: existing.Repositories[0].RepositoryArn, Tags: tags}); err != nil {
+			return err
+		}
+	}
+	if settings.KMSKey != "" {
+		log.Debugf("Encryption of existing repository <green>%s</green> can't be changed\n", repository)
+	}
+	return r.setPolicy(repository, policy)
+}
+
+func (r *ECR) setPolicy(repository, policy string) error {
+	if policy == "" {
+		return nil
+	}
+	_, err := r.ecrSvc.SetRepositoryPolicy(context.Background(), &ecr.SetRepositoryPolicyInput{
+		RegistryId:     r.registryId,
+		RepositoryName: &repository,
+		PolicyText:     &policy,
+	})
+	return err
+}
+
+var _ PathResolver = &ECR{}
+
+// ResolvePaths resolves the paths of policy files against dir
+func (r *ECR) ResolvePaths(dir string) {
+	if r.Repository == nil {
+		return
+	}
+	r.Repository.LifecyclePolicy = resolvePath(dir, r.Repository.LifecyclePolicy)
+	r.Repository.Policy = resolvePath(dir, r.Repository.Policy)
+}
+
+func (s *ECRRepository) tagMutability() types.ImageTagMutability {
+	if aws.ToBool(s.ImmutableTags) {
+		return types.ImageTagMutabilityImmutable
+	}
+	return types.ImageTagMutabilityMutable
+}
+
+func (s *ECRRepository) tags() []types.Tag {
+	var tags []types.Tag
+	for _, key := range slices.Sorted(maps.Keys(s.Tags)) {
+		tags = append(tags, types.Tag{Key: aws.String(key), Value: aws.String(s.Tags[key])})
+	}
+	return tags
+}
+
+// policyDocument returns the policy, which is either JSON or the path to a file containing JSON
+func policyDocument(policy string) (string, error) {
+	if policy == "" || strings.HasPrefix(strings.TrimSpace(policy), "{") {
+		return policy, nil
+	}
+	content, err := os.ReadFile(policy)
+	if err != nil {
+		return "", fmt.Errorf("unable to read policy: %w", err)
+	}
+	return string(content), nil
 }
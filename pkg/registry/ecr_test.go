@@ -25,10 +25,13 @@ package registry
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/apex/log"
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/ecr"
 	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
@@ -105,6 +108,43 @@ func TestEcr_RegistryAndClientInDifferentAccounts(t *testing.T) {
 	assert.EqualError(t, err, "account mismatch, logged in at '1234' got 'repo' from repository url ")
 }
 
+func TestEcr_AssumedRoleInDifferentAccount(t *testing.T) {
+	registryId := "5678"
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	mockECR := &MockECR{repoExists: true}
+	mockSTS := &MockSTS{userAccountId: aws.String("1234")}
+	registry := &ECR{ecrSvc: mockECR, stsSvc: mockSTS, registryId: &registryId, RoleArn: "arn:aws:iam::1234:role/push"}
+	err := registry.Create("repo")
+	assert.NoError(t, err)
+	logMock.Check(t, []string{"debug: Assumed role in account <green>1234</green>, using registry in account <green>5678</green>\n"})
+}
+
+func TestEcr_AwsConfigAssumesRole(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	registry := &ECR{Url: "5678.dkr.ecr.eu-west-1.amazonaws.com", Region: "eu-west-1", RoleArn: "arn:aws:iam::5678:role/push", ExternalId: "id"}
+	cfg, err := registry.awsConfig()
+	assert.NoError(t, err)
+	cache, ok := cfg.Credentials.(*aws.CredentialsCache)
+	if assert.True(t, ok) {
+		assert.True(t, cache.IsCredentialsProvider(&stscreds.AssumeRoleProvider{}))
+	}
+}
+
+func TestEcr_AwsConfigWithoutRole(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	registry := &ECR{Url: "5678.dkr.ecr.eu-west-1.amazonaws.com", Region: "eu-west-1"}
+	cfg, err := registry.awsConfig()
+	assert.NoError(t, err)
+	cache, ok := cfg.Credentials.(*aws.CredentialsCache)
+	if assert.True(t, ok) {
+		assert.False(t, cache.IsCredentialsProvider(&stscreds.AssumeRoleProvider{}))
+	}
+}
+
 func TestEcr_RepositoryAccessNotAllowed(t *testing.T) {
 	registryId := "1234"
 	mockECR := &MockECR{repoExists: true, repoAccessNotAllowed: true}
@@ -125,6 +165,47 @@ func TestEcr_ExistingRepository(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, []string{repo}, mockECR.describeRepositoriesInput.RepositoryNames)
 	assert.Equal(t, &registryId, mockECR.describeRepositoriesInput.RegistryId)
+	assert.Nil(t, mockECR.putLifecyclePolicyInput)
+	assert.Nil(t, mockECR.tagMutabilityInput)
+}
+
+func TestEcr_ExistingRepositoryReconcile(t *testing.T) {
+	registryId := "1234"
+	mockECR := &MockECR{repoExists: true}
+	mockSTS := &MockSTS{userAccountId: &registryId}
+	settings := &ECRRepository{
+		LifecyclePolicy: `{"rules":[]}`,
+		Policy:          `{"Statement":[]}`,
+		ImmutableTags:   aws.Bool(true),
+		ScanOnPush:      aws.Bool(true),
+		KMSKey:          "key",
+		Tags:            map[string]string{"team": "a"},
+		Reconcile:       true,
+	}
+	registry := &ECR{ecrSvc: mockECR, stsSvc: mockSTS, registryId: &registryId, Repository: settings}
+	err := registry.Create("repo")
+	assert.NoError(t, err)
+	assert.Nil(t, mockECR.createRepositoryInput)
+	assert.Equal(t, types.ImageTagMutabilityImmutable, mockECR.tagMutabilityInput.ImageTagMutability)
+	assert.True(t, mockECR.scanningInput.ImageScanningConfiguration.ScanOnPush)
+	assert.Equal(t, `{"rules":[]}`, *mockECR.putLifecyclePolicyInput.LifecyclePolicyText)
+	assert.Equal(t, `{"Statement":[]}`, *mockECR.setRepositoryPolicyInput.PolicyText)
+	assert.Equal(t, "arn:aws:ecr:eu-west-1:1234:repository/repo", *mockECR.tagResourceInput.ResourceArn)
+	assert.Equal(t, []types.Tag{{Key: aws.String("team"), Value: aws.String("a")}}, mockECR.tagResourceInput.Tags)
+}
+
+func TestEcr_ExistingRepositoryReconcileConfiguredOnly(t *testing.T) {
+	registryId := "1234"
+	mockECR := &MockECR{repoExists: true}
+	mockSTS := &MockSTS{userAccountId: &registryId}
+	registry := &ECR{ecrSvc: mockECR, stsSvc: mockSTS, registryId: &registryId, Repository: &ECRRepository{ScanOnPush: aws.Bool(false), Reconcile: true}}
+	err := registry.Create("repo")
+	assert.NoError(t, err)
+	assert.False(t, mockECR.scanningInput.ImageScanningConfiguration.ScanOnPush)
+	assert.Nil(t, mockECR.tagMutabilityInput)
+	assert.Nil(t, mockECR.putLifecyclePolicyInput)
+	assert.Nil(t, mockECR.setRepositoryPolicyInput)
+	assert.Nil(t, mockECR.tagResourceInput)
 }
 
 func TestEcr_NewRepositoryCreateError(t *testing.T) {
@@ -157,6 +238,66 @@ func TestEcr_NewRepository(t *testing.T) {
 	assert.Equal(t, &policyText, mockECR.putLifecyclePolicyInput.LifecyclePolicyText)
 }
 
+func TestEcr_NewRepositoryWithSettings(t *testing.T) {
+	registryId := "1234"
+	mockSTS := &MockSTS{userAccountId: &registryId}
+	mockECR := &MockECR{}
+	dir := t.TempDir()
+	lifecyclePolicy := `{"rules":[{"rulePriority":1,"selection":{"tagStatus":"any","countType":"imageCountMoreThan","countNumber":100},"action":{"type":"expire"}}]}`
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "lifecycle.json"), []byte(lifecyclePolicy), 0666))
+	settings := &ECRRepository{
+		LifecyclePolicy: filepath.Join(dir, "lifecycle.json"),
+		Policy:          ` {"Version":"2012-10-17","Statement":[]}`,
+		ImmutableTags:   aws.Bool(true),
+		ScanOnPush:      aws.Bool(true),
+		KMSKey:          "arn:aws:kms:eu-west-1:1234:key/abc",
+		Tags:            map[string]string{"team": "a", "cost-center": "b"},
+	}
+	registry := &ECR{ecrSvc: mockECR, stsSvc: mockSTS, registryId: &registryId, Repository: settings}
+	err := registry.Create("repo")
+	assert.NoError(t, err)
+	input := mockECR.createRepositoryInput
+	assert.Equal(t, types.ImageTagMutabilityImmutable, input.ImageTagMutability)
+	assert.True(t, input.ImageScanningConfiguration.ScanOnPush)
+	assert.Equal(t, &types.EncryptionConfiguration{EncryptionType: types.EncryptionTypeKms, KmsKey: aws.String("arn:aws:kms:eu-west-1:1234:key/abc")}, input.EncryptionConfiguration)
+	assert.Equal(t, []types.Tag{{Key: aws.String("cost-center"), Value: aws.String("b")}, {Key: aws.String("team"), Value: aws.String("a")}}, input.Tags)
+	assert.Equal(t, lifecyclePolicy, *mockECR.putLifecyclePolicyInput.LifecyclePolicyText)
+	assert.Equal(t, ` {"Version":"2012-10-17","Statement":[]}`, *mockECR.setRepositoryPolicyInput.PolicyText)
+	assert.Equal(t, &registryId, mockECR.setRepositoryPolicyInput.RegistryId)
+}
+
+func TestEcr_NewRepositoryPolicyError(t *testing.T) {
+	registryId := "1234"
+	mockSTS := &MockSTS{userAccountId: &registryId}
+	mockECR := &MockECR{policyError: fmt.Errorf("invalid policy")}
+	registry := &ECR{ecrSvc: mockECR, stsSvc: mockSTS, registryId: &registryId, Repository: &ECRRepository{Policy: "{}"}}
+	err := registry.Create("repo")
+	assert.EqualError(t, err, "invalid policy")
+}
+
+func TestEcr_MissingPolicyFile(t *testing.T) {
+	registryId := "1234"
+	mockSTS := &MockSTS{userAccountId: &registryId}
+	mockECR := &MockECR{}
+	registry := &ECR{ecrSvc: mockECR, stsSvc: mockSTS, registryId: &registryId, Repository: &ECRRepository{LifecyclePolicy: "missing.json"}}
+	err := registry.Create("repo")
+	assert.EqualError(t, err, "unable to read policy: open missing.json: no such file or directory")
+	assert.Nil(t, mockECR.createRepositoryInput)
+}
+
+func TestEcr_ResolvePaths(t *testing.T) {
+	registry := &ECR{Repository: &ECRRepository{LifecyclePolicy: "ecr/lifecycle.json", Policy: `{"Statement":[]}`}}
+	registry.ResolvePaths("/project")
+	assert.Equal(t, filepath.Join("/project", "ecr", "lifecycle.json"), registry.Repository.LifecyclePolicy)
+	assert.Equal(t, `{"Statement":[]}`, registry.Repository.Policy)
+
+	registry = &ECR{Repository: &ECRRepository{Policy: "/etc/ecr/policy.json"}}
+	registry.ResolvePaths("/project")
+	assert.Equal(t, "/etc/ecr/policy.json", registry.Repository.Policy)
+
+	(&ECR{}).ResolvePaths("/project")
+}
+
 func TestEcr_ParseECRUrlIfNoRegionIsSet(t *testing.T) {
 	ecr := ECR{
 		Url: "12345678.dkr.ecr.eu-west-1.amazonaws.com",
@@ -205,6 +346,11 @@ type MockECR struct {
 	putLifecyclePolicyInput   *ecr.PutLifecyclePolicyInput
 	putError                  error
 	repoAccessNotAllowed      bool
+	setRepositoryPolicyInput  *ecr.SetRepositoryPolicyInput
+	policyError               error
+	tagMutabilityInput        *ecr.PutImageTagMutabilityInput
+	scanningInput             *ecr.PutImageScanningConfigurationInput
+	tagResourceInput          *ecr.TagResourceInput
 }
 
 func (r *MockECR) GetAuthorizationToken(_ context.Context, input *ecr.GetAuthorizationTokenInput, optFns ...func(*ecr.Options)) (*ecr.GetAuthorizationTokenOutput, error) {
@@ -220,7 +366,7 @@ func (r *MockECR) DescribeRepositories(_ context.Context, input *ecr.DescribeRep
 		return &ecr.DescribeRepositoriesOutput{Repositories: []types.Repository{}}, fmt.Errorf("not allowed")
 	}
 	if r.repoExists {
-		return &ecr.DescribeRepositoriesOutput{Repositories: []types.Repository{}}, nil
+		return &ecr.DescribeRepositoriesOutput{Repositories: []types.Repository{{RepositoryArn: aws.String("arn:aws:ecr:eu-west-1:1234:repository/repo")}}}, nil
 	}
 	return &ecr.DescribeRepositoriesOutput{Repositories: []types.Repository{}},
 		&types.RepositoryNotFoundException{}
@@ -236,6 +382,26 @@ func (r *MockECR) PutLifecyclePolicy(_ context.Context, input *ecr.PutLifecycleP
 	return &ecr.PutLifecyclePolicyOutput{}, r.putError
 }
 
+func (r *MockECR) SetRepositoryPolicy(_ context.Context, input *ecr.SetRepositoryPolicyInput, optFns ...func(*ecr.Options)) (*ecr.SetRepositoryPolicyOutput, error) {
+	r.setRepositoryPolicyInput = input
+	return &ecr.SetRepositoryPolicyOutput{}, r.policyError
+}
+
+func (r *MockECR) PutImageTagMutability(_ context.Context, input *ecr.PutImageTagMutabilityInput, optFns ...func(*ecr.Options)) (*ecr.PutImageTagMutabilityOutput, error) {
+	r.tagMutabilityInput = input
+	return &ecr.PutImageTagMutabilityOutput{}, nil
+}
+
+func (r *MockECR) PutImageScanningConfiguration(_ context.Context, input *ecr.PutImageScanningConfigurationInput, optFns ...func(*ecr.Options)) (*ecr.PutImageScanningConfigurationOutput, error) {
+	r.scanningInput = input
+	return &ecr.PutImageScanningConfigurationOutput{}, nil
+}
+
+func (r *MockECR) TagResource(_ context.Context, input *ecr.TagResourceInput, optFns ...func(*ecr.Options)) (*ecr.TagResourceOutput, error) {
+	r.tagResourceInput = input
+	return &ecr.TagResourceOutput{}, nil
+}
+
 func (r *MockSTS) GetCallerIdentity(context.Context, *sts.GetCallerIdentityInput, ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
 	if r.userAccountId != nil {
 		return &sts.GetCallerIdentityOutput{Account: r.userAccountId}, nil
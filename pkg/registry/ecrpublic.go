@@ -0,0 +1,182 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ecrpublic"
+	"github.com/aws/aws-sdk-go-v2/service/ecrpublic/types"
+	"github.com/docker/docker/api/types/registry"
+
+	"github.com/buildtool/build-tools/pkg/docker"
+)
+
+// ecrPublicRegion is the only region where the ECR Public API is available
+const ecrPublicRegion = "us-east-1"
+
+const ecrPublicHost = "public.ecr.aws"
+
+type ECRPublicClient interface {
+	GetAuthorizationToken(ctx context.Context, params *ecrpublic.GetAuthorizationTokenInput, optFns ...func(*ecrpublic.Options)) (*ecrpublic.GetAuthorizationTokenOutput, error)
+	DescribeRepositories(ctx context.Context, params *ecrpublic.DescribeRepositoriesInput, optFns ...func(*ecrpublic.Options)) (*ecrpublic.DescribeRepositoriesOutput, error)
+	CreateRepository(ctx context.Context, params *ecrpublic.CreateRepositoryInput, optFns ...func(*ecrpublic.Options)) (*ecrpublic.CreateRepositoryOutput, error)
+	PutRepositoryCatalogData(ctx context.Context, params *ecrpublic.PutRepositoryCatalogDataInput, optFns ...func(*ecrpublic.Options)) (*ecrpublic.PutRepositoryCatalogDataOutput, error)
+}
+
+type ECRPublic struct {
+	dockerRegistry `yaml:"-"`
+	// Alias is the registry alias, images are pushed to public.ecr.aws/<alias>
+	Alias string `yaml:"alias" env:"ECR_PUBLIC_ALIAS"`
+	// Description, About and Usage are shown in the Amazon ECR Public Gallery
+	Description      string   `yaml:"description,omitempty"`
+	About            string   `yaml:"about,omitempty"`
+	Usage            string   `yaml:"usage,omitempty"`
+	Architectures    []string `yaml:"architectures,omitempty"`
+	OperatingSystems []string `yaml:"operatingSystems,omitempty"`
+	username         string
+	password         string
+	svc              ECRPublicClient
+}
+
+var _ Registry = &ECRPublic{}
+
+func (r *ECRPublic) Name() string {
+	return "ECR Public"
+}
+
+func (r *ECRPublic) Configured() bool {
+	if len(r.Alias) > 0 {
+		sess, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(ecrPublicRegion))
+		if err != nil {
+			return false
+		}
+		r.svc = ecrpublic.NewFromConfig(sess)
+		return true
+	}
+	return false
+}
+
+func (r *ECRPublic) Login(client docker.Client) error {
+	if err := r.fetchCredentials(); err != nil {
+		return err
+	}
+
+	if ok, err := client.RegistryLogin(context.Background(), r.GetAuthConfig()); err == nil {
+		log.Debugf("%s\n", ok.Status)
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (r *ECRPublic) fetchCredentials() error {
+	result, err := r.svc.GetAuthorizationToken(context.Background(), &ecrpublic.GetAuthorizationTokenInput{})
+	if err != nil {
+		return err
+	}
+	if result.AuthorizationData == nil || result.AuthorizationData.AuthorizationToken == nil {
+		return errors.New("no authorization token returned by ECR Public")
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(*result.AuthorizationData.AuthorizationToken)
+	if err != nil {
+		return err
+	}
+	username, password, found := strings.Cut(string(decoded), ":")
+	if !found {
+		return errors.New("invalid authorization token returned by ECR Public")
+	}
+	r.username = username
+	r.password = password
+	return nil
+}
+
+func (r *ECRPublic) GetAuthConfig() registry.AuthConfig {
+	return registry.AuthConfig{Username: r.username, Password: r.password, ServerAddress: ecrPublicHost}
+}
+
+func (r *ECRPublic) GetAuthInfo() string {
+	authBytes, _ := json.Marshal(r.GetAuthConfig())
+	return base64.URLEncoding.EncodeToString(authBytes)
+}
+
+func (r *ECRPublic) RegistryUrl() string {
+	return fmt.Sprintf("%s/%s", ecrPublicHost, r.Alias)
+}
+
+// Create creates the repository if missing, with the configured catalog data.
+// The catalog data of existing repositories is updated if configured.
+func (r *ECRPublic) Create(repository string) error {
+	catalogData := r.catalogData()
+	if _, err := r.svc.DescribeRepositories(context.Background(), &ecrpublic.DescribeRepositoriesInput{
+		RepositoryNames: []string{repository},
+	}); err != nil {
+		var aerr *types.RepositoryNotFoundException
+		if !errors.As(err, &aerr) {
+			return err
+		}
+		_, err := r.svc.CreateRepository(context.Background(), &ecrpublic.CreateRepositoryInput{
+			RepositoryName: aws.String(repository),
+			CatalogData:    catalogData,
+		})
+		return err
+	}
+	if catalogData == nil {
+		return nil
+	}
+	_, err := r.svc.PutRepositoryCatalogData(context.Background(), &ecrpublic.PutRepositoryCatalogDataInput{
+		RepositoryName: aws.String(repository),
+		CatalogData:    catalogData,
+	})
+	return err
+}
+
+// catalogData returns the configured catalog data, or nil if none is configured
+func (r *ECRPublic) catalogData() *types.RepositoryCatalogDataInput {
+	if r.Description == "" && r.About == "" && r.Usage == "" && len(r.Architectures) == 0 && len(r.OperatingSystems) == 0 {
+		return nil
+	}
+	data := &types.RepositoryCatalogDataInput{
+		Architectures:    r.Architectures,
+		OperatingSystems: r.OperatingSystems,
+	}
+	if r.Description != "" {
+		data.Description = aws.String(r.Description)
+	}
+	if r.About != "" {
+		data.AboutText = aws.String(r.About)
+	}
+	if r.Usage != "" {
+		data.UsageText = aws.String(r.Usage)
+	}
+	return data
+}
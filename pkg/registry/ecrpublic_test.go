@@ -0,0 +1,173 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package registry
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecrpublic"
+	"github.com/aws/aws-sdk-go-v2/service/ecrpublic/types"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+
+	"github.com/buildtool/build-tools/pkg/docker"
+)
+
+func TestEcrPublic_LoginAuthRequestFailed(t *testing.T) {
+	client := &docker.MockDocker{}
+	registry := &ECRPublic{Alias: "alias", svc: &MockECRPublic{loginError: fmt.Errorf("auth failure")}}
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	err := registry.Login(client)
+	assert.EqualError(t, err, "auth failure")
+	logMock.Check(t, []string{})
+}
+
+func TestEcrPublic_LoginInvalidAuthData(t *testing.T) {
+	client := &docker.MockDocker{}
+	registry := &ECRPublic{Alias: "alias", svc: &MockECRPublic{authData: "QVdT"}}
+	err := registry.Login(client)
+	assert.EqualError(t, err, "invalid authorization token returned by ECR Public")
+}
+
+func TestEcrPublic_LoginSuccess(t *testing.T) {
+	client := &docker.MockDocker{}
+	registry := &ECRPublic{Alias: "alias", svc: &MockECRPublic{authData: "QVdTOmFiYzEyMw=="}}
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	err := registry.Login(client)
+	assert.NoError(t, err)
+	assert.Equal(t, "AWS", client.Username)
+	assert.Equal(t, "abc123", client.Password)
+	assert.Equal(t, "public.ecr.aws", client.ServerAddress)
+	logMock.Check(t, []string{"debug: Logged in\n"})
+}
+
+func TestEcrPublic_GetAuthInfo(t *testing.T) {
+	registry := &ECRPublic{Alias: "alias", username: "AWS", password: "abc123"}
+	auth := registry.GetAuthInfo()
+	assert.Equal(t, "eyJ1c2VybmFtZSI6IkFXUyIsInBhc3N3b3JkIjoiYWJjMTIzIiwic2VydmVyYWRkcmVzcyI6InB1YmxpYy5lY3IuYXdzIn0=", auth)
+}
+
+func TestEcrPublic_RegistryUrl(t *testing.T) {
+	registry := &ECRPublic{Alias: "alias"}
+	assert.Equal(t, "public.ecr.aws/alias", registry.RegistryUrl())
+}
+
+func TestEcrPublic_NewRepository(t *testing.T) {
+	mock := &MockECRPublic{}
+	registry := &ECRPublic{
+		Alias:         "alias",
+		Description:   "A tool",
+		About:         "# About",
+		Usage:         "# Usage",
+		Architectures: []string{"x86-64", "ARM 64"},
+		svc:           mock,
+	}
+	err := registry.Create("repo")
+	assert.NoError(t, err)
+	assert.Equal(t, &ecrpublic.CreateRepositoryInput{
+		RepositoryName: aws.String("repo"),
+		CatalogData: &types.RepositoryCatalogDataInput{
+			Description:   aws.String("A tool"),
+			AboutText:     aws.String("# About"),
+			UsageText:     aws.String("# Usage"),
+			Architectures: []string{"x86-64", "ARM 64"},
+		},
+	}, mock.createInput)
+	assert.Nil(t, mock.catalogInput)
+}
+
+func TestEcrPublic_NewRepositoryCreateError(t *testing.T) {
+	registry := &ECRPublic{Alias: "alias", svc: &MockECRPublic{createError: fmt.Errorf("create error")}}
+	err := registry.Create("repo")
+	assert.EqualError(t, err, "create error")
+}
+
+func TestEcrPublic_ExistingRepository(t *testing.T) {
+	mock := &MockECRPublic{repoExists: true}
+	registry := &ECRPublic{Alias: "alias", svc: mock}
+	err := registry.Create("repo")
+	assert.NoError(t, err)
+	assert.Nil(t, mock.createInput)
+	assert.Nil(t, mock.catalogInput)
+}
+
+func TestEcrPublic_ExistingRepositoryUpdatesCatalogData(t *testing.T) {
+	mock := &MockECRPublic{repoExists: true}
+	registry := &ECRPublic{Alias: "alias", Description: "A tool", svc: mock}
+	err := registry.Create("repo")
+	assert.NoError(t, err)
+	assert.Nil(t, mock.createInput)
+	assert.Equal(t, aws.String("A tool"), mock.catalogInput.CatalogData.Description)
+}
+
+func TestEcrPublic_DescribeError(t *testing.T) {
+	registry := &ECRPublic{Alias: "alias", svc: &MockECRPublic{describeError: fmt.Errorf("not allowed")}}
+	err := registry.Create("repo")
+	assert.EqualError(t, err, "not allowed")
+}
+
+type MockECRPublic struct {
+	ECRPublicClient
+	loginError    error
+	authData      string
+	repoExists    bool
+	describeError error
+	createError   error
+	createInput   *ecrpublic.CreateRepositoryInput
+	catalogInput  *ecrpublic.PutRepositoryCatalogDataInput
+}
+
+func (r *MockECRPublic) GetAuthorizationToken(context.Context, *ecrpublic.GetAuthorizationTokenInput, ...func(*ecrpublic.Options)) (*ecrpublic.GetAuthorizationTokenOutput, error) {
+	if r.loginError != nil {
+		return nil, r.loginError
+	}
+	return &ecrpublic.GetAuthorizationTokenOutput{AuthorizationData: &types.AuthorizationData{AuthorizationToken: &r.authData}}, nil
+}
+
+func (r *MockECRPublic) DescribeRepositories(context.Context, *ecrpublic.DescribeRepositoriesInput, ...func(*ecrpublic.Options)) (*ecrpublic.DescribeRepositoriesOutput, error) {
+	if r.describeError != nil {
+		return nil, r.describeError
+	}
+	if r.repoExists {
+		return &ecrpublic.DescribeRepositoriesOutput{Repositories: []types.Repository{{RepositoryName: aws.String("repo")}}}, nil
+	}
+	return nil, &types.RepositoryNotFoundException{}
+}
+
+func (r *MockECRPublic) CreateRepository(_ context.Context, input *ecrpublic.CreateRepositoryInput, _ ...func(*ecrpublic.Options)) (*ecrpublic.CreateRepositoryOutput, error) {
+	r.createInput = input
+	return &ecrpublic.CreateRepositoryOutput{}, r.createError
+}
+
+func (r *MockECRPublic) PutRepositoryCatalogData(_ context.Context, input *ecrpublic.PutRepositoryCatalogDataInput, _ ...func(*ecrpublic.Options)) (*ecrpublic.PutRepositoryCatalogDataOutput, error) {
+	r.catalogInput = input
+	return &ecrpublic.PutRepositoryCatalogDataOutput{}, nil
+}
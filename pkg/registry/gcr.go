@@ -26,6 +26,7 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/docker/docker/api/types/registry"
@@ -34,9 +35,10 @@ import (
 )
 
 type GCR struct {
-	dockerRegistry `yaml:"-"`
-	Url            string `yaml:"url" env:"GCR_URL"`
-	KeyFileContent string `yaml:"keyfileContent,omitempty" env:"GCR_KEYFILE_CONTENT"`
+	dockerRegistry    `yaml:"-"`
+	Url               string `yaml:"url" env:"GCR_URL"`
+	KeyFileContent    string `yaml:"keyfileContent,omitempty" env:"GCR_KEYFILE_CONTENT"`
+	CredentialProcess string `yaml:"credentialProcess,omitempty" env:"GCR_CREDENTIAL_PROCESS"`
 }
 
 var _ Registry = &GCR{}
@@ -46,14 +48,23 @@ func (r *GCR) Name() string {
 }
 
 func (r *GCR) Configured() bool {
-	if len(r.Url) <= 0 || len(r.KeyFileContent) <= 0 {
+	if len(r.Url) <= 0 {
+		return false
+	}
+	if r.CredentialProcess != "" {
+		return true
+	}
+	if len(r.KeyFileContent) <= 0 {
 		return false
 	}
 	return r.GetAuthConfig() != registry.AuthConfig{}
 }
 
 func (r *GCR) Login(client docker.Client) error {
-	auth := r.GetAuthConfig()
+	auth, err := r.credentials(r.resolve)
+	if err != nil {
+		return err
+	}
 	auth.ServerAddress = r.Url
 	if ok, err := client.RegistryLogin(context.Background(), auth); err == nil {
 		log.Debugf("%s\n", ok.Status)
@@ -64,11 +75,18 @@ func (r *GCR) Login(client docker.Client) error {
 }
 
 func (r *GCR) GetAuthConfig() registry.AuthConfig {
+	return r.cachedCredentials(r.resolve)
+}
+
+func (r *GCR) resolve() (registry.AuthConfig, *time.Time, error) {
+	if r.CredentialProcess != "" {
+		return withCredentialProcess(registry.AuthConfig{}, r.CredentialProcess)
+	}
 	decoded, err := base64.StdEncoding.DecodeString(r.KeyFileContent)
 	if err != nil {
-		return registry.AuthConfig{}
+		return registry.AuthConfig{}, nil, nil
 	}
-	return registry.AuthConfig{Username: "_json_key", Password: string(decoded)}
+	return registry.AuthConfig{Username: "_json_key", Password: string(decoded)}, nil, nil
 }
 
 func (r *GCR) GetAuthInfo() string {
@@ -76,10 +94,10 @@ func (r *GCR) GetAuthInfo() string {
 	return base64.URLEncoding.EncodeToString(authBytes)
 }
 
-func (r GCR) RegistryUrl() string {
+func (r *GCR) RegistryUrl() string {
 	return r.Url
 }
 
-func (r GCR) Create(repository string) error {
+func (r *GCR) Create(repository string) error {
 	return nil
 }
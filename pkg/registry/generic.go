@@ -0,0 +1,164 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package registry
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"github.com/buildtool/build-tools/pkg/docker"
+)
+
+// Generic is any registry implementing the OCI distribution spec, like a self-hosted registry, Distribution or Zot
+type Generic struct {
+	dockerRegistry `yaml:"-"`
+	// Url is the host of the registry, optionally followed by a namespace, like localhost:5000/team
+	Url      string `yaml:"url" env:"GENERIC_REGISTRY_URL"`
+	Username string `yaml:"username,omitempty" env:"GENERIC_REGISTRY_USERNAME"`
+	Password string `yaml:"password,omitempty" env:"GENERIC_REGISTRY_PASSWORD"`
+	// Token is a bearer token used instead of username and password
+	Token             string `yaml:"token,omitempty" env:"GENERIC_REGISTRY_TOKEN"`
+	CredentialProcess string `yaml:"credentialProcess,omitempty" env:"GENERIC_REGISTRY_CREDENTIAL_PROCESS"`
+	// CACert is the path to a bundle of CA certificates trusted in addition to the system ones
+	CACert string `yaml:"caCert,omitempty" env:"GENERIC_REGISTRY_CA_CERT"`
+	// ClientCert and ClientKey are paths to a certificate and key used for TLS client authentication
+	ClientCert string `yaml:"clientCert,omitempty" env:"GENERIC_REGISTRY_CLIENT_CERT"`
+	ClientKey  string `yaml:"clientKey,omitempty" env:"GENERIC_REGISTRY_CLIENT_KEY"`
+	// Insecure allows plain HTTP and skips verification of TLS certificates
+	Insecure bool `yaml:"insecure,omitempty" env:"GENERIC_REGISTRY_INSECURE"`
+	tr       http.RoundTripper
+}
+
+var _ Registry = &Generic{}
+
+func (r *Generic) Name() string {
+	return "Generic"
+}
+
+func (r *Generic) Configured() bool {
+	return len(r.Url) > 0
+}
+
+func (r *Generic) Login(client docker.Client) error {
+	auth, err := r.credentials(r.resolve)
+	if err != nil {
+		return err
+	}
+	if err := r.configureTransport(); err != nil {
+		return err
+	}
+	if !docker.HasCredentials(auth) {
+		log.Debugf("No credentials configured for <green>%s</green>, skipping login\n", r.host())
+		return nil
+	}
+	if auth.Username == "" && auth.Password == "" {
+		log.Debugf("Only a token configured for <green>%s</green>, skipping login\n", r.host())
+		return nil
+	}
+	if ok, err := client.RegistryLogin(context.Background(), auth); err == nil {
+		log.Debugf("%s\n", ok.Status)
+		return nil
+	} else {
+		return err
+	}
+}
+
+// configureTransport sets up the transport for the registry API, with the configured certificates
+func (r *Generic) configureTransport() error {
+	if r.CACert == "" && r.ClientCert == "" && !r.Insecure {
+		return nil
+	}
+	config := &tls.Config{InsecureSkipVerify: r.Insecure}
+	if r.CACert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(r.CACert)
+		if err != nil {
+			return fmt.Errorf("unable to read CA certificates: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no CA certificates found in %s", r.CACert)
+		}
+		config.RootCAs = pool
+	}
+	if r.ClientCert != "" || r.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(r.ClientCert, r.ClientKey)
+		if err != nil {
+			return fmt.Errorf("unable to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	tr := remote.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = config
+	r.tr = tr
+	return nil
+}
+
+func (r *Generic) transport() http.RoundTripper {
+	return r.tr
+}
+
+func (r *Generic) insecure() bool {
+	return r.Insecure
+}
+
+func (r *Generic) host() string {
+	host, _, _ := strings.Cut(r.Url, "/")
+	return host
+}
+
+func (r *Generic) GetAuthConfig() registry.AuthConfig {
+	return r.cachedCredentials(r.resolve)
+}
+
+func (r *Generic) resolve() (registry.AuthConfig, *time.Time, error) {
+	return resolveCredentials(registry.AuthConfig{Username: r.Username, Password: r.Password, RegistryToken: r.Token, ServerAddress: r.host()}, r.CredentialProcess, r.host())
+}
+
+func (r *Generic) GetAuthInfo() string {
+	authBytes, _ := json.Marshal(r.GetAuthConfig())
+	return base64.URLEncoding.EncodeToString(authBytes)
+}
+
+func (r *Generic) RegistryUrl() string {
+	return r.Url
+}
+
+// Create does nothing, repositories are created when pushed to
+func (r *Generic) Create(repository string) error {
+	return nil
+}
@@ -0,0 +1,219 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package registry
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	stdlog "log"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	dockerregistry "github.com/docker/docker/api/types/registry"
+	refname "github.com/google/go-containerregistry/pkg/name"
+	ggcr "github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+
+	"github.com/buildtool/build-tools/pkg/docker"
+)
+
+// tlsRegistry starts an in-memory registry served over TLS, returning its host and the path of its CA certificate
+func tlsRegistry(t *testing.T, clientAuth tls.ClientAuthType) (string, string) {
+	server := httptest.NewUnstartedServer(ggcr.New(ggcr.Logger(stdlog.New(io.Discard, "", 0))))
+	server.TLS = &tls.Config{ClientAuth: clientAuth}
+	server.Config.ErrorLog = stdlog.New(io.Discard, "", 0)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+	return strings.TrimPrefix(server.URL, "https://"), caFile
+}
+
+// clientCertificate writes a self-signed client certificate and key, returning their paths
+func clientCertificate(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile
+}
+
+func writeImage(r *Generic) error {
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		return err
+	}
+	ref, err := refname.ParseReference(fmt.Sprintf("%s/image:tag", r.RegistryUrl()), NameOptions(r)...)
+	if err != nil {
+		return err
+	}
+	return remote.Write(ref, img, RemoteOptions(context.Background(), r)...)
+}
+
+func TestGeneric_LoginSuccess(t *testing.T) {
+	client := &docker.MockDocker{}
+	registry := &Generic{Url: "registry.example.com:5000/team", Username: "user", Password: "pass"}
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	err := registry.Login(client)
+	assert.NoError(t, err)
+	assert.Equal(t, "user", client.Username)
+	assert.Equal(t, "pass", client.Password)
+	assert.Equal(t, "registry.example.com:5000", client.ServerAddress)
+	logMock.Check(t, []string{"debug: Logged in\n"})
+}
+
+func TestGeneric_LoginWithoutCredentials(t *testing.T) {
+	client := &docker.MockDocker{}
+	registry := &Generic{Url: "localhost:5000"}
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	err := registry.Login(client)
+	assert.NoError(t, err)
+	assert.Equal(t, "", client.ServerAddress)
+	logMock.Check(t, []string{"debug: No credentials configured for <green>localhost:5000</green>, skipping login\n"})
+}
+
+func TestGeneric_LoginTokenOnly(t *testing.T) {
+	client := &docker.MockDocker{LoginError: fmt.Errorf("login not expected")}
+	registry := &Generic{Url: "localhost:5000", Token: "token"}
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	err := registry.Login(client)
+	assert.NoError(t, err)
+	assert.Equal(t, "", client.ServerAddress)
+	logMock.Check(t, []string{"debug: Only a token configured for <green>localhost:5000</green>, skipping login\n"})
+
+	auth, err := Authenticator(registry).Authorization()
+	assert.NoError(t, err)
+	assert.Equal(t, "token", auth.RegistryToken)
+}
+
+func TestGeneric_LoginFailed(t *testing.T) {
+	client := &docker.MockDocker{LoginError: fmt.Errorf("invalid username/password")}
+	registry := &Generic{Url: "localhost:5000", Username: "user", Password: "pass"}
+	err := registry.Login(client)
+	assert.EqualError(t, err, "invalid username/password")
+}
+
+func TestGeneric_GetAuthConfig(t *testing.T) {
+	registry := &Generic{Url: "localhost:5000/team", Token: "token"}
+	assert.Equal(t, dockerregistry.AuthConfig{RegistryToken: "token", ServerAddress: "localhost:5000"}, registry.GetAuthConfig())
+	assert.Equal(t, "localhost:5000/team", registry.RegistryUrl())
+	assert.NoError(t, registry.Create("repo"))
+}
+
+func TestGeneric_CACert(t *testing.T) {
+	host, caFile := tlsRegistry(t, tls.NoClientCert)
+
+	assert.Error(t, writeImage(&Generic{Url: host}))
+
+	registry := &Generic{Url: host, CACert: caFile}
+	assert.NoError(t, Authenticate(registry))
+	assert.NoError(t, writeImage(registry))
+}
+
+func TestGeneric_ClientCert(t *testing.T) {
+	host, caFile := tlsRegistry(t, tls.RequireAnyClientCert)
+	certFile, keyFile := clientCertificate(t)
+
+	registry := &Generic{Url: host, CACert: caFile}
+	assert.NoError(t, Authenticate(registry))
+	assert.Error(t, writeImage(registry))
+
+	registry = &Generic{Url: host, CACert: caFile, ClientCert: certFile, ClientKey: keyFile}
+	assert.NoError(t, Authenticate(registry))
+	assert.NoError(t, writeImage(registry))
+}
+
+func TestGeneric_InsecureTLS(t *testing.T) {
+	host, _ := tlsRegistry(t, tls.NoClientCert)
+
+	registry := &Generic{Url: host, Insecure: true}
+	assert.NoError(t, Authenticate(registry))
+	assert.NoError(t, writeImage(registry))
+}
+
+func TestGeneric_NameOptions(t *testing.T) {
+	ref, err := refname.ParseReference("registry.example.com/image:tag", NameOptions(&Generic{Url: "registry.example.com", Insecure: true})...)
+	assert.NoError(t, err)
+	assert.Equal(t, "http", ref.Context().Scheme())
+
+	ref, err = refname.ParseReference("registry.example.com/image:tag", NameOptions(&Generic{Url: "registry.example.com"})...)
+	assert.NoError(t, err)
+	assert.Equal(t, "https", ref.Context().Scheme())
+}
+
+func TestGeneric_MissingCACert(t *testing.T) {
+	registry := &Generic{Url: "localhost:5000", CACert: "missing.pem"}
+	err := registry.Login(&docker.MockDocker{})
+	assert.EqualError(t, err, "unable to read CA certificates: open missing.pem: no such file or directory")
+}
+
+func TestGeneric_InvalidCACert(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, os.WriteFile(caFile, []byte("not a certificate"), 0600))
+	registry := &Generic{Url: "localhost:5000", CACert: caFile}
+	err := Authenticate(registry)
+	assert.EqualError(t, err, fmt.Sprintf("no CA certificates found in %s", caFile))
+}
+
+func TestGeneric_MissingClientKey(t *testing.T) {
+	certFile, _ := clientCertificate(t)
+	registry := &Generic{Url: "localhost:5000", ClientCert: certFile}
+	err := Authenticate(registry)
+	assert.EqualError(t, err, "unable to load client certificate: open : no such file or directory")
+}
@@ -27,6 +27,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/docker/docker/api/types/registry"
@@ -35,25 +36,30 @@ import (
 )
 
 type Github struct {
-	dockerRegistry `yaml:"-"`
-	Username       string `yaml:"username" env:"GITHUB_USERNAME"`
-	Password       string `yaml:"password" env:"GITHUB_PASSWORD"`
-	Token          string `yaml:"token" env:"GITHUB_TOKEN"`
-	Repository     string `yaml:"repository" env:"GITHUB_REPOSITORY_OWNER"`
+	dockerRegistry    `yaml:"-"`
+	Username          string `yaml:"username" env:"GITHUB_USERNAME"`
+	Password          string `yaml:"password" env:"GITHUB_PASSWORD"`
+	Token             string `yaml:"token" env:"GITHUB_TOKEN"`
+	CredentialProcess string `yaml:"credentialProcess,omitempty" env:"GITHUB_CREDENTIAL_PROCESS"`
+	Repository        string `yaml:"repository" env:"GITHUB_REPOSITORY_OWNER"`
 }
 
 var _ Registry = &Github{}
 
-func (r Github) Name() string {
+func (r *Github) Name() string {
 	return "Github"
 }
 
-func (r Github) Configured() bool {
+func (r *Github) Configured() bool {
 	return len(r.Repository) > 0
 }
 
-func (r Github) Login(client docker.Client) error {
-	if ok, err := client.RegistryLogin(context.Background(), registry.AuthConfig{Username: r.Username, Password: r.password(), ServerAddress: "ghcr.io"}); err == nil {
+func (r *Github) Login(client docker.Client) error {
+	auth, err := r.credentials(r.resolve)
+	if err != nil {
+		return err
+	}
+	if ok, err := client.RegistryLogin(context.Background(), auth); err == nil {
 		log.Debugf("%s\n", ok.Status)
 		return nil
 	} else {
@@ -61,22 +67,26 @@ func (r Github) Login(client docker.Client) error {
 	}
 }
 
-func (r Github) password() string {
+func (r *Github) password() string {
 	if len(r.Token) > 0 {
 		return r.Token
 	}
 	return r.Password
 }
-func (r Github) GetAuthConfig() registry.AuthConfig {
-	return registry.AuthConfig{Username: r.Username, Password: r.password(), ServerAddress: "ghcr.io"}
+func (r *Github) GetAuthConfig() registry.AuthConfig {
+	return r.cachedCredentials(r.resolve)
+}
+
+func (r *Github) resolve() (registry.AuthConfig, *time.Time, error) {
+	return resolveCredentials(registry.AuthConfig{Username: r.Username, Password: r.password(), ServerAddress: "ghcr.io"}, r.CredentialProcess, "ghcr.io")
 }
 
-func (r Github) GetAuthInfo() string {
+func (r *Github) GetAuthInfo() string {
 	authBytes, _ := json.Marshal(r.GetAuthConfig())
 	return base64.URLEncoding.EncodeToString(authBytes)
 }
 
-func (r Github) RegistryUrl() string {
+func (r *Github) RegistryUrl() string {
 	return fmt.Sprintf("ghcr.io/%s", r.Repository)
 }
 
@@ -27,6 +27,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"strings"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/docker/docker/api/types/registry"
@@ -35,25 +36,30 @@ import (
 )
 
 type Gitlab struct {
-	dockerRegistry `yaml:"-"`
-	Registry       string `yaml:"registry" env:"CI_REGISTRY"`
-	User           string `yaml:"user" env:"CI_REGISTRY_USER"`
-	Repository     string `yaml:"repository" env:"CI_REGISTRY_IMAGE"`
-	Token          string `yaml:"token,omitempty" env:"CI_JOB_TOKEN"`
+	dockerRegistry    `yaml:"-"`
+	Registry          string `yaml:"registry" env:"CI_REGISTRY"`
+	User              string `yaml:"user" env:"CI_REGISTRY_USER"`
+	Repository        string `yaml:"repository" env:"CI_REGISTRY_IMAGE"`
+	Token             string `yaml:"token,omitempty" env:"CI_JOB_TOKEN"`
+	CredentialProcess string `yaml:"credentialProcess,omitempty" env:"GITLAB_CREDENTIAL_PROCESS"`
 }
 
 var _ Registry = &Gitlab{}
 
-func (r Gitlab) Name() string {
+func (r *Gitlab) Name() string {
 	return "Gitlab"
 }
 
-func (r Gitlab) Configured() bool {
+func (r *Gitlab) Configured() bool {
 	return len(r.Repository) > 0 || len(r.Registry) > 0
 }
 
-func (r Gitlab) Login(client docker.Client) error {
-	if ok, err := client.RegistryLogin(context.Background(), r.GetAuthConfig()); err == nil {
+func (r *Gitlab) Login(client docker.Client) error {
+	auth, err := r.credentials(r.resolve)
+	if err != nil {
+		return err
+	}
+	if ok, err := client.RegistryLogin(context.Background(), auth); err == nil {
 		log.Debugf("%s\n", ok.Status)
 		return nil
 	} else {
@@ -61,16 +67,20 @@ func (r Gitlab) Login(client docker.Client) error {
 	}
 }
 
-func (r Gitlab) GetAuthConfig() registry.AuthConfig {
-	return registry.AuthConfig{Username: r.User, Password: r.Token, ServerAddress: r.Registry}
+func (r *Gitlab) GetAuthConfig() registry.AuthConfig {
+	return r.cachedCredentials(r.resolve)
+}
+
+func (r *Gitlab) resolve() (registry.AuthConfig, *time.Time, error) {
+	return resolveCredentials(registry.AuthConfig{Username: r.User, Password: r.Token, ServerAddress: r.Registry}, r.CredentialProcess, r.Registry)
 }
 
-func (r Gitlab) GetAuthInfo() string {
+func (r *Gitlab) GetAuthInfo() string {
 	authBytes, _ := json.Marshal(r.GetAuthConfig())
 	return base64.URLEncoding.EncodeToString(authBytes)
 }
 
-func (r Gitlab) RegistryUrl() string {
+func (r *Gitlab) RegistryUrl() string {
 	if len(r.Repository) != 0 {
 		if strings.Contains(r.Repository, "/") {
 			return r.Repository[:strings.LastIndex(r.Repository, "/")]
@@ -0,0 +1,206 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/go-units"
+
+	"github.com/buildtool/build-tools/pkg/docker"
+)
+
+type Harbor struct {
+	dockerRegistry    `yaml:"-"`
+	Url               string `yaml:"url" env:"HARBOR_URL"`
+	Project           string `yaml:"project" env:"HARBOR_PROJECT"`
+	Username          string `yaml:"username" env:"HARBOR_USERNAME"`
+	Password          string `yaml:"password" env:"HARBOR_PASSWORD"`
+	CredentialProcess string `yaml:"credentialProcess,omitempty" env:"HARBOR_CREDENTIAL_PROCESS"`
+	// Public makes projects created by Create public
+	Public bool `yaml:"public,omitempty"`
+	// StorageLimit is the storage quota of created projects, like 10GiB
+	StorageLimit string `yaml:"storageLimit,omitempty"`
+	// ScanOnPush scans images for vulnerabilities when pushed to created projects
+	ScanOnPush bool `yaml:"scanOnPush,omitempty"`
+	// PreventSeverity prevents pulling images with vulnerabilities of this severity or higher from created projects
+	PreventSeverity string           `yaml:"preventSeverity,omitempty"`
+	Retention       *HarborRetention `yaml:"retention,omitempty"`
+	client          *http.Client
+}
+
+// HarborRetention is the tag retention policy of projects created by Create
+type HarborRetention struct {
+	// KeepLatest is the number of most recently pushed artifacts kept in each repository
+	KeepLatest int `yaml:"keepLatest"`
+	// Schedule is a cron expression with seconds for when retention runs, defaults to daily at midnight
+	Schedule string `yaml:"schedule,omitempty"`
+}
+
+var _ Registry = &Harbor{}
+
+func (r *Harbor) Name() string {
+	return "Harbor"
+}
+
+func (r *Harbor) Configured() bool {
+	return len(r.Url) > 0 && len(r.Project) > 0
+}
+
+func (r *Harbor) Login(client docker.Client) error {
+	auth, err := r.credentials(r.resolve)
+	if err != nil {
+		return err
+	}
+	if ok, err := client.RegistryLogin(context.Background(), auth); err == nil {
+		log.Debugf("%s\n", ok.Status)
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (r *Harbor) GetAuthConfig() registry.AuthConfig {
+	return r.cachedCredentials(r.resolve)
+}
+
+func (r *Harbor) resolve() (registry.AuthConfig, *time.Time, error) {
+	return resolveCredentials(registry.AuthConfig{Username: r.Username, Password: r.Password, ServerAddress: r.Url}, r.CredentialProcess, r.Url)
+}
+
+func (r *Harbor) GetAuthInfo() string {
+	authBytes, _ := json.Marshal(r.GetAuthConfig())
+	return base64.URLEncoding.EncodeToString(authBytes)
+}
+
+func (r *Harbor) RegistryUrl() string {
+	return fmt.Sprintf("%s/%s", r.Url, r.Project)
+}
+
+// Create creates the project if missing, repositories in the project are created when pushed to
+func (r *Harbor) Create(repository string) error {
+	status, err := r.api().request(http.MethodHead, fmt.Sprintf("/projects?project_name=%s", url.QueryEscape(r.Project)), nil, nil)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusOK {
+		return nil
+	}
+	if status != http.StatusNotFound {
+		return fmt.Errorf("unable to check if project %s exists: unexpected status %d", r.Project, status)
+	}
+
+	project := map[string]interface{}{
+		"project_name": r.Project,
+		"metadata":     r.metadata(),
+	}
+	if r.StorageLimit != "" {
+		limit, err := units.RAMInBytes(r.StorageLimit)
+		if err != nil {
+			return fmt.Errorf("invalid storage limit: %w", err)
+		}
+		project["storage_limit"] = limit
+	}
+	log.Debugf("Creating project '<green>%s</green>' in Harbor\n", r.Project)
+	if status, err := r.api().request(http.MethodPost, "/projects", project, nil); err != nil {
+		return err
+	} else if status == http.StatusConflict {
+		// created concurrently, the retention policy is added by whoever created it
+		return nil
+	} else if status != http.StatusCreated {
+		return fmt.Errorf("unable to create project %s: unexpected status %d", r.Project, status)
+	}
+	if r.Retention != nil {
+		return r.createRetention()
+	}
+	return nil
+}
+
+func (r *Harbor) metadata() map[string]string {
+	metadata := map[string]string{"public": fmt.Sprint(r.Public)}
+	if r.ScanOnPush {
+		metadata["auto_scan"] = "true"
+	}
+	if r.PreventSeverity != "" {
+		metadata["prevent_vul"] = "true"
+		metadata["severity"] = r.PreventSeverity
+	}
+	return metadata
+}
+
+// createRetention adds a retention policy to the project, keeping the latest pushed artifacts of each repository
+func (r *Harbor) createRetention() error {
+	var project struct {
+		ProjectId int `json:"project_id"`
+	}
+	if status, err := r.api().request(http.MethodGet, fmt.Sprintf("/projects/%s", url.PathEscape(r.Project)), nil, &project); err != nil {
+		return err
+	} else if status != http.StatusOK {
+		return fmt.Errorf("unable to get project %s: unexpected status %d", r.Project, status)
+	}
+	schedule := r.Retention.Schedule
+	if schedule == "" {
+		schedule = "0 0 0 * * *"
+	}
+	policy := map[string]interface{}{
+		"algorithm": "or",
+		"rules": []interface{}{map[string]interface{}{
+			"action":   "retain",
+			"template": "latestPushedK",
+			"params":   map[string]interface{}{"latestPushedK": r.Retention.KeepLatest},
+			"tag_selectors": []interface{}{map[string]interface{}{
+				"kind": "doublestar", "decoration": "matches", "pattern": "**",
+			}},
+			"scope_selectors": map[string]interface{}{"repository": []interface{}{map[string]interface{}{
+				"kind": "doublestar", "decoration": "repoMatches", "pattern": "**",
+			}}},
+		}},
+		"trigger": map[string]interface{}{"kind": "Schedule", "settings": map[string]interface{}{"cron": schedule}},
+		"scope":   map[string]interface{}{"level": "project", "ref": project.ProjectId},
+	}
+	if status, err := r.api().request(http.MethodPost, "/retentions", policy, nil); err != nil {
+		return err
+	} else if status != http.StatusCreated {
+		return fmt.Errorf("unable to create retention policy for project %s: unexpected status %d", r.Project, status)
+	}
+	return nil
+}
+
+// api returns a client for the Harbor API, authenticated with the credentials of the registry
+func (r *Harbor) api() apiClient {
+	auth := r.GetAuthConfig()
+	return apiClient{
+		client:  r.client,
+		baseUrl: fmt.Sprintf("https://%s/api/v2.0", r.Url),
+		auth:    basic(auth.Username, auth.Password),
+		header:  http.Header{"X-Is-Resource-Name": {"true"}},
+	}
+}
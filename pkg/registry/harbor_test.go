@@ -0,0 +1,178 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+
+	"github.com/buildtool/build-tools/pkg/docker"
+)
+
+func newHarborServer(t *testing.T, exists bool) *apiServer {
+	s := newApiServer(t, exists)
+	s.authorized = func(r *http.Request) bool {
+		user, pass, _ := r.BasicAuth()
+		return user == "user" && pass == "pass"
+	}
+	s.respond = func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodGet:
+			_, _ = fmt.Fprint(w, `{"project_id":42,"name":"project"}`)
+		}
+	}
+	s.StartTLS()
+	return s
+}
+
+func (s *apiServer) harbor() *Harbor {
+	return &Harbor{Url: strings.TrimPrefix(s.URL, "https://"), Project: "project", Username: "user", Password: "pass", client: s.Client()}
+}
+
+func TestHarbor_LoginSuccess(t *testing.T) {
+	client := &docker.MockDocker{}
+	registry := &Harbor{Url: "harbor.example.com", Project: "project", Username: "user", Password: "pass"}
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	err := registry.Login(client)
+	assert.NoError(t, err)
+	assert.Equal(t, "user", client.Username)
+	assert.Equal(t, "pass", client.Password)
+	assert.Equal(t, "harbor.example.com", client.ServerAddress)
+	logMock.Check(t, []string{"debug: Logged in\n"})
+}
+
+func TestHarbor_LoginFailed(t *testing.T) {
+	client := &docker.MockDocker{LoginError: fmt.Errorf("invalid username/password")}
+	registry := &Harbor{Url: "harbor.example.com", Project: "project"}
+	err := registry.Login(client)
+	assert.EqualError(t, err, "invalid username/password")
+}
+
+func TestHarbor_RegistryUrl(t *testing.T) {
+	registry := &Harbor{Url: "harbor.example.com", Project: "project"}
+	assert.Equal(t, "harbor.example.com/project", registry.RegistryUrl())
+}
+
+func TestHarbor_ExistingProject(t *testing.T) {
+	server := newHarborServer(t, true)
+	err := server.harbor().Create("repo")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"HEAD /api/v2.0/projects?project_name=project"}, server.requests)
+}
+
+func TestHarbor_CredentialProcess(t *testing.T) {
+	server := newHarborServer(t, true)
+	harbor := server.harbor()
+	harbor.Username, harbor.Password = "", ""
+	harbor.CredentialProcess = `echo '{"Version":1,"Username":"user","Password":"pass"}'`
+	err := harbor.Create("repo")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"HEAD /api/v2.0/projects?project_name=project"}, server.requests)
+}
+
+func TestHarbor_NewProject(t *testing.T) {
+	server := newHarborServer(t, false)
+	log.SetHandler(mocks.New())
+	err := server.harbor().Create("repo")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"HEAD /api/v2.0/projects?project_name=project", "POST /api/v2.0/projects"}, server.requests)
+	assert.Equal(t, map[string]interface{}{
+		"project_name": "project",
+		"metadata":     map[string]interface{}{"public": "false"},
+	}, server.bodies["POST /api/v2.0/projects"])
+}
+
+func TestHarbor_NewProjectWithPolicies(t *testing.T) {
+	server := newHarborServer(t, false)
+	log.SetHandler(mocks.New())
+	registry := server.harbor()
+	registry.Public = true
+	registry.StorageLimit = "10GiB"
+	registry.ScanOnPush = true
+	registry.PreventSeverity = "high"
+	registry.Retention = &HarborRetention{KeepLatest: 10}
+	err := registry.Create("repo")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"HEAD /api/v2.0/projects?project_name=project",
+		"POST /api/v2.0/projects",
+		"GET /api/v2.0/projects/project",
+		"POST /api/v2.0/retentions",
+	}, server.requests)
+	assert.Equal(t, map[string]interface{}{
+		"project_name":  "project",
+		"storage_limit": float64(10 * 1024 * 1024 * 1024),
+		"metadata":      map[string]interface{}{"public": "true", "auto_scan": "true", "prevent_vul": "true", "severity": "high"},
+	}, server.bodies["POST /api/v2.0/projects"])
+	retention := server.bodies["POST /api/v2.0/retentions"]
+	assert.Equal(t, map[string]interface{}{"level": "project", "ref": float64(42)}, retention["scope"])
+	assert.Equal(t, map[string]interface{}{"kind": "Schedule", "settings": map[string]interface{}{"cron": "0 0 0 * * *"}}, retention["trigger"])
+	rule := retention["rules"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"latestPushedK": float64(10)}, rule["params"])
+}
+
+func TestHarbor_InvalidStorageLimit(t *testing.T) {
+	server := newHarborServer(t, false)
+	registry := server.harbor()
+	registry.StorageLimit = "lots"
+	err := registry.Create("repo")
+	assert.EqualError(t, err, "invalid storage limit: invalid size: 'lots'")
+}
+
+func TestHarbor_Unauthorized(t *testing.T) {
+	server := newHarborServer(t, false)
+	registry := server.harbor()
+	registry.Password = "wrong"
+	err := registry.Create("repo")
+	assert.EqualError(t, err, "unable to check if project project exists: unexpected status 401")
+}
+
+func TestHarbor_CreatedConcurrently(t *testing.T) {
+	server := newHarborServer(t, false)
+	log.SetHandler(mocks.New())
+	server.createStatus = http.StatusConflict
+	registry := server.harbor()
+	registry.Retention = &HarborRetention{KeepLatest: 20}
+	err := registry.Create("repo")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"HEAD /api/v2.0/projects?project_name=project", "POST /api/v2.0/projects"}, server.requests)
+}
+
+func TestHarbor_CreateForbidden(t *testing.T) {
+	server := newHarborServer(t, false)
+	log.SetHandler(mocks.New())
+	// robot accounts are not allowed to create projects
+	server.createStatus = http.StatusForbidden
+	err := server.harbor().Create("repo")
+	assert.EqualError(t, err, "unable to create project project: unexpected status 403")
+}
@@ -62,8 +62,8 @@ func (n NoDockerRegistry) Create(repository string) error {
 	return nil
 }
 
-func (n NoDockerRegistry) PushImage(client docker.Client, auth, image string) error {
-	return fmt.Errorf("push not supported by registry")
+func (n NoDockerRegistry) PushImage(client docker.Client, auth, image string) (string, error) {
+	return "", fmt.Errorf("push not supported by registry")
 }
 
 var _ Registry = &NoDockerRegistry{}
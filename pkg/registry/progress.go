@@ -0,0 +1,212 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package registry
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/go-units"
+	"golang.org/x/term"
+)
+
+// now is used for timing pushes, replaced in tests
+var now = time.Now
+
+// display renders the progress of all ongoing pushes to stderr
+var display = newProgressDisplay(os.Stderr, term.IsTerminal(int(os.Stderr.Fd())))
+
+// progressDisplay renders push progress as per-layer bars on a terminal, redrawn in place for all ongoing pushes,
+// and as periodic summary lines otherwise (for example in CI logs)
+type progressDisplay struct {
+	mu       sync.Mutex
+	out      io.Writer
+	terminal bool
+	interval time.Duration
+	active   []*pushProgress
+	lines    int
+	drawn    time.Time
+}
+
+func newProgressDisplay(out io.Writer, terminal bool) *progressDisplay {
+	return &progressDisplay{out: out, terminal: terminal, interval: 10 * time.Second}
+}
+
+type pushProgress struct {
+	image    string
+	started  time.Time
+	reported time.Time
+	layers   map[string]*layerProgress
+	order    []string
+}
+
+type layerProgress struct {
+	status   string
+	progress string
+	current  int64
+	total    int64
+	existed  bool
+	pushed   bool
+}
+
+func (d *progressDisplay) start(image string) *pushProgress {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	started := now()
+	p := &pushProgress{image: image, started: started, reported: started, layers: map[string]*layerProgress{}}
+	d.active = append(d.active, p)
+	return p
+}
+
+func (d *progressDisplay) update(p *pushProgress, r *responsetype) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !p.update(r) {
+		return
+	}
+	current := now()
+	if d.terminal {
+		if current.Sub(d.drawn) >= 100*time.Millisecond {
+			d.draw(current)
+			d.drawn = current
+		}
+	} else if current.Sub(p.reported) >= d.interval {
+		_, _ = fmt.Fprintln(d.out, p.summary(current))
+		p.reported = current
+	}
+}
+
+func (d *progressDisplay) finish(p *pushProgress) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, active := range d.active {
+		if active == p {
+			d.active = append(d.active[:i], d.active[i+1:]...)
+			break
+		}
+	}
+	current := now()
+	var result []string
+	if len(p.layers) > 0 {
+		result = append(result, p.result(current))
+	}
+	if d.terminal {
+		d.draw(current, result...)
+	} else if len(result) > 0 {
+		_, _ = fmt.Fprintln(d.out, result[0])
+	}
+}
+
+// draw redraws the ongoing pushes in place, printing the permanent lines above them
+func (d *progressDisplay) draw(at time.Time, permanent ...string) {
+	b := &strings.Builder{}
+	if d.lines > 0 {
+		_, _ = fmt.Fprintf(b, "\x1b[%dA", d.lines)
+	}
+	for _, line := range permanent {
+		_, _ = fmt.Fprintf(b, "\x1b[K%s\n", line)
+	}
+	d.lines = 0
+	for _, p := range d.active {
+		for _, line := range p.lines(at) {
+			_, _ = fmt.Fprintf(b, "\x1b[K%s\n", line)
+			d.lines++
+		}
+	}
+	b.WriteString("\x1b[J")
+	_, _ = io.WriteString(d.out, b.String())
+}
+
+// update records a message from the push stream, returning false if it isn't about a layer
+func (p *pushProgress) update(r *responsetype) bool {
+	if r.Id == "" || r.Status == "" {
+		return false
+	}
+	l, exists := p.layers[r.Id]
+	if !exists {
+		l = &layerProgress{}
+		p.layers[r.Id] = l
+		p.order = append(p.order, r.Id)
+	}
+	l.status = r.Status
+	l.progress = r.Progress
+	switch {
+	case r.Status == "Layer already exists" || strings.HasPrefix(r.Status, "Mounted from"):
+		l.existed = true
+	case r.Status == "Pushed":
+		l.pushed = true
+		l.current = l.total
+	case r.ProgressDetail != nil && r.ProgressDetail.Total > 0:
+		l.current = min(r.ProgressDetail.Current, r.ProgressDetail.Total)
+		l.total = r.ProgressDetail.Total
+	}
+	return true
+}
+
+// counts returns the bytes uploaded, the total bytes to upload and the number of pushed and existing layers
+func (p *pushProgress) counts() (current, total int64, pushed, existed int) {
+	for _, l := range p.layers {
+		current += l.current
+		total += l.total
+		if l.pushed {
+			pushed++
+		}
+		if l.existed {
+			existed++
+		}
+	}
+	return current, total, pushed, existed
+}
+
+func (p *pushProgress) rate(current int64, at time.Time) string {
+	elapsed := at.Sub(p.started).Seconds()
+	if elapsed <= 0 {
+		return units.HumanSize(0)
+	}
+	return units.HumanSize(float64(current) / elapsed)
+}
+
+func (p *pushProgress) summary(at time.Time) string {
+	current, total, pushed, existed := p.counts()
+	return fmt.Sprintf("%s: %s of %s uploaded, %d of %d layers pushed, %d already existed, %s/s",
+		p.image, units.HumanSize(float64(current)), units.HumanSize(float64(total)), pushed, len(p.layers)-existed, existed, p.rate(current, at))
+}
+
+func (p *pushProgress) result(at time.Time) string {
+	current, _, pushed, existed := p.counts()
+	return fmt.Sprintf("%s: %s uploaded in %s, %d layers pushed, %d already existed, %s/s",
+		p.image, units.HumanSize(float64(current)), at.Sub(p.started).Round(time.Second), pushed, existed, p.rate(current, at))
+}
+
+func (p *pushProgress) lines(at time.Time) []string {
+	lines := []string{p.summary(at)}
+	for _, id := range p.order {
+		l := p.layers[id]
+		lines = append(lines, strings.TrimRight(fmt.Sprintf("  %s: %-20s %s", id, l.status, l.progress), " "))
+	}
+	return lines
+}
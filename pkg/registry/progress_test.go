@@ -0,0 +1,107 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package registry
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/buildtool/build-tools/pkg/docker"
+)
+
+const pushOutput = `{"status":"The push refers to repository [repo/image]"}
+{"status":"Preparing","progressDetail":{},"id":"cb13bd9b95b6"}
+{"status":"Preparing","progressDetail":{},"id":"5905e8d02856"}
+{"status":"Preparing","progressDetail":{},"id":"6096558c3d50"}
+{"status":"Layer already exists","progressDetail":{},"id":"6096558c3d50"}
+{"status":"Pushing","progressDetail":{"current":6000,"total":12000},"progress":"[=========================>                         ]      6kB/12kB","id":"cb13bd9b95b6"}
+{"status":"Pushing","progressDetail":{"current":3000,"total":3000},"progress":"[==================================================>]      3kB","id":"5905e8d02856"}
+{"status":"Pushing","progressDetail":{"current":12000,"total":12000},"progress":"[==================================================>]     12kB","id":"cb13bd9b95b6"}
+{"status":"Pushed","progressDetail":{},"id":"5905e8d02856"}
+{"status":"Pushed","progressDetail":{},"id":"cb13bd9b95b6"}
+{"status":"abc123: digest: sha256:af534ee896ce2ac80f3413318329e45e3b3e74b89eb337b9364b8ac1e83498b7 size: 2828"}
+{"progressDetail":{},"aux":{"Tag":"abc123","Digest":"sha256:af534ee896ce2ac80f3413318329e45e3b3e74b89eb337b9364b8ac1e83498b7","Size":2828}}
+`
+
+// withDisplay replaces the display and the clock, which advances a second each time it's read
+func withDisplay(t *testing.T, terminal bool) *bytes.Buffer {
+	out := &bytes.Buffer{}
+	previous, previousNow := display, now
+	display = newProgressDisplay(out, terminal)
+	current := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time {
+		current = current.Add(time.Second)
+		return current
+	}
+	t.Cleanup(func() { display, now = previous, previousNow })
+	return out
+}
+
+func TestPushImage_ProgressSummaries(t *testing.T) {
+	out := withDisplay(t, false)
+	display.interval = 3 * time.Second
+	output := pushOutput
+	client := &docker.MockDocker{PushOutput: &output}
+
+	_, err := (&Gitlab{}).PushImage(client, "dummy", "repo/image:abc123")
+
+	assert.NoError(t, err)
+	assert.Equal(t, `repo/image:abc123: 0B of 0B uploaded, 0 of 3 layers pushed, 0 already existed, 0B/s
+repo/image:abc123: 9kB of 15kB uploaded, 0 of 2 layers pushed, 1 already existed, 1.5kB/s
+repo/image:abc123: 15kB of 15kB uploaded, 2 of 2 layers pushed, 1 already existed, 1.667kB/s
+repo/image:abc123: 15kB uploaded in 10s, 2 layers pushed, 1 already existed, 1.5kB/s
+`, out.String())
+}
+
+func TestPushImage_ProgressNothingPushed(t *testing.T) {
+	out := withDisplay(t, false)
+	output := `{"status":"Push successful"}`
+	client := &docker.MockDocker{PushOutput: &output}
+
+	_, err := (&Gitlab{}).PushImage(client, "dummy", "repo/image:abc123")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "", out.String())
+}
+
+func TestPushImage_ProgressTerminal(t *testing.T) {
+	out := withDisplay(t, true)
+	output := pushOutput
+	client := &docker.MockDocker{PushOutput: &output}
+
+	_, err := (&Gitlab{}).PushImage(client, "dummy", "repo/image:abc123")
+
+	assert.NoError(t, err)
+	frames := strings.Split(out.String(), "\x1b[J")
+	assert.Equal(t, 11, len(frames))
+	assert.Equal(t, "\x1b[4A\x1b[Krepo/image:abc123: 15kB of 15kB uploaded, 1 of 2 layers pushed, 1 already existed, 1.875kB/s\n"+
+		"\x1b[K  cb13bd9b95b6: Pushing              [==================================================>]     12kB\n"+
+		"\x1b[K  5905e8d02856: Pushed\n"+
+		"\x1b[K  6096558c3d50: Layer already exists\n", frames[7])
+	assert.Equal(t, "\x1b[4A\x1b[Krepo/image:abc123: 15kB uploaded in 10s, 2 layers pushed, 1 already existed, 1.5kB/s\n", frames[9])
+	assert.Equal(t, "", frames[10])
+}
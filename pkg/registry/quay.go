@@ -27,6 +27,10 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/docker/docker/api/types/registry"
@@ -34,11 +38,24 @@ import (
 	"github.com/buildtool/build-tools/pkg/docker"
 )
 
+// quayApi is the base URL of the Quay API
+var quayApi = "https://quay.io/api/v1"
+
 type Quay struct {
 	dockerRegistry `yaml:"-"`
-	Repository     string `yaml:"repository" env:"QUAY_REPOSITORY"`
-	Username       string `yaml:"username" env:"QUAY_USERNAME"`
-	Password       string `yaml:"password" env:"QUAY_PASSWORD"`
+	// Repository is the namespace, i.e. the organization or user, of the images
+	Repository string `yaml:"repository" env:"QUAY_REPOSITORY"`
+	// Username is a user or a robot account, like org+robot, with Password being the robot token
+	Username string `yaml:"username" env:"QUAY_USERNAME"`
+	Password string `yaml:"password" env:"QUAY_PASSWORD"`
+	// Token is an OAuth access token of an application in the organization, used to create repositories
+	Token             string `yaml:"token,omitempty" env:"QUAY_TOKEN"`
+	CredentialProcess string `yaml:"credentialProcess,omitempty" env:"QUAY_CREDENTIAL_PROCESS"`
+	// Visibility of created repositories, private or public, defaults to private
+	Visibility string `yaml:"visibility,omitempty"`
+	// Description of created repositories
+	Description string `yaml:"description,omitempty"`
+	client      *http.Client
 }
 
 var _ Registry = &Quay{}
@@ -52,7 +69,14 @@ func (r *Quay) Configured() bool {
 }
 
 func (r *Quay) Login(client docker.Client) error {
-	if ok, err := client.RegistryLogin(context.Background(), r.GetAuthConfig()); err == nil {
+	auth, err := r.credentials(r.resolve)
+	if err != nil {
+		return err
+	}
+	if robot, ok := r.robot(); ok {
+		log.Debugf("Using robot account <green>%s</green>\n", robot)
+	}
+	if ok, err := client.RegistryLogin(context.Background(), auth); err == nil {
 		log.Debugf("%s\n", ok.Status)
 		return nil
 	} else {
@@ -60,19 +84,78 @@ func (r *Quay) Login(client docker.Client) error {
 	}
 }
 
-func (r Quay) GetAuthConfig() registry.AuthConfig {
-	return registry.AuthConfig{Username: r.Username, Password: r.Password, ServerAddress: "quay.io"}
+func (r *Quay) GetAuthConfig() registry.AuthConfig {
+	return r.cachedCredentials(r.resolve)
 }
 
-func (r Quay) GetAuthInfo() string {
+func (r *Quay) resolve() (registry.AuthConfig, *time.Time, error) {
+	return resolveCredentials(registry.AuthConfig{Username: r.Username, Password: r.Password, ServerAddress: "quay.io"}, r.CredentialProcess, "quay.io")
+}
+
+func (r *Quay) GetAuthInfo() string {
 	authBytes, _ := json.Marshal(r.GetAuthConfig())
 	return base64.URLEncoding.EncodeToString(authBytes)
 }
 
-func (r Quay) RegistryUrl() string {
+func (r *Quay) RegistryUrl() string {
 	return fmt.Sprintf("quay.io/%s", r.Repository)
 }
 
+// robot returns the name of the robot account and true if Username is one
+func (r *Quay) robot() (string, bool) {
+	namespace, _, found := strings.Cut(r.Username, "+")
+	if !found {
+		return "", false
+	}
+	if namespace != r.Repository {
+		log.Warnf("<yellow>Robot account '%s' belongs to '%s', not '%s'</yellow>\n", r.Username, namespace, r.Repository)
+	}
+	return r.Username, true
+}
+
+// Create creates the repository using the Quay API if missing. Without a Token, repositories
+// are expected to be created when pushed to, which robot accounts are usually not allowed to.
 func (r *Quay) Create(repository string) error {
+	if r.Token == "" {
+		log.Debugf("No OAuth token configured for Quay, not creating repository '<green>%s</green>'\n", repository)
+		return nil
+	}
+	visibility := r.Visibility
+	if visibility == "" {
+		visibility = "private"
+	}
+	if visibility != "private" && visibility != "public" {
+		return fmt.Errorf("invalid visibility %s, must be private or public", visibility)
+	}
+	status, err := r.api().request(http.MethodGet, fmt.Sprintf("/repository/%s/%s", url.PathEscape(r.Repository), url.PathEscape(repository)), nil, nil)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusOK {
+		return nil
+	}
+	if status != http.StatusNotFound {
+		return fmt.Errorf("unable to check if repository %s exists: unexpected status %d", repository, status)
+	}
+
+	log.Debugf("Creating %s repository '<green>%s/%s</green>' in Quay\n", visibility, r.Repository, repository)
+	status, err = r.api().request(http.MethodPost, "/repository", map[string]string{
+		"namespace":   r.Repository,
+		"repository":  repository,
+		"visibility":  visibility,
+		"description": r.Description,
+		"repo_kind":   "image",
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusCreated {
+		return fmt.Errorf("unable to create repository %s: unexpected status %d", repository, status)
+	}
 	return nil
 }
+
+// api returns a client for the Quay API, authenticated with the token
+func (r *Quay) api() apiClient {
+	return apiClient{client: r.client, baseUrl: quayApi, auth: bearer(r.Token)}
+}
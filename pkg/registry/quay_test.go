@@ -24,6 +24,7 @@ package registry
 
 import (
 	"fmt"
+	"net/http"
 	"testing"
 
 	"github.com/apex/log"
@@ -71,3 +72,120 @@ func TestQuay_Create(t *testing.T) {
 	err := registry.Create("repo")
 	assert.Nil(t, err)
 }
+
+func newQuayServer(t *testing.T, exists bool) *apiServer {
+	s := newApiServer(t, exists)
+	s.authorized = func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Bearer token"
+	}
+	s.respond = func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"namespace":"org","name":"repo"}`)
+	}
+	s.Start()
+	previous := quayApi
+	quayApi = s.URL + "/api/v1"
+	t.Cleanup(func() { quayApi = previous })
+	return s
+}
+
+func TestQuay_LoginRobot(t *testing.T) {
+	client := &docker.MockDocker{}
+	registry := &Quay{Repository: "org", Username: "org+builder", Password: "robot-token"}
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+
+	err := registry.Login(client)
+	assert.NoError(t, err)
+	assert.Equal(t, "org+builder", client.Username)
+	assert.Equal(t, "robot-token", client.Password)
+	logMock.Check(t, []string{"debug: Using robot account <green>org+builder</green>\n", "debug: Logged in\n"})
+}
+
+func TestQuay_LoginRobotOtherNamespace(t *testing.T) {
+	client := &docker.MockDocker{}
+	registry := &Quay{Repository: "org", Username: "other+builder", Password: "robot-token"}
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+
+	err := registry.Login(client)
+	assert.NoError(t, err)
+	logMock.Check(t, []string{
+		"warn: <yellow>Robot account 'other+builder' belongs to 'other', not 'org'</yellow>\n",
+		"debug: Using robot account <green>other+builder</green>\n",
+		"debug: Logged in\n",
+	})
+}
+
+func TestQuay_CreateWithoutToken(t *testing.T) {
+	registry := &Quay{Repository: "org"}
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	err := registry.Create("repo")
+	assert.NoError(t, err)
+	logMock.Check(t, []string{"debug: No OAuth token configured for Quay, not creating repository '<green>repo</green>'\n"})
+}
+
+func TestQuay_CreateExisting(t *testing.T) {
+	server := newQuayServer(t, true)
+	registry := &Quay{Repository: "org", Token: "token", client: server.Client()}
+	err := registry.Create("repo")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"GET /api/v1/repository/org/repo"}, server.requests)
+}
+
+func TestQuay_CreateNew(t *testing.T) {
+	server := newQuayServer(t, false)
+	registry := &Quay{Repository: "org", Token: "token", Description: "The repo", client: server.Client()}
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	err := registry.Create("repo")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"GET /api/v1/repository/org/repo", "POST /api/v1/repository"}, server.requests)
+	assert.Equal(t, map[string]interface{}{
+		"namespace":   "org",
+		"repository":  "repo",
+		"visibility":  "private",
+		"description": "The repo",
+		"repo_kind":   "image",
+	}, server.bodies["POST /api/v1/repository"])
+	logMock.Check(t, []string{"debug: Creating private repository '<green>org/repo</green>' in Quay\n"})
+}
+
+func TestQuay_CreatePublic(t *testing.T) {
+	server := newQuayServer(t, false)
+	log.SetHandler(mocks.New())
+	registry := &Quay{Repository: "org", Token: "token", Visibility: "public", client: server.Client()}
+	err := registry.Create("repo")
+	assert.NoError(t, err)
+	assert.Equal(t, "public", server.bodies["POST /api/v1/repository"]["visibility"])
+}
+
+func TestQuay_CreateInvalidVisibility(t *testing.T) {
+	registry := &Quay{Repository: "org", Token: "token", Visibility: "internal"}
+	err := registry.Create("repo")
+	assert.EqualError(t, err, "invalid visibility internal, must be private or public")
+}
+
+func TestQuay_CreateUnauthorized(t *testing.T) {
+	server := newQuayServer(t, false)
+	registry := &Quay{Repository: "org", Token: "wrong", client: server.Client()}
+	err := registry.Create("repo")
+	assert.EqualError(t, err, "unable to check if repository repo exists: unexpected status 401")
+}
+
+func TestQuay_CreateForbidden(t *testing.T) {
+	server := newQuayServer(t, false)
+	log.SetHandler(mocks.New())
+	server.createStatus = http.StatusForbidden
+	registry := &Quay{Repository: "org", Token: "token", client: server.Client()}
+	err := registry.Create("repo")
+	assert.EqualError(t, err, "unable to create repository repo: unexpected status 403")
+}
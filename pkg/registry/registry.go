@@ -27,6 +27,9 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/apex/log"
 	img "github.com/docker/docker/api/types/image"
@@ -43,7 +46,22 @@ type Registry interface {
 	GetAuthInfo() string
 	RegistryUrl() string
 	Create(repository string) error
-	PushImage(client docker.Client, auth, image string) error
+	// PushImage pushes the image and returns the digest reported by the daemon
+	PushImage(client docker.Client, auth, image string) (string, error)
+}
+
+// PathResolver is implemented by registries with settings referring to files, relative paths are
+// resolved against the directory of the configuration file the registry is configured in
+type PathResolver interface {
+	ResolvePaths(dir string)
+}
+
+// resolvePath returns the path relative to dir, unless it's absolute or inline JSON
+func resolvePath(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) || strings.HasPrefix(strings.TrimSpace(path), "{") {
+		return path
+	}
+	return filepath.Join(dir, path)
 }
 
 type responsetype struct {
@@ -65,26 +83,38 @@ type responsetype struct {
 	} `json:"aux"`
 }
 
-type dockerRegistry struct{}
+type dockerRegistry struct {
+	// mu guards resolved, as images are pushed concurrently
+	mu sync.Mutex
+	// resolved are the credentials of the registry, see cachedCredentials
+	resolved *resolvedCredentials
+}
 
-func (dockerRegistry) PushImage(client docker.Client, auth, image string) error {
+func (*dockerRegistry) PushImage(client docker.Client, auth, image string) (string, error) {
 	if out, err := client.ImagePush(context.Background(), image, img.PushOptions{All: true, RegistryAuth: auth}); err != nil {
-		return err
+		return "", err
 	} else {
+		digest := ""
+		progress := display.start(image)
+		defer display.finish(progress)
 		scanner := bufio.NewScanner(out)
 		for scanner.Scan() {
 			r := &responsetype{}
 			response := scanner.Bytes()
 			if err := json.Unmarshal(response, &r); err != nil {
 				log.Errorf("Unable to parse response: %s, Error: %v\n", string(response), err)
-				return err
+				return "", err
 			} else {
 				if r.ErrorDetail != nil {
-					return errors.New(r.ErrorDetail.Message)
+					return "", errors.New(r.ErrorDetail.Message)
+				}
+				if r.Aux != nil && r.Aux.Digest != "" {
+					digest = r.Aux.Digest
 				}
+				display.update(progress, r)
 			}
 		}
 
-		return nil
+		return digest, nil
 	}
 }
@@ -35,6 +35,26 @@ func TestDockerRegistry_PushImage(t *testing.T) {
 	registry := &Gitlab{}
 	client := &docker.MockDocker{PushError: errors.New("error")}
 
-	err := registry.PushImage(client, "dummy", "unknown")
+	_, err := registry.PushImage(client, "dummy", "unknown")
 	assert.EqualError(t, err, "error")
 }
+
+func TestDockerRegistry_PushImage_Digest(t *testing.T) {
+	registry := &Gitlab{}
+	output := `{"status":"Pushed","progressDetail":{},"id":"cb13bd9b95b6"}
+{"progressDetail":{},"aux":{"Tag":"abc123","Digest":"sha256:af534ee896ce2ac80f3413318329e45e3b3e74b89eb337b9364b8ac1e83498b7","Size":2828}}
+`
+	client := &docker.MockDocker{PushOutput: &output}
+
+	digest, err := registry.PushImage(client, "dummy", "repo/image:abc123")
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:af534ee896ce2ac80f3413318329e45e3b3e74b89eb337b9364b8ac1e83498b7", digest)
+}
+
+func TestHost(t *testing.T) {
+	assert.Equal(t, "docker.io", Host(&Dockerhub{Namespace: "namespace"}))
+	assert.Equal(t, "quay.io", Host(&Quay{Repository: "org"}))
+	assert.Equal(t, "123456789012.dkr.ecr.eu-west-1.amazonaws.com", Host(&ECR{Url: "123456789012.dkr.ecr.eu-west-1.amazonaws.com"}))
+	assert.Equal(t, "localhost:5000", Host(&Generic{Url: "localhost:5000/team"}))
+	assert.Equal(t, "localhost", Host(&Generic{Url: "localhost/team"}))
+}
@@ -0,0 +1,132 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package registry
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/registry"
+	"github.com/google/go-containerregistry/pkg/authn"
+	refname "github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"github.com/buildtool/build-tools/pkg/docker"
+)
+
+// credentialsFetcher is implemented by registries which must fetch credentials
+// before GetAuthConfig returns anything useful
+type credentialsFetcher interface {
+	fetchCredentials() error
+}
+
+// credentialsResolver is implemented by registries resolving their credentials once, see cachedCredentials
+type credentialsResolver interface {
+	// resolve returns the configured credentials of the registry, those of its credential process or
+	// those stored by docker login, and when they expire
+	resolve() (registry.AuthConfig, *time.Time, error)
+	credentials(resolve func() (registry.AuthConfig, *time.Time, error)) (registry.AuthConfig, error)
+}
+
+// transportProvider is implemented by registries which need a custom transport for the registry API,
+// for example with additional certificates. The transport is available after Authenticate.
+type transportProvider interface {
+	transport() http.RoundTripper
+}
+
+// transportConfigurer is implemented by registries which must set up their transport before it is used
+type transportConfigurer interface {
+	configureTransport() error
+}
+
+// insecureProvider is implemented by registries which may be accessed over plain HTTP
+type insecureProvider interface {
+	insecure() bool
+}
+
+// Authenticate makes the credentials for the registry available without
+// logging in to a Docker daemon, for use with the registry API directly
+func Authenticate(r Registry) error {
+	if t, ok := r.(transportConfigurer); ok {
+		if err := t.configureTransport(); err != nil {
+			return err
+		}
+	}
+	if f, ok := r.(credentialsFetcher); ok {
+		if err := f.fetchCredentials(); err != nil {
+			return err
+		}
+	}
+	if c, ok := r.(credentialsResolver); ok {
+		_, err := c.credentials(c.resolve)
+		return err
+	}
+	return nil
+}
+
+// Authenticator returns an authenticator for the registry API using the credentials of the registry
+func Authenticator(r Registry) authn.Authenticator {
+	auth := r.GetAuthConfig()
+	if !docker.HasCredentials(auth) {
+		return authn.Anonymous
+	}
+	return authn.FromConfig(authn.AuthConfig{
+		Username:      auth.Username,
+		Password:      auth.Password,
+		Auth:          auth.Auth,
+		IdentityToken: auth.IdentityToken,
+		RegistryToken: auth.RegistryToken,
+	})
+}
+
+// RemoteOptions returns the options to use for registry API calls against the registry
+func RemoteOptions(ctx context.Context, r Registry) []remote.Option {
+	options := []remote.Option{
+		remote.WithContext(ctx),
+		remote.WithAuth(Authenticator(r)),
+		remote.WithUserAgent("buildtools"),
+	}
+	if p, ok := r.(transportProvider); ok && p.transport() != nil {
+		options = append(options, remote.WithTransport(p.transport()))
+	}
+	return options
+}
+
+// Host returns the host of the registry, registries without a host in their url are on Docker Hub
+func Host(r Registry) string {
+	host, _, _ := strings.Cut(r.RegistryUrl(), "/")
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return "docker.io"
+	}
+	return host
+}
+
+// NameOptions returns the options to use when parsing references to images in the registry
+func NameOptions(r Registry) []refname.Option {
+	if p, ok := r.(insecureProvider); ok && p.insecure() {
+		return []refname.Option{refname.Insecure}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package retag
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/apex/log"
+	refname "github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"github.com/buildtool/build-tools/pkg/args"
+	"github.com/buildtool/build-tools/pkg/ci"
+	"github.com/buildtool/build-tools/pkg/config"
+	"github.com/buildtool/build-tools/pkg/docker"
+	"github.com/buildtool/build-tools/pkg/registry"
+	"github.com/buildtool/build-tools/pkg/version"
+)
+
+type Args struct {
+	args.Globals
+	Tags   []string `arg:"" name:"tags" help:"the tags to add to the image"`
+	Source string   `name:"source" help:"the tag of the image to retag, defaults to the commit evaluated from CI or VCS" default:""`
+}
+
+func DoRetag(dir string, info version.Info, osArgs ...string) int {
+	var retagArgs Args
+	err := args.ParseArgs(dir, osArgs, info, &retagArgs)
+	if err != nil {
+		if err != args.Done {
+			return -1
+		} else {
+			return 0
+		}
+	}
+
+	cfg, err := config.Load(dir)
+	if err != nil {
+		log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+		return -2
+	}
+	return retag(cfg, retagArgs)
+}
+
+func retag(cfg *config.Config, retagArgs Args) int {
+	currentCI := cfg.CurrentCI()
+	source := retagArgs.Source
+	if source == "" {
+		if !ci.IsValid(currentCI) || currentCI.Commit() == "" {
+			log.Error("Commit and/or branch information is <red>missing</red>. Perhaps your not in a Git repository or forgot to set environment variables?")
+			return -3
+		}
+		source = currentCI.Commit()
+	}
+
+	currentRegistry := cfg.CurrentRegistry()
+	log.Debugf("Using registry <green>%s</green>\n", currentRegistry.Name())
+	if err := registry.Authenticate(currentRegistry); err != nil {
+		log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+		return -4
+	}
+	options := registry.RemoteOptions(context.Background(), currentRegistry)
+
+	nameOptions := registry.NameOptions(currentRegistry)
+	sourceRef, err := refname.ParseReference(docker.Tag(currentRegistry.RegistryUrl(), currentCI.BuildName(), source), nameOptions...)
+	if err != nil {
+		log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+		return -5
+	}
+	desc, err := remote.Get(sourceRef, options...)
+	if err != nil {
+		log.Error(fmt.Sprintf("<red>unable to find image %s: %s</red>", sourceRef, err.Error()))
+		return -5
+	}
+	for _, tag := range retagArgs.Tags {
+		target, err := refname.NewTag(docker.Tag(currentRegistry.RegistryUrl(), currentCI.BuildName(), tag), nameOptions...)
+		if err != nil {
+			log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+			return -6
+		}
+		log.Infof("Tagging '<green>%s</green>' as '<green>%s</green>'\n", sourceRef, target)
+		if err := remote.Tag(target, desc, options...); err != nil {
+			log.Error(fmt.Sprintf("<red>%s</red>", err.Error()))
+			return -6
+		}
+	}
+	log.Debugf("Tagged digest <green>%s</green>\n", desc.Digest)
+	return 0
+}
@@ -0,0 +1,184 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package retag
+
+import (
+	"fmt"
+	"io"
+	stdlog "log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	refname "github.com/google/go-containerregistry/pkg/name"
+	ggcr "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/assert"
+	mocks "gitlab.com/unboundsoftware/apex-mocks"
+
+	"github.com/buildtool/build-tools/pkg/config"
+	"github.com/buildtool/build-tools/pkg/vcs"
+	"github.com/buildtool/build-tools/pkg/version"
+)
+
+// setupRegistry starts an in-memory registry with an image tagged abc123 in repo/reponame
+func setupRegistry(t *testing.T) (string, v1.Hash) {
+	server := httptest.NewServer(ggcr.New(ggcr.Logger(stdlog.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "http://")
+	img, err := random.Image(1024, 1)
+	assert.NoError(t, err)
+	ref, err := refname.ParseReference(fmt.Sprintf("%s/repo/reponame:abc123", host))
+	assert.NoError(t, err)
+	assert.NoError(t, remote.Write(ref, img))
+	digest, err := img.Digest()
+	assert.NoError(t, err)
+	return host, digest
+}
+
+func testConfig(host string) *config.Config {
+	cfg := config.InitEmptyConfig()
+	cfg.CI.Gitlab.CIBuildName = "reponame"
+	cfg.CI.Gitlab.CICommit = "abc123"
+	cfg.CI.Gitlab.CIBranchName = "main"
+	cfg.Registry.Dockerhub.Namespace = fmt.Sprintf("%s/repo", host)
+	return cfg
+}
+
+func assertDigest(t *testing.T, image string, expected v1.Hash) {
+	ref, err := refname.ParseReference(image)
+	assert.NoError(t, err)
+	desc, err := remote.Head(ref)
+	if assert.NoError(t, err) {
+		assert.Equal(t, expected, desc.Digest)
+	}
+}
+
+func TestRetag(t *testing.T) {
+	host, digest := setupRegistry(t)
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+
+	exitCode := retag(testConfig(host), Args{Tags: []string{"prod", "release/2024-10"}})
+
+	assert.Equal(t, 0, exitCode)
+	assertDigest(t, fmt.Sprintf("%s/repo/reponame:prod", host), digest)
+	assertDigest(t, fmt.Sprintf("%s/repo/reponame:release2024-10", host), digest)
+	logMock.Check(t, []string{
+		"debug: Using registry <green>Dockerhub</green>\n",
+		fmt.Sprintf("info: Tagging '<green>%s/repo/reponame:abc123</green>' as '<green>%s/repo/reponame:prod</green>'\n", host, host),
+		"debug: <yellow>Warning: tag was changed from 'release/2024-10' to 'release2024-10' due to Dockers rules.</yellow>\n",
+		fmt.Sprintf("info: Tagging '<green>%s/repo/reponame:abc123</green>' as '<green>%s/repo/reponame:release2024-10</green>'\n", host, host),
+		fmt.Sprintf("debug: Tagged digest <green>%s</green>\n", digest),
+	})
+}
+
+func TestRetag_Source(t *testing.T) {
+	host, digest := setupRegistry(t)
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.InfoLevel)
+	cfg := testConfig(host)
+	cfg.CI.Gitlab.CICommit = "def456"
+
+	exitCode := retag(cfg, Args{Tags: []string{"prod"}, Source: "abc123"})
+
+	assert.Equal(t, 0, exitCode)
+	assertDigest(t, fmt.Sprintf("%s/repo/reponame:prod", host), digest)
+	logMock.Check(t, []string{
+		fmt.Sprintf("info: Tagging '<green>%s/repo/reponame:abc123</green>' as '<green>%s/repo/reponame:prod</green>'\n", host, host),
+	})
+}
+
+func TestRetag_MissingSource(t *testing.T) {
+	host, _ := setupRegistry(t)
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.ErrorLevel)
+
+	exitCode := retag(testConfig(host), Args{Tags: []string{"prod"}, Source: "missing"})
+
+	assert.Equal(t, -5, exitCode)
+	assert.Equal(t, 1, len(logMock.Logged))
+	assert.True(t, strings.HasPrefix(logMock.Logged[0], fmt.Sprintf("error: <red>unable to find image %s/repo/reponame:missing: ", host)), logMock.Logged[0])
+}
+
+func TestRetag_NoCommit(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+	cfg := config.InitEmptyConfig()
+	cfg.VCS.VCS = &no{}
+
+	exitCode := retag(cfg, Args{Tags: []string{"prod"}})
+
+	assert.Equal(t, -3, exitCode)
+	logMock.Check(t, []string{
+		"error: Commit and/or branch information is <red>missing</red>. Perhaps your not in a Git repository or forgot to set environment variables?",
+	})
+}
+
+func TestDoRetag_BrokenConfig(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.WriteFile(filepath.Join(dir, ".buildtools.yaml"), []byte(`ci: []`), 0666)
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+	log.SetLevel(log.DebugLevel)
+
+	exitCode := DoRetag(dir, version.Info{}, "prod")
+
+	assert.Equal(t, -2, exitCode)
+	logMock.Check(t, []string{
+		fmt.Sprintf("debug: Parsing config from file: <green>'%s'</green>\n", filepath.Join(dir, ".buildtools.yaml")),
+		"error: <red>yaml: unmarshal errors:\n  line 1: cannot unmarshal !!seq into config.CIConfig</red>",
+	})
+}
+
+func TestDoRetag_MissingTags(t *testing.T) {
+	logMock := mocks.New()
+	log.SetHandler(logMock)
+
+	exitCode := DoRetag(t.TempDir(), version.Info{})
+
+	assert.Equal(t, -1, exitCode)
+}
+
+type no struct {
+	vcs.CommonVCS
+}
+
+func (v no) Identify(string) bool {
+	return true
+}
+
+func (v no) Name() string {
+	return "none"
+}
+
+var _ vcs.VCS = &no{}
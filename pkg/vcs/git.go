@@ -23,8 +23,11 @@
 package vcs
 
 import (
+	"strings"
+
 	"github.com/apex/log"
 	git2 "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 )
 
 type git struct {
@@ -50,6 +53,34 @@ func (v *git) Identify(dir string) bool {
 	return true
 }
 
+// Branches returns the local branches and the branches of all remotes, remote branches
+// are named without the name of the remote
+func (v *git) Branches() map[string]string {
+	branches := make(map[string]string)
+	if v.repo == nil {
+		return branches
+	}
+	refs, err := v.repo.References()
+	if err != nil {
+		log.Debugf("Unable to list references: %s\n", err)
+		return branches
+	}
+	_ = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		if ref.Name().IsBranch() {
+			branches[ref.Name().Short()] = ref.Hash().String()
+		} else if ref.Name().IsRemote() {
+			if _, branch, found := strings.Cut(strings.TrimPrefix(ref.Name().String(), "refs/remotes/"), "/"); found {
+				branches[branch] = ref.Hash().String()
+			}
+		}
+		return nil
+	})
+	return branches
+}
+
 func (v *git) Name() string {
 	return "Git"
 }
@@ -0,0 +1,59 @@
+// MIT License
+//
+// Copyright (c) 2018 buildtool
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package vcs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	git2 "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGit_Branches(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := git2.PlainInit(dir, false)
+	assert.NoError(t, err)
+	tree, err := repo.Worktree()
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "file"), []byte("test"), 0666))
+	_, err = tree.Add("file")
+	assert.NoError(t, err)
+	hash, err := tree.Commit("Test", &git2.CommitOptions{Author: &object.Signature{Email: "test@example.com"}})
+	assert.NoError(t, err)
+	assert.NoError(t, repo.Storer.SetReference(plumbing.NewHashReference("refs/heads/feature/local", hash)))
+	assert.NoError(t, repo.Storer.SetReference(plumbing.NewHashReference("refs/remotes/origin/feature/remote", hash)))
+	assert.NoError(t, repo.Storer.SetReference(plumbing.NewSymbolicReference("refs/remotes/origin/HEAD", "refs/remotes/origin/feature/remote")))
+
+	vcs := &git{}
+	assert.True(t, vcs.Identify(dir))
+
+	assert.Equal(t, map[string]string{
+		"master":         hash.String(),
+		"feature/local":  hash.String(),
+		"feature/remote": hash.String(),
+	}, vcs.Branches())
+}
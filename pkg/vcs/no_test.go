@@ -43,3 +43,9 @@ func TestNo_Name(t *testing.T) {
 
 	assert.Equal(t, "none", vcs.Name())
 }
+
+func TestNo_Branches(t *testing.T) {
+	vcs := &no{}
+
+	assert.Empty(t, vcs.Branches())
+}
@@ -32,6 +32,8 @@ type VCS interface {
 	Branch() string
 	// Commit returns the current commit
 	Commit() string
+	// Branches returns the known local and remote branches with the commit at their head
+	Branches() map[string]string
 }
 
 // CommonVCS contains functions shared by all VCSs
@@ -50,6 +52,11 @@ func (v CommonVCS) Commit() string {
 	return v.CurrentCommit
 }
 
+// Branches returns no branches, for VCSs without branch information
+func (v CommonVCS) Branches() map[string]string {
+	return map[string]string{}
+}
+
 var systems = []VCS{&git{}}
 
 // Identify tries to identify the actual VCS
@@ -23,8 +23,9 @@
 package vcs
 
 type mockVcs struct {
-	branch string
-	commit string
+	branch   string
+	commit   string
+	branches map[string]string
 }
 
 // NewMockVcs returns a mockVcs with default commit and branch name
@@ -42,6 +43,16 @@ func NewMockVcsWithBranch(branch string) VCS {
 		commit: "fallback-sha",
 	}
 }
+
+// NewMockVcsWithBranches returns a mockVcs on branch main with the known branches
+func NewMockVcsWithBranches(branches map[string]string) VCS {
+	return &mockVcs{
+		branch:   "main",
+		commit:   branches["main"],
+		branches: branches,
+	}
+}
+
 func (m mockVcs) Identify(dir string) bool {
 	panic("implement me")
 }
@@ -58,4 +69,8 @@ func (m mockVcs) Commit() string {
 	return m.commit
 }
 
+func (m mockVcs) Branches() map[string]string {
+	return m.branches
+}
+
 var _ VCS = mockVcs{}